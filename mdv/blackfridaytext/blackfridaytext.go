@@ -20,9 +20,11 @@ package blackfridaytext
 import (
 	"bytes"
 	"fmt"
+	"html"
 	"strings"
+	"unicode/utf8"
 
-	"github.com/gholt/brimtext"
+	"github.com/rn/utils/mdv/brimtext"
 	"github.com/russross/blackfriday"
 )
 
@@ -45,6 +47,85 @@ type Options struct {
 	HeaderPrefix []byte
 	// HeaderSuffix is the suffix after any header line.
 	HeaderSuffix []byte
+	// HeaderStyles optionally gives the style to use for each header level;
+	// the first entry is for level 1 headers, the second for level 2, etc.
+	// Levels without an entry, or with a nil entry, use HeaderPrefix and
+	// HeaderSuffix.
+	HeaderStyles []*HeaderStyle
+	// HeaderIndent is the indent added to content for each header level. If
+	// nil, four spaces are used; set to an empty, non-nil value to disable
+	// indenting content under headers.
+	HeaderIndent []byte
+	// NumberHeaders set true will prefix headers with hierarchical numbers,
+	// such as 1, 1.1, 1.1.2.
+	NumberHeaders bool
+	// Images selects how local images are rendered; images which are remote,
+	// missing or can't be decoded always use the "[alt] path" placeholder.
+	Images ImageMode
+	// Math set true will render TeX math, $...$ and $$...$$, as Unicode.
+	Math bool
+	// Smartypants set true will use typographic quotes, dashes and ellipses,
+	// such as “quoted” for "quoted", – for -- and … for ...
+	Smartypants bool
+	// Emoji selects how GitHub style :shortcode: emoji are rendered.
+	Emoji EmojiMode
+	// ImageDir is the directory relative image paths are resolved against,
+	// usually the directory of the markdown file. If empty, the current
+	// directory is used.
+	ImageDir string
+}
+
+// HeaderStyle controls the decoration of a single header level.
+type HeaderStyle struct {
+	// Prefix is the prefix before the header line.
+	Prefix []byte
+	// Suffix is the suffix after the header line.
+	Suffix []byte
+	// Color is the ANSI Escape Code used for the header text if color is
+	// enabled; ANSIEscape.Bold is used if nil.
+	Color []byte
+	// Underline, if not 0, is repeated on the line below the header for the
+	// length of the header, like Setext "===" and "---" headers.
+	Underline byte
+	// Rule, if not 0, is repeated on the line below the header for the full
+	// width.
+	Rule byte
+	// Box, if set, draws a box around the header. It must contain six
+	// characters: the top left corner, the horizontal line, the top right
+	// corner, the vertical line, the bottom left corner, and the bottom right
+	// corner, such as "+-+|++" or "┌─┐│└┘".
+	Box string
+}
+
+// NewSetextHeaderStyles returns header styles which underline level 1 headers
+// with "=" and level 2 headers with "-", like Setext headers, in bold cyan,
+// bold and cyan respectively for levels 1 to 3.
+func NewSetextHeaderStyles() []*HeaderStyle {
+	return []*HeaderStyle{
+		{Underline: '=', Color: boldCyan()},
+		{Underline: '-', Color: brimtext.ANSIEscape.Bold},
+		{Color: brimtext.ANSIEscape.FCyan},
+	}
+}
+
+// NewBoxedHeaderStyles returns header styles which draw a box around level 1
+// headers, a rule the full width under level 2 headers and underline level 3
+// headers, in bold cyan, bold and cyan respectively. If unicode is true, box
+// drawing characters are used, otherwise plain ASCII.
+func NewBoxedHeaderStyles(unicode bool) []*HeaderStyle {
+	box := "+-+|++"
+	if unicode {
+		box = "┌─┐│└┘"
+	}
+	return []*HeaderStyle{
+		{Box: box, Color: boldCyan()},
+		{Rule: '-', Color: brimtext.ANSIEscape.Bold},
+		{Underline: '-', Color: brimtext.ANSIEscape.FCyan},
+	}
+}
+
+func boldCyan() []byte {
+	return append(append([]byte{}, brimtext.ANSIEscape.Bold...), brimtext.ANSIEscape.FCyan...)
 }
 
 func resolveOpts(opts *Options) *Options {
@@ -71,9 +152,22 @@ func resolveOpts(opts *Options) *Options {
 	if ropts.HeaderSuffix == nil {
 		ropts.HeaderSuffix = []byte("]--")
 	}
+	if ropts.HeaderIndent == nil {
+		ropts.HeaderIndent = []byte("    ")
+	}
 	return ropts
 }
 
+// extensions are the Blackfriday extensions used for all parsing done by this
+// package.
+const extensions = blackfriday.EXTENSION_NO_INTRA_EMPHASIS |
+	blackfriday.EXTENSION_TABLES |
+	blackfriday.EXTENSION_FENCED_CODE |
+	blackfriday.EXTENSION_AUTOLINK |
+	blackfriday.EXTENSION_STRIKETHROUGH |
+	blackfriday.EXTENSION_DEFINITION_LISTS |
+	blackfriday.EXTENSION_AUTO_HEADER_IDS
+
 const (
 	_               byte = iota // 0 NUL
 	markLineBreak               // 1 SOH
@@ -159,25 +253,59 @@ func MarkdownMetadata(markdown []byte) ([][]string, int) {
 // will be used.
 func MarkdownToTextNoMetadata(markdown []byte, opts *Options) []byte {
 	opts = resolveOpts(opts)
-	rend := &renderer{
+	return renderText(markdown, opts, newRenderer(opts))
+}
+
+func newRenderer(opts *Options) *renderer {
+	return &renderer{
 		width:             opts.Width,
 		color:             opts.Color,
 		tableAlignOptions: opts.TableAlignOptions,
 		headerPrefix:      opts.HeaderPrefix,
 		headerSuffix:      opts.HeaderSuffix,
+		headerStyles:      opts.HeaderStyles,
+		headerIndent:      opts.HeaderIndent,
+		numberHeaders:     opts.NumberHeaders,
+		images:            opts.Images,
+		imageDir:          opts.ImageDir,
+		smartypants:       opts.Smartypants,
 	}
-	markdown = bytes.Replace(markdown, []byte("\n///\n"), []byte(""), -1)
-	txt := blackfriday.Markdown(markdown, rend,
-		blackfriday.EXTENSION_NO_INTRA_EMPHASIS|
-			blackfriday.EXTENSION_TABLES|
-			blackfriday.EXTENSION_FENCED_CODE|
-			blackfriday.EXTENSION_AUTOLINK|
-			blackfriday.EXTENSION_STRIKETHROUGH|
-			blackfriday.EXTENSION_DEFINITION_LISTS)
+}
+
+func renderText(markdown []byte, opts *Options, rend *renderer) []byte {
+	txt := rend.markdown(markdown, opts)
 	for rend.level > 0 {
 		txt = append(txt, markIndentStop)
 		rend.level--
 	}
+	return finishText(txt, opts, rend)
+}
+
+// markdown runs the markdown through Blackfriday with the renderer, leaving
+// the output marked up for finishText.
+func (rend *renderer) markdown(markdown []byte, opts *Options) []byte {
+	return blackfriday.Markdown(rend.prepare(markdown, opts), rend, extensions)
+}
+
+// prepare returns the markdown as given to Blackfriday: without summary
+// marks, with any math extracted and with emoji replaced.
+func (rend *renderer) prepare(markdown []byte, opts *Options) []byte {
+	markdown = removeSummaryMarks(markdown)
+	if opts.Math {
+		markdown = rend.extractMath(markdown)
+	}
+	return replaceEmoji(markdown, opts.Emoji)
+}
+
+// removeSummaryMarks removes the "///" lines that end a summary; see
+// MarkdownMetadata.
+func removeSummaryMarks(markdown []byte) []byte {
+	return bytes.Replace(markdown, []byte("\n///\n"), []byte(""), -1)
+}
+
+// finishText reflows the renderer's output to the width and replaces the
+// marks left in it.
+func finishText(txt []byte, opts *Options, rend *renderer) []byte {
 	if len(txt) > 0 {
 		txt = bytes.Replace(txt, []byte(" \n"), []byte(" "), -1)
 		txt = bytes.Replace(txt, []byte("\n"), []byte(" "), -1)
@@ -197,6 +325,22 @@ type renderer struct {
 	definitionList    [][]byte
 	headerPrefix      []byte
 	headerSuffix      []byte
+	headerStyles      []*HeaderStyle
+	headerIndent      []byte
+	numberHeaders     bool
+	numberer          numberer
+	images            ImageMode
+	imageDir          string
+	smartypants       bool
+	// math holds the math spans found by extractMath, if Options.Math.
+	math []mathSpan
+	// headers, if not nil, collects each header as it is rendered.
+	headers *[]Header
+	// index set true will emit index marks for links and headers; see
+	// MarkdownToTextIndexed.
+	index      bool
+	indexLinks []string
+	codeBlocks int
 }
 
 func (rend *renderer) BlockCode(out *bytes.Buffer, text []byte, lang string) {
@@ -205,7 +349,11 @@ func (rend *renderer) BlockCode(out *bytes.Buffer, text []byte, lang string) {
 		text = text[:length-1]
 	}
 	rend.ensureBlankLine(out)
-	for _, line := range bytes.Split(text, []byte("\n")) {
+	lines := bytes.Split(text, []byte("\n"))
+	for i, line := range lines {
+		if rend.index && i == 0 {
+			out.Write(indexMark(markCodeStart, rend.codeBlocks))
+		}
 		if rend.color {
 			out.Write(brimtext.ANSIEscape.FGreen)
 		}
@@ -213,6 +361,10 @@ func (rend *renderer) BlockCode(out *bytes.Buffer, text []byte, lang string) {
 		if rend.color {
 			out.Write(brimtext.ANSIEscape.Reset)
 		}
+		if rend.index && i == len(lines)-1 {
+			out.Write(indexMark(markCodeEnd, rend.codeBlocks))
+			rend.codeBlocks++
+		}
 		out.WriteByte(markLineBreak)
 	}
 	rend.ensureBlankLine(out)
@@ -243,49 +395,200 @@ func (rend *renderer) Header(out *bytes.Buffer, text func() bool, level int, id
 	level--
 	for rend.level > level {
 		out.WriteByte(markIndentStop)
-		rend.currentIndent -= 4
+		rend.currentIndent -= len(rend.headerIndent)
 		rend.level--
 	}
-	if len(rend.headerPrefix) > 0 {
+	tPos := out.Len()
+	if !text() {
+		out.Truncate(oPos)
+		rend.level = oLevel
+		return
+	}
+	title := make([]byte, out.Len()-tPos)
+	copy(title, out.Bytes()[tPos:])
+	out.Truncate(tPos)
+	number := rend.numberer.next(level + 1)
+	if rend.headers != nil {
+		*rend.headers = append(*rend.headers, Header{
+			Level:  level + 1,
+			Number: number,
+			Text:   plainText(title),
+			ID:     id,
+		})
+	}
+	if !rend.numberHeaders {
+		number = ""
+	}
+	style := &HeaderStyle{Prefix: rend.headerPrefix, Suffix: rend.headerSuffix}
+	if level < len(rend.headerStyles) && rend.headerStyles[level] != nil {
+		style = rend.headerStyles[level]
+	}
+	if rend.index && rend.headers != nil {
+		title = append(indexMark(markHeader, len(*rend.headers)-1), title...)
+	}
+	if utf8.RuneCountInString(style.Box) == 6 {
+		rend.boxedHeader(out, style, number, title)
+	} else {
+		rend.plainHeader(out, style, number, title)
+	}
+	for rend.level <= level {
 		out.WriteByte(markIndentStart)
-		out.Write(rend.headerPrefix)
+		out.Write(rend.headerIndent)
+		out.WriteByte(markIndent1)
+		out.Write(rend.headerIndent)
+		out.WriteByte(markIndent2)
+		rend.currentIndent += len(rend.headerIndent)
+		rend.level++
+	}
+	rend.ensureBlankLine(out)
+}
+
+func (rend *renderer) plainHeader(out *bytes.Buffer, style *HeaderStyle, number string, title []byte) {
+	if len(style.Prefix) > 0 {
+		out.WriteByte(markIndentStart)
+		out.Write(style.Prefix)
 		out.WriteByte(markNBSP)
 		out.WriteByte(markIndent1)
-		for i := 0; i <= len(rend.headerPrefix); i++ {
+		for i := 0; i <= len(style.Prefix); i++ {
 			out.WriteByte(' ')
 		}
 		out.WriteByte(markIndent2)
-		rend.currentIndent += len(rend.headerPrefix) + 1
-	}
-	if rend.color {
-		out.Write(brimtext.ANSIEscape.Bold)
+		rend.currentIndent += len(style.Prefix) + 1
 	}
-	if !text() {
-		out.Truncate(oPos)
-		rend.level = oLevel
-		return
+	rend.writeHeaderColor(out, style)
+	if number != "" {
+		out.WriteString(number)
+		out.WriteByte(markNBSP)
 	}
+	out.Write(title)
 	if rend.color {
 		out.Write(brimtext.ANSIEscape.Reset)
 	}
-	if len(rend.headerSuffix) > 0 {
+	if len(style.Suffix) > 0 {
 		out.WriteByte(markNBSP)
-		out.Write(rend.headerSuffix)
+		out.Write(style.Suffix)
 	}
-	if len(rend.headerPrefix) > 0 {
+	if len(style.Prefix) > 0 {
 		out.WriteByte(markIndentStop)
-		rend.currentIndent -= len(rend.headerPrefix) + 1
+		rend.currentIndent -= len(style.Prefix) + 1
 	}
-	for rend.level <= level {
-		out.WriteByte(markIndentStart)
-		out.WriteString("    ")
-		out.WriteByte(markIndent1)
-		out.WriteString("    ")
-		out.WriteByte(markIndent2)
-		rend.currentIndent += 4
-		rend.level++
+	if style.Underline != 0 {
+		length := brimtext.DisplayWidth(plainText(title))
+		if len(style.Prefix) > 0 {
+			length += utf8.RuneCount(style.Prefix) + 1
+		}
+		if number != "" {
+			length += len(number) + 1
+		}
+		if len(style.Suffix) > 0 {
+			length += utf8.RuneCount(style.Suffix) + 1
+		}
+		if max := rend.width - rend.currentIndent; length > max {
+			length = max
+		}
+		rend.ensureNewLine(out)
+		rend.writeHeaderColor(out, style)
+		out.Write(bytes.Repeat([]byte{style.Underline}, length))
+		if rend.color {
+			out.Write(brimtext.ANSIEscape.Reset)
+		}
+		out.WriteByte(markLineBreak)
+	}
+	if style.Rule != 0 {
+		rend.ensureNewLine(out)
+		out.WriteByte(markHRule)
+		out.WriteByte(style.Rule)
+		out.WriteByte(markLineBreak)
+	}
+}
+
+func (rend *renderer) boxedHeader(out *bytes.Buffer, style *HeaderStyle, number string, title []byte) {
+	box := []rune(style.Box)
+	var line bytes.Buffer
+	if len(style.Prefix) > 0 {
+		line.Write(style.Prefix)
+		line.WriteByte(' ')
+	}
+	if number != "" {
+		line.WriteString(number)
+		line.WriteByte(' ')
+	}
+	line.WriteString(plainText(title))
+	if len(style.Suffix) > 0 {
+		line.WriteByte(' ')
+		line.Write(style.Suffix)
+	}
+	// The box's sides and the spaces inside them take 4 columns.
+	max := rend.width - rend.currentIndent - 4
+	if max < 1 {
+		rend.plainHeader(out, style, number, title)
+		return
+	}
+	rows := wrapRunes(line.String(), max)
+	length := 0
+	for _, row := range rows {
+		if n := brimtext.DisplayWidth(row); n > length {
+			length = n
+		}
+	}
+	horizontal := strings.Repeat(string(box[1]), length+2)
+	rend.ensureNewLine(out)
+	out.WriteString(string(box[0]) + horizontal + string(box[2]))
+	out.WriteByte(markLineBreak)
+	out.Write(indexMarks(title))
+	for _, row := range rows {
+		row += strings.Repeat(" ", length-brimtext.DisplayWidth(row))
+		out.WriteString(string(box[3]))
+		out.WriteByte(markNBSP)
+		rend.writeHeaderColor(out, style)
+		out.Write(bytes.Replace([]byte(row), []byte(" "), []byte{markNBSP}, -1))
+		if rend.color {
+			out.Write(brimtext.ANSIEscape.Reset)
+		}
+		out.WriteByte(markNBSP)
+		out.WriteString(string(box[3]))
+		out.WriteByte(markLineBreak)
+	}
+	out.WriteString(string(box[4]) + horizontal + string(box[5]))
+	out.WriteByte(markLineBreak)
+}
+
+// wrapRunes wraps the text at spaces into lines of at most width runes,
+// breaking words longer than that.
+func wrapRunes(text string, width int) []string {
+	var lines []string
+	var line []rune
+	for _, word := range strings.Fields(text) {
+		w := []rune(word)
+		if len(line) > 0 && len(line)+1+len(w) > width {
+			lines = append(lines, string(line))
+			line = nil
+		}
+		if len(line) > 0 {
+			line = append(line, ' ')
+		}
+		for len(line)+len(w) > width {
+			n := width - len(line)
+			lines = append(lines, string(append(line, w[:n]...)))
+			line, w = nil, w[n:]
+		}
+		line = append(line, w...)
+	}
+	if len(line) > 0 || len(lines) == 0 {
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+func (rend *renderer) writeHeaderColor(out *bytes.Buffer, style *HeaderStyle) {
+	if !rend.color {
+		return
+	}
+	if style.Color != nil {
+		out.Write(style.Color)
+	} else {
+		out.Write(brimtext.ANSIEscape.Bold)
 	}
-	rend.ensureBlankLine(out)
 }
 
 func (rend *renderer) HRule(out *bytes.Buffer) {
@@ -377,9 +680,9 @@ func (rend *renderer) Table(out *bytes.Buffer, header []byte, body []byte, colum
 			} else if columnData[c]&blackfriday.TABLE_ALIGNMENT_RIGHT != 0 {
 				opts.Alignments[c] = brimtext.Right
 			}
-			cellString := string(cell)
-			if len(cellString) > opts.Widths[c] {
-				opts.Widths[c] = len(cellString)
+			cellString := string(stripIndexMarks(cell))
+			if n := brimtext.DisplayWidth(cellString); n > opts.Widths[c] {
+				opts.Widths[c] = n
 			}
 			headerRow = append(headerRow, cellString)
 		}
@@ -398,20 +701,45 @@ func (rend *renderer) Table(out *bytes.Buffer, header []byte, body []byte, colum
 		var bodyRow []string
 		cells := bytes.Split(row[:len(row)-1], []byte{markTableCell})
 		for c, cell := range cells {
-			cellString := string(cell)
-			if len(cellString) > opts.Widths[c] {
-				opts.Widths[c] = len(cellString)
+			cellString := string(stripIndexMarks(cell))
+			if n := brimtext.DisplayWidth(cellString); n > opts.Widths[c] {
+				opts.Widths[c] = n
 			}
 			bodyRow = append(bodyRow, cellString)
 		}
 		data = append(data, bodyRow)
 	}
-	aw := rend.width - rend.currentIndent - len(opts.RowFirstUD) - len(opts.RowLastUD)
-	if len(columnData) > 1 {
-		aw -= len(opts.RowSecondUD)
+	text := AlignToWidth(data, opts, rend.width-rend.currentIndent)
+	textBytes := []byte(text)
+	textBytes = bytes.Replace(textBytes, []byte{' '}, []byte{markNBSP}, -1)
+	textBytes = bytes.Replace(textBytes, []byte{'\n'}, []byte{markLineBreak}, -1)
+	rend.ensureBlankLine(out)
+	out.Write(textBytes)
+}
+
+// AlignToWidth formats the data with brimtext.Align, shrinking the columns
+// until the table fits within width, the same way tables in markdown are
+// rendered. If opts.Widths is nil it is set to the widest cell of each column;
+// opts is modified.
+func AlignToWidth(data [][]string, opts *brimtext.AlignOptions, width int) string {
+	if opts.Widths == nil {
+		for _, row := range data {
+			for c, cell := range row {
+				for c >= len(opts.Widths) {
+					opts.Widths = append(opts.Widths, 0)
+				}
+				if n := brimtext.DisplayWidth(cell); n > opts.Widths[c] {
+					opts.Widths[c] = n
+				}
+			}
+		}
 	}
-	if len(columnData) > 2 {
-		aw -= len(opts.RowUD)*len(columnData) - 2
+	aw := width - utf8.RuneCountInString(opts.RowFirstUD) - utf8.RuneCountInString(opts.RowLastUD)
+	if len(opts.Widths) > 1 {
+		aw -= utf8.RuneCountInString(opts.RowSecondUD)
+	}
+	if len(opts.Widths) > 2 {
+		aw -= utf8.RuneCountInString(opts.RowUD)*len(opts.Widths) - 2
 	}
 	cw := 0
 	for _, w := range opts.Widths {
@@ -438,7 +766,7 @@ func (rend *renderer) Table(out *bytes.Buffer, header []byte, body []byte, colum
 		good := true
 		text = brimtext.Align(data, opts)
 		for _, line := range strings.Split(text, "\n") {
-			if len(line) > aw {
+			if brimtext.DisplayWidth(line) > aw {
 				good = false
 			}
 		}
@@ -459,11 +787,7 @@ func (rend *renderer) Table(out *bytes.Buffer, header []byte, body []byte, colum
 			break
 		}
 	}
-	textBytes := []byte(text)
-	textBytes = bytes.Replace(textBytes, []byte{' '}, []byte{markNBSP}, -1)
-	textBytes = bytes.Replace(textBytes, []byte{'\n'}, []byte{markLineBreak}, -1)
-	rend.ensureBlankLine(out)
-	out.Write(textBytes)
+	return text
 }
 
 func (rend *renderer) TableRow(out *bytes.Buffer, text []byte) {
@@ -504,6 +828,9 @@ func (rend *renderer) TitleBlock(out *bytes.Buffer, text []byte) {
 }
 
 func (rend *renderer) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	if rend.index {
+		defer rend.indexLink(out, link)()
+	}
 	if rend.color {
 		out.Write(brimtext.ANSIEscape.FBlue)
 	}
@@ -556,6 +883,9 @@ func (rend *renderer) Emphasis(out *bytes.Buffer, text []byte) {
 }
 
 func (rend *renderer) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+	if rend.image(out, link) {
+		return
+	}
 	if rend.color {
 		out.Write(brimtext.ANSIEscape.FMagenta)
 	}
@@ -581,6 +911,16 @@ func (rend *renderer) LineBreak(out *bytes.Buffer) {
 }
 
 func (rend *renderer) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+	if rend.index {
+		defer rend.indexLink(out, link)()
+	}
+	if bytes.IndexByte(content, markLineBreak) != -1 {
+		// The content is an image rendered on lines of its own, so just
+		// follow it with the link.
+		rend.ensureNewLine(out)
+		out.Write(content)
+		content = nil
+	}
 	if rend.color {
 		out.Write(brimtext.ANSIEscape.FBlue)
 	}
@@ -642,14 +982,27 @@ func (rend *renderer) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
 }
 
 func (rend *renderer) Entity(out *bytes.Buffer, entity []byte) {
-	out.Write(entity)
+	out.WriteString(html.UnescapeString(string(entity)))
 }
 
 func (rend *renderer) NormalText(out *bytes.Buffer, text []byte) {
-	out.Write(text)
+	if rend.math != nil {
+		rend.replaceMath(out, text)
+		return
+	}
+	rend.writeText(out, text)
 }
 
 func (rend *renderer) DocumentHeader(out *bytes.Buffer) {
+	// When streaming, later chunks start inside the header indentation left
+	// open by earlier ones.
+	for i := 0; i < rend.level; i++ {
+		out.WriteByte(markIndentStart)
+		out.Write(rend.headerIndent)
+		out.WriteByte(markIndent1)
+		out.Write(rend.headerIndent)
+		out.WriteByte(markIndent2)
+	}
 }
 
 func (rend *renderer) DocumentFooter(out *bytes.Buffer) {
@@ -780,25 +1133,10 @@ func wrapBytes(text []byte, width int, indent1 []byte, indent2 []byte) []byte {
 		lineLen := 0
 		start := true
 		for _, word := range bytes.Split(line, []byte{' '}) {
-			wordLen := len(word)
-			if wordLen == 0 {
+			if len(word) == 0 {
 				continue
 			}
-			scan := word
-			for len(scan) > 1 {
-				i := bytes.IndexByte(scan, '\x1b')
-				if i == -1 {
-					break
-				}
-				j := bytes.IndexByte(scan[i+1:], 'm')
-				if j == -1 {
-					i++
-				} else {
-					j += 2
-					wordLen -= j
-					scan = scan[i+j:]
-				}
-			}
+			wordLen := brimtext.DisplayWidth(string(word))
 			if start {
 				if out.Len() == 0 {
 					out.Write(indent1)
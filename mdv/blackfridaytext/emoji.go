@@ -0,0 +1,378 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import (
+	"bytes"
+	"html"
+	"strings"
+
+	"github.com/russross/blackfriday"
+)
+
+// EmojiMode selects how GitHub style :shortcode: emoji are rendered.
+type EmojiMode int
+
+const (
+	// EmojiOff leaves shortcodes as they are.
+	EmojiOff EmojiMode = iota
+	// EmojiUnicode replaces known shortcodes with their Unicode emoji.
+	EmojiUnicode
+	// EmojiASCII replaces known shortcodes with an ASCII approximation, such
+	// as ":)" for :smile:, for terminals which can't display emoji. Shortcodes
+	// without one are left as they are.
+	EmojiASCII
+)
+
+// smartypants is used for its Smartypants method, which writes HTML entities
+// that writeText then decodes.
+var smartypants = blackfriday.HtmlRenderer(
+	blackfriday.HTML_USE_SMARTYPANTS|blackfriday.HTML_SMARTYPANTS_DASHES|blackfriday.HTML_SMARTYPANTS_LATEX_DASHES,
+	"", "").(*blackfriday.Html)
+
+// writeText writes normal text, with smart quotes, dashes and ellipses if
+// enabled.
+func (rend *renderer) writeText(out *bytes.Buffer, text []byte) {
+	if !rend.smartypants {
+		out.Write(text)
+		return
+	}
+	var typographic bytes.Buffer
+	smartypants.Smartypants(&typographic, text)
+	out.WriteString(html.UnescapeString(typographic.String()))
+}
+
+// replaceEmoji replaces the :shortcode: emoji of the markdown, other than
+// those in code, according to mode.
+func replaceEmoji(markdown []byte, mode EmojiMode) []byte {
+	if mode == EmojiOff || bytes.IndexByte(markdown, ':') == -1 {
+		return markdown
+	}
+	var out bytes.Buffer
+	var fence []byte
+	blank := true
+	for _, line := range bytes.SplitAfter(markdown, []byte("\n")) {
+		trimmed := bytes.TrimLeft(line, " ")
+		switch {
+		case fence != nil:
+			if bytes.HasPrefix(trimmed, fence) {
+				fence = nil
+			}
+			out.Write(line)
+			continue
+		case bytes.HasPrefix(trimmed, []byte("```")) || bytes.HasPrefix(trimmed, []byte("~~~")):
+			fence = trimmed[:3]
+			out.Write(line)
+			continue
+		case blank && (bytes.HasPrefix(line, []byte("    ")) || bytes.HasPrefix(line, []byte("\t"))):
+			out.Write(line)
+			continue
+		}
+		blank = len(bytes.TrimSpace(line)) == 0
+		replaceEmojiLine(&out, line, mode)
+	}
+	return out.Bytes()
+}
+
+func replaceEmojiLine(out *bytes.Buffer, line []byte, mode EmojiMode) {
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == '`' {
+			n := 1
+			for i+n < len(line) && line[i+n] == '`' {
+				n++
+			}
+			if j := bytes.Index(line[i+n:], line[i:i+n]); j != -1 {
+				n += j + n
+			}
+			out.Write(line[i : i+n])
+			i += n - 1
+			continue
+		}
+		if c != ':' {
+			out.WriteByte(c)
+			continue
+		}
+		j := i + 1
+		for j < len(line) && isShortcodeByte(line[j]) {
+			j++
+		}
+		if j < len(line) && line[j] == ':' && j > i+1 {
+			if e, ok := emoji[string(line[i+1:j])]; ok {
+				switch {
+				case mode == EmojiUnicode:
+					out.WriteString(e.unicode)
+					i = j
+					continue
+				case e.ascii != "":
+					// The replacement is still markdown, so characters such
+					// as * and _ are escaped to keep them literal.
+					for k := 0; k < len(e.ascii); k++ {
+						if strings.IndexByte(markdownEscapable, e.ascii[k]) != -1 {
+							out.WriteByte('\\')
+						}
+						out.WriteByte(e.ascii[k])
+					}
+					i = j
+					continue
+				}
+			}
+		}
+		out.WriteByte(c)
+	}
+}
+
+// markdownEscapable are the characters blackfriday allows to be backslash
+// escaped.
+const markdownEscapable = "\\`*_{}[]()#+-.!:|&<>~"
+
+func isShortcodeByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '+' || c == '-'
+}
+
+// emoji are some of the GitHub emoji shortcodes, with ASCII approximations
+// where there is a reasonable one.
+var emoji = map[string]struct{ unicode, ascii string }{
+	"smile":                        {"😄", ":D"},
+	"smiley":                       {"😃", ":)"},
+	"grinning":                     {"😀", ":D"},
+	"grin":                         {"😁", ":D"},
+	"laughing":                     {"😆", "XD"},
+	"satisfied":                    {"😆", "XD"},
+	"joy":                          {"😂", ":'D"},
+	"rofl":                         {"🤣", "XD"},
+	"sweat_smile":                  {"😅", "^^;"},
+	"slightly_smiling_face":        {"🙂", ":)"},
+	"upside_down_face":             {"🙃", "(:"},
+	"wink":                         {"😉", ";)"},
+	"blush":                        {"😊", "^_^"},
+	"innocent":                     {"😇", "O:)"},
+	"heart_eyes":                   {"😍", "<3_<3"},
+	"kissing_heart":                {"😘", ":-*"},
+	"yum":                          {"😋", ":P"},
+	"stuck_out_tongue":             {"😛", ":P"},
+	"stuck_out_tongue_winking_eye": {"😜", ";P"},
+	"thinking":                     {"🤔", ":-?"},
+	"neutral_face":                 {"😐", ":|"},
+	"expressionless":               {"😑", "-_-"},
+	"unamused":                     {"😒", ":-/"},
+	"roll_eyes":                    {"🙄", "9_9"},
+	"smirk":                        {"😏", ":->"},
+	"relieved":                     {"😌", ":)"},
+	"pensive":                      {"😔", ":("},
+	"sleepy":                       {"😪", "-_-"},
+	"sleeping":                     {"😴", "Zzz"},
+	"mask":                         {"😷", ":#"},
+	"nerd_face":                    {"🤓", "8-)"},
+	"sunglasses":                   {"😎", "B-)"},
+	"confused":                     {"😕", ":/"},
+	"worried":                      {"😟", ":("},
+	"slightly_frowning_face":       {"🙁", ":("},
+	"frowning":                     {"😦", ":("},
+	"open_mouth":                   {"😮", ":O"},
+	"hushed":                       {"😯", ":o"},
+	"astonished":                   {"😲", ":O"},
+	"flushed":                      {"😳", "O_O"},
+	"fearful":                      {"😨", "D:"},
+	"cold_sweat":                   {"😰", "D:"},
+	"cry":                          {"😢", ":'("},
+	"sob":                          {"😭", ":'("},
+	"scream":                       {"😱", "D:"},
+	"confounded":                   {"😖", ">_<"},
+	"persevere":                    {"😣", ">_<"},
+	"disappointed":                 {"😞", ":("},
+	"sweat":                        {"😓", "^^;"},
+	"weary":                        {"😩", "D:"},
+	"tired_face":                   {"😫", "D:"},
+	"triumph":                      {"😤", ">:("},
+	"rage":                         {"😡", ">:("},
+	"angry":                        {"😠", ">:("},
+	"skull":                        {"💀", "X_X"},
+	"poop":                         {"💩", ""},
+	"hankey":                       {"💩", ""},
+	"clown_face":                   {"🤡", ":o)"},
+	"ghost":                        {"👻", ""},
+	"alien":                        {"👽", ""},
+	"robot":                        {"🤖", "[:]"},
+	"heart":                        {"❤️", "<3"},
+	"broken_heart":                 {"💔", "</3"},
+	"sparkling_heart":              {"💖", "<3"},
+	"yellow_heart":                 {"💛", "<3"},
+	"green_heart":                  {"💚", "<3"},
+	"blue_heart":                   {"💙", "<3"},
+	"purple_heart":                 {"💜", "<3"},
+	"100":                          {"💯", "100"},
+	"boom":                         {"💥", "*BOOM*"},
+	"collision":                    {"💥", "*BOOM*"},
+	"zzz":                          {"💤", "Zzz"},
+	"wave":                         {"👋", "o/"},
+	"raised_hand":                  {"✋", "o/"},
+	"ok_hand":                      {"👌", "OK"},
+	"+1":                           {"👍", "+1"},
+	"thumbsup":                     {"👍", "+1"},
+	"-1":                           {"👎", "-1"},
+	"thumbsdown":                   {"👎", "-1"},
+	"clap":                         {"👏", ""},
+	"raised_hands":                 {"🙌", "\\o/"},
+	"pray":                         {"🙏", ""},
+	"muscle":                       {"💪", ""},
+	"point_right":                  {"👉", "->"},
+	"point_left":                   {"👈", "<-"},
+	"point_up":                     {"☝️", "^"},
+	"point_down":                   {"👇", "v"},
+	"eyes":                         {"👀", "o_o"},
+	"tada":                         {"🎉", "\\o/"},
+	"confetti_ball":                {"🎊", "\\o/"},
+	"gift":                         {"🎁", ""},
+	"trophy":                       {"🏆", ""},
+	"rocket":                       {"🚀", ""},
+	"fire":                         {"🔥", ""},
+	"sparkles":                     {"✨", "*"},
+	"star":                         {"⭐", "*"},
+	"star2":                        {"🌟", "*"},
+	"zap":                          {"⚡", ""},
+	"sunny":                        {"☀️", ""},
+	"cloud":                        {"☁️", ""},
+	"umbrella":                     {"☔", ""},
+	"snowflake":                    {"❄️", "*"},
+	"rainbow":                      {"🌈", ""},
+	"coffee":                       {"☕", "c[_]"},
+	"beer":                         {"🍺", ""},
+	"beers":                        {"🍻", ""},
+	"pizza":                        {"🍕", ""},
+	"cake":                         {"🍰", ""},
+	"apple":                        {"🍎", ""},
+	"cat":                          {"🐱", "=^.^="},
+	"dog":                          {"🐶", ""},
+	"penguin":                      {"🐧", ""},
+	"whale":                        {"🐳", ""},
+	"octocat":                      {"🐙", ""},
+	"bug":                          {"🐛", ""},
+	"bee":                          {"🐝", ""},
+	"snail":                        {"🐌", "@_/"},
+	"turtle":                       {"🐢", ""},
+	"seedling":                     {"🌱", ""},
+	"evergreen_tree":               {"🌲", ""},
+	"four_leaf_clover":             {"🍀", ""},
+	"earth_americas":               {"🌎", ""},
+	"globe_with_meridians":         {"🌐", ""},
+	"computer":                     {"💻", ""},
+	"keyboard":                     {"⌨️", ""},
+	"desktop_computer":             {"🖥️", ""},
+	"floppy_disk":                  {"💾", ""},
+	"cd":                           {"💿", ""},
+	"package":                      {"📦", ""},
+	"memo":                         {"📝", ""},
+	"pencil":                       {"📝", ""},
+	"pencil2":                      {"✏️", ""},
+	"book":                         {"📖", ""},
+	"books":                        {"📚", ""},
+	"bookmark":                     {"🔖", ""},
+	"page_facing_up":               {"📄", ""},
+	"clipboard":                    {"📋", ""},
+	"calendar":                     {"📆", ""},
+	"date":                         {"📅", ""},
+	"chart_with_upwards_trend":     {"📈", ""},
+	"chart_with_downwards_trend":   {"📉", ""},
+	"bar_chart":                    {"📊", ""},
+	"paperclip":                    {"📎", ""},
+	"pushpin":                      {"📌", ""},
+	"link":                         {"🔗", ""},
+	"mag":                          {"🔍", ""},
+	"bulb":                         {"💡", ""},
+	"lock":                         {"🔒", ""},
+	"unlock":                       {"🔓", ""},
+	"key":                          {"🔑", ""},
+	"hammer":                       {"🔨", ""},
+	"wrench":                       {"🔧", ""},
+	"hammer_and_wrench":            {"🛠️", ""},
+	"gear":                         {"⚙️", ""},
+	"nut_and_bolt":                 {"🔩", ""},
+	"bomb":                         {"💣", ""},
+	"microscope":                   {"🔬", ""},
+	"telescope":                    {"🔭", ""},
+	"bell":                         {"🔔", ""},
+	"no_bell":                      {"🔕", ""},
+	"loudspeaker":                  {"📢", ""},
+	"mega":                         {"📣", ""},
+	"email":                        {"📧", ""},
+	"envelope":                     {"✉️", ""},
+	"inbox_tray":                   {"📥", ""},
+	"outbox_tray":                  {"📤", ""},
+	"hourglass":                    {"⌛", ""},
+	"hourglass_flowing_sand":       {"⏳", ""},
+	"watch":                        {"⌚", ""},
+	"alarm_clock":                  {"⏰", ""},
+	"stopwatch":                    {"⏱️", ""},
+	"construction":                 {"🚧", ""},
+	"rotating_light":               {"🚨", "(!)"},
+	"warning":                      {"⚠️", "(!)"},
+	"no_entry":                     {"⛔", "(X)"},
+	"no_entry_sign":                {"🚫", "(X)"},
+	"x":                            {"❌", "[X]"},
+	"heavy_check_mark":             {"✔️", "[v]"},
+	"white_check_mark":             {"✅", "[v]"},
+	"ballot_box_with_check":        {"☑️", "[v]"},
+	"heavy_multiplication_x":       {"✖️", "x"},
+	"heavy_plus_sign":              {"➕", "+"},
+	"heavy_minus_sign":             {"➖", "-"},
+	"question":                     {"❓", "?"},
+	"grey_question":                {"❔", "?"},
+	"exclamation":                  {"❗", "!"},
+	"grey_exclamation":             {"❕", "!"},
+	"bangbang":                     {"‼️", "!!"},
+	"interrobang":                  {"⁉️", "!?"},
+	"information_source":           {"ℹ️", "(i)"},
+	"recycle":                      {"♻️", ""},
+	"arrow_right":                  {"➡️", "->"},
+	"arrow_left":                   {"⬅️", "<-"},
+	"arrow_up":                     {"⬆️", "^"},
+	"arrow_down":                   {"⬇️", "v"},
+	"arrows_counterclockwise":      {"🔄", ""},
+	"heavy_dollar_sign":            {"💲", "$"},
+	"copyright":                    {"©️", "(c)"},
+	"registered":                   {"®️", "(R)"},
+	"tm":                           {"™️", "(TM)"},
+	"new":                          {"🆕", "NEW"},
+	"free":                         {"🆓", "FREE"},
+	"up":                           {"🆙", "UP!"},
+	"cool":                         {"🆒", "COOL"},
+	"ok":                           {"🆗", "OK"},
+	"sos":                          {"🆘", "SOS"},
+	"red_circle":                   {"🔴", "(o)"},
+	"large_blue_circle":            {"🔵", "(o)"},
+	"white_circle":                 {"⚪", "( )"},
+	"black_circle":                 {"⚫", "(o)"},
+	"checkered_flag":               {"🏁", ""},
+	"triangular_flag_on_post":      {"🚩", ""},
+	"crossed_flags":                {"🎌", ""},
+	"bike":                         {"🚲", ""},
+	"car":                          {"🚗", ""},
+	"airplane":                     {"✈️", ""},
+	"ship":                         {"🚢", ""},
+	"house":                        {"🏠", ""},
+	"office":                       {"🏢", ""},
+	"moneybag":                     {"💰", "$$$"},
+	"dollar":                       {"💵", "$"},
+	"credit_card":                  {"💳", ""},
+	"gem":                          {"💎", "<>"},
+	"crown":                        {"👑", ""},
+	"art":                          {"🎨", ""},
+	"musical_note":                 {"🎵", ""},
+	"notes":                        {"🎶", ""},
+	"video_game":                   {"🎮", ""},
+	"dart":                         {"🎯", ""},
+	"game_die":                     {"🎲", ""},
+	"lipstick":                     {"💄", ""},
+	"speech_balloon":               {"💬", ""},
+	"thought_balloon":              {"💭", ""},
+	"see_no_evil":                  {"🙈", ""},
+	"hear_no_evil":                 {"🙉", ""},
+	"speak_no_evil":                {"🙊", ""},
+	"shrug":                        {"🤷", "\\_(o.o)_/"},
+	"facepalm":                     {"🤦", "m(_ _)m"},
+	"man_shrugging":                {"🤷‍♂️", "\\_(o.o)_/"},
+	"woman_shrugging":              {"🤷‍♀️", "\\_(o.o)_/"},
+}
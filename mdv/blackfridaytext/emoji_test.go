@@ -0,0 +1,50 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import "testing"
+
+func TestReplaceEmoji(t *testing.T) {
+	for _, test := range []struct {
+		mode     EmojiMode
+		markdown string
+		want     string
+	}{
+		{EmojiOff, "Go :rocket:", "Go :rocket:"},
+		{EmojiUnicode, "Go :rocket: :+1: :wink:", "Go 🚀 👍 😉"},
+		// The approximations are escaped to stay literal markdown.
+		{EmojiASCII, ":heart: :wink: :smile:", "\\<3 ;\\) \\:D"},
+		// Shortcodes without an ASCII approximation are left alone.
+		{EmojiASCII, "Go :rocket:", "Go :rocket:"},
+		// Unknown shortcodes, times and code aren't replaced.
+		{EmojiUnicode, ":nosuchemoji: at 10:30:45", ":nosuchemoji: at 10:30:45"},
+		{EmojiUnicode, "`:rocket:` and ``a :rocket: b`` :rocket:", "`:rocket:` and ``a :rocket: b`` 🚀"},
+		{EmojiUnicode, "```\n:rocket:\n```\n:rocket:\n", "```\n:rocket:\n```\n🚀\n"},
+		{EmojiUnicode, "text\n\n    :rocket:\n", "text\n\n    :rocket:\n"},
+	} {
+		if got := string(replaceEmoji([]byte(test.markdown), test.mode)); got != test.want {
+			t.Errorf("replaceEmoji(%q, %d) = %q, want %q", test.markdown, test.mode, got, test.want)
+		}
+	}
+}
+
+func TestTypography(t *testing.T) {
+	for _, test := range []struct {
+		markdown string
+		smart    bool
+		want     string
+	}{
+		{`"quoted" -- and --- then...`, false, "\"quoted\" -- and --- then...\n"},
+		{`"quoted" -- and --- then...`, true, "“quoted” – and — then…\n"},
+		{"a &mdash; b &amp; c &copy;", false, "a — b & c ©\n"},
+		{"`\"code\" --`", true, "\"\"code\" --\"\n"},
+		{":heart: :wink: :smile: :-)", false, "<3 ;) :D :-)\n"},
+	} {
+		opts := &Options{Width: 80, Smartypants: test.smart, Emoji: EmojiASCII}
+		if got := string(MarkdownToTextNoMetadata([]byte(test.markdown), opts)); got != test.want {
+			t.Errorf("%q smart %v:\n got %q\nwant %q", test.markdown, test.smart, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestBoxedHeaderFitsWidth(t *testing.T) {
+	markdown := []byte("# A very long level one header that goes on well past forty columns, with averyveryveryverylongwordthatcannotbewrappedatall\n\ntext\n")
+	for _, indent := range [][]byte{nil, {}} {
+		out := MarkdownToTextNoMetadata(markdown, &Options{
+			Width:        40,
+			HeaderStyles: NewBoxedHeaderStyles(false),
+			HeaderIndent: indent,
+		})
+		lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+		for _, line := range lines {
+			if n := utf8.RuneCountInString(line); n > 40 {
+				t.Errorf("line %q is %d wide, more than 40", line, n)
+			}
+		}
+		if !strings.HasPrefix(lines[0], "+--") || !strings.HasPrefix(lines[1], "| A very long") {
+			t.Errorf("header not boxed:\n%s", out)
+		}
+	}
+}
+
+func TestWrapRunes(t *testing.T) {
+	for _, test := range []struct {
+		text  string
+		width int
+		want  []string
+	}{
+		{"", 5, []string{""}},
+		{"one two three", 20, []string{"one two three"}},
+		{"one two three", 7, []string{"one two", "three"}},
+		{"abcdefghij xy", 4, []string{"abcd", "efgh", "ij", "xy"}},
+		{"αβγ δεζ", 3, []string{"αβγ", "δεζ"}},
+	} {
+		if got := wrapRunes(test.text, test.width); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("wrapRunes(%q, %d) = %q, want %q", test.text, test.width, got, test.want)
+		}
+	}
+}
+
+func TestHeaderStyleColor(t *testing.T) {
+	out := string(MarkdownToTextNoMetadata([]byte("# One\n\n## Two\n"), &Options{
+		Width:        40,
+		Color:        true,
+		HeaderStyles: NewBoxedHeaderStyles(true),
+	}))
+	if !strings.Contains(out, "\x1b[1m\x1b[36mOne") {
+		t.Errorf("level 1 header not bold cyan:\n%q", out)
+	}
+	if !strings.Contains(out, "\x1b[1mTwo\x1b[0m\n    ----") {
+		t.Errorf("level 2 header not bold with a rule:\n%q", out)
+	}
+}
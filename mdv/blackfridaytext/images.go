@@ -0,0 +1,274 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// ImageMode selects how images are rendered.
+type ImageMode int
+
+const (
+	// ImagesOff renders images as their alt text and path, "[alt] path".
+	ImagesOff ImageMode = iota
+	// ImagesBlocks renders local images as 24 bit color Unicode half block
+	// characters, two pixels per character cell. This requires Color.
+	ImagesBlocks
+	// ImagesSixel renders local images as DEC sixel graphics.
+	ImagesSixel
+	// ImagesKitty renders local images with the kitty terminal graphics
+	// protocol.
+	ImagesKitty
+)
+
+// Character cells are assumed to be about twice as tall as they are wide, and
+// sixel images to use this many pixels per cell.
+const (
+	sixelCellWidth  = 10
+	sixelCellHeight = 20
+)
+
+// image renders the local PNG, JPEG or GIF image at link on lines of its own,
+// sized to the text width, and returns false if it could not.
+func (rend *renderer) image(out *bytes.Buffer, link []byte) bool {
+	if rend.images == ImagesOff || (rend.images == ImagesBlocks && !rend.color) {
+		return false
+	}
+	u, err := url.Parse(string(link))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return false
+	}
+	fileName := filepath.FromSlash(u.Path)
+	if !filepath.IsAbs(fileName) {
+		fileName = filepath.Join(rend.imageDir, fileName)
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return false
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return false
+	}
+	bounds := img.Bounds()
+	if bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return false
+	}
+	columns := rend.width - rend.currentIndent - 1
+	if columns > bounds.Dx() {
+		columns = bounds.Dx()
+	}
+	if columns < 1 {
+		columns = 1
+	}
+	rows := (bounds.Dy()*columns/bounds.Dx() + 1) / 2
+	if rows < 1 {
+		rows = 1
+	}
+	var lines [][]byte
+	switch rend.images {
+	case ImagesBlocks:
+		lines = halfBlocks(resample(img, columns, rows*2))
+	case ImagesSixel:
+		lines = [][]byte{sixel(resample(img, columns*sixelCellWidth, rows*sixelCellHeight))}
+	case ImagesKitty:
+		var data bytes.Buffer
+		if err := png.Encode(&data, img); err != nil {
+			return false
+		}
+		lines = [][]byte{kitty(data.Bytes(), columns, rows)}
+	}
+	rend.ensureNewLine(out)
+	for _, line := range lines {
+		out.Write(line)
+		out.WriteByte(markLineBreak)
+	}
+	return true
+}
+
+// resample scales the image to width x height pixels by averaging the source
+// pixels covered by each destination pixel.
+func resample(img image.Image, width, height int) [][]color.NRGBA {
+	bounds := img.Bounds()
+	pixels := make([][]color.NRGBA, height)
+	for y := range pixels {
+		pixels[y] = make([]color.NRGBA, width)
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := range pixels[y] {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r += pr
+					g += pg
+					b += pb
+					a += pa
+					n++
+				}
+			}
+			if a == 0 {
+				continue
+			}
+			// The sums are alpha premultiplied, so dividing by the alpha sum
+			// gives the straight color.
+			pixels[y][x] = color.NRGBA{
+				R: uint8(uint64(r) * 0xff / uint64(a)),
+				G: uint8(uint64(g) * 0xff / uint64(a)),
+				B: uint8(uint64(b) * 0xff / uint64(a)),
+				A: uint8(a / n >> 8),
+			}
+		}
+	}
+	return pixels
+}
+
+// halfBlocks returns a line of "▀" characters for each two rows of pixels,
+// the foreground color being the upper pixel and the background the lower.
+// Mostly transparent pixels are left as the terminal's default colors.
+func halfBlocks(pixels [][]color.NRGBA) [][]byte {
+	var lines [][]byte
+	for y := 0; y < len(pixels); y += 2 {
+		var line bytes.Buffer
+		plain := true
+		for x, top := range pixels[y] {
+			var bottom color.NRGBA
+			if y+1 < len(pixels) {
+				bottom = pixels[y+1][x]
+			}
+			if top.A < 0x80 && bottom.A < 0x80 {
+				if !plain {
+					line.WriteString("\x1b[0m")
+					plain = true
+				}
+				line.WriteByte(markNBSP)
+				continue
+			}
+			plain = false
+			switch {
+			case top.A < 0x80:
+				fmt.Fprintf(&line, "\x1b[0;38;2;%d;%d;%dm▄", bottom.R, bottom.G, bottom.B)
+			case bottom.A < 0x80:
+				fmt.Fprintf(&line, "\x1b[0;38;2;%d;%d;%dm▀", top.R, top.G, top.B)
+			default:
+				fmt.Fprintf(&line, "\x1b[38;2;%d;%d;%d;48;2;%d;%d;%dm▀", top.R, top.G, top.B, bottom.R, bottom.G, bottom.B)
+			}
+		}
+		if !plain {
+			line.WriteString("\x1b[0m")
+		}
+		lines = append(lines, line.Bytes())
+	}
+	return lines
+}
+
+// sixel encodes the pixels as a DEC sixel image, using a 6x6x6 color cube.
+// Transparent pixels are left as the background.
+func sixel(pixels [][]color.NRGBA) []byte {
+	var out bytes.Buffer
+	height := len(pixels)
+	width := 0
+	if height > 0 {
+		width = len(pixels[0])
+	}
+	fmt.Fprintf(&out, "\x1bP0;1q\"1;1;%d;%d", width, height)
+	for i := 0; i < 216; i++ {
+		fmt.Fprintf(&out, "#%d;2;%d;%d;%d", i, i/36*20, i/6%6*20, i%6*20)
+	}
+	index := func(c color.NRGBA) int {
+		return (int(c.R)+25)/51*36 + (int(c.G)+25)/51*6 + (int(c.B)+25)/51
+	}
+	for band := 0; band < height; band += 6 {
+		var used [216]bool
+		var order []int
+		for y := band; y < band+6 && y < height; y++ {
+			for _, c := range pixels[y] {
+				if i := index(c); c.A >= 0x80 && !used[i] {
+					used[i] = true
+					order = append(order, i)
+				}
+			}
+		}
+		for n, i := range order {
+			if n > 0 {
+				out.WriteByte('$')
+			}
+			fmt.Fprintf(&out, "#%d", i)
+			last, count := byte(0), 0
+			flush := func() {
+				switch {
+				case count > 3:
+					fmt.Fprintf(&out, "!%d%c", count, last)
+				case count > 0:
+					out.Write(bytes.Repeat([]byte{last}, count))
+				}
+			}
+			for x := 0; x < width; x++ {
+				bits := byte(0)
+				for bit := 0; bit < 6 && band+bit < height; bit++ {
+					if c := pixels[band+bit][x]; c.A >= 0x80 && index(c) == i {
+						bits |= 1 << uint(bit)
+					}
+				}
+				if ch := '?' + bits; ch == last {
+					count++
+				} else {
+					flush()
+					last, count = ch, 1
+				}
+			}
+			flush()
+		}
+		out.WriteByte('-')
+	}
+	out.WriteString("\x1b\\")
+	return out.Bytes()
+}
+
+// kitty returns the kitty graphics protocol escape codes to display the PNG
+// data scaled to columns x rows character cells at the cursor.
+func kitty(data []byte, columns, rows int) []byte {
+	var out bytes.Buffer
+	encoded := base64.StdEncoding.EncodeToString(data)
+	first := true
+	for len(encoded) > 0 {
+		chunk := encoded
+		if len(chunk) > 4096 {
+			chunk = chunk[:4096]
+		}
+		encoded = encoded[len(chunk):]
+		more := 0
+		if len(encoded) > 0 {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(&out, "\x1b_Ga=T,f=100,q=2,c=%d,r=%d,m=%d;%s\x1b\\", columns, rows, more, chunk)
+			first = false
+		} else {
+			fmt.Fprintf(&out, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,98 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResample(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		img.Set(x, 0, color.NRGBA{R: 200, A: 0xff})
+		img.Set(x, 1, color.NRGBA{B: 100, A: 0xff})
+	}
+	img.Set(3, 1, color.NRGBA{})
+	pixels := resample(img, 2, 1)
+	if len(pixels) != 1 || len(pixels[0]) != 2 {
+		t.Fatalf("resample size %dx%d, want 2x1", len(pixels[0]), len(pixels))
+	}
+	// Averaged with premultiplied alpha, so the transparent pixel doesn't
+	// darken the color, only makes it more transparent.
+	if got, want := pixels[0][0], (color.NRGBA{R: 100, B: 50, A: 0xff}); got != want {
+		t.Errorf("left pixel %v, want %v", got, want)
+	}
+	if got := pixels[0][1]; got.A != 0xbf || got.R != 133 || got.B != 33 {
+		t.Errorf("right pixel %v, want 3/4 opaque", got)
+	}
+}
+
+func TestHalfBlocks(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 0xff}
+	blue := color.NRGBA{B: 255, A: 0xff}
+	clear := color.NRGBA{}
+	lines := halfBlocks([][]color.NRGBA{
+		{red, clear, red, clear},
+		{blue, clear, clear, blue},
+	})
+	want := "\x1b[38;2;255;0;0;48;2;0;0;255m▀\x1b[0m\x02\x1b[0;38;2;255;0;0m▀\x1b[0;38;2;0;0;255m▄\x1b[0m"
+	if len(lines) != 1 || string(lines[0]) != want {
+		t.Errorf("halfBlocks = %q, want %q", lines, want)
+	}
+}
+
+func TestSixel(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 0xff}
+	got := string(sixel([][]color.NRGBA{{red, red, red, red, red}, {red, red, red, red, red}}))
+	if !strings.HasPrefix(got, "\x1bP0;1q\"1;1;5;2") || !strings.HasSuffix(got, "#180!5B-\x1b\\") {
+		t.Errorf("sixel = %q", got)
+	}
+}
+
+func TestKitty(t *testing.T) {
+	got := string(kitty(bytes.Repeat([]byte{0}, 3075), 10, 5))
+	parts := strings.Split(got, "\x1b\\")
+	if len(parts) != 3 || !strings.HasPrefix(parts[0], "\x1b_Ga=T,f=100,q=2,c=10,r=5,m=1;") || !strings.HasPrefix(parts[1], "\x1b_Gm=0;") {
+		t.Errorf("kitty = %q", got)
+	}
+}
+
+func TestImageRendering(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blackfridaytext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 40))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	var b bytes.Buffer
+	png.Encode(&b, img)
+	ioutil.WriteFile(filepath.Join(dir, "white.png"), b.Bytes(), 0644)
+
+	markdown := []byte("![white](white.png) ![gone](missing.png)\n")
+	opts := &Options{Width: 21, Color: true, Images: ImagesBlocks, ImageDir: dir}
+	out := string(MarkdownToTextNoMetadata(markdown, opts))
+	// 20 columns by 4 rows, being half the height of 8 pixels.
+	if n := strings.Count(out, "▀"); n != 80 {
+		t.Errorf("%d half blocks, want 80:\n%s", n, out)
+	}
+	if !strings.Contains(out, "missing.png") {
+		t.Errorf("missing image not shown as its path:\n%s", out)
+	}
+	opts.Images = ImagesOff
+	if out := string(MarkdownToTextNoMetadata(markdown, opts)); strings.Contains(out, "▀") || !strings.Contains(out, "white.png") {
+		t.Errorf("image shown with ImagesOff:\n%s", out)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import (
+	"bytes"
+	"strconv"
+	"unicode/utf8"
+)
+
+// TextLink is a link located in the text returned by MarkdownToTextIndexed.
+type TextLink struct {
+	// Target is the link destination as given in the markdown.
+	Target string
+	// Line and Column give the 0 based line and display column of the start
+	// of the link.
+	Line   int
+	Column int
+	// EndLine and EndColumn give the line and display column just after the
+	// end of the link.
+	EndLine   int
+	EndColumn int
+}
+
+// TextHeader is a header located in the text returned by
+// MarkdownToTextIndexed.
+type TextHeader struct {
+	Header
+	// Line is the 0 based line of the header.
+	Line int
+}
+
+// Index marks are emitted by the renderer as fake ANSI Escape Codes, which
+// reflow and wrapBytes already treat as zero width, and are removed again by
+// MarkdownToTextIndexed: "\x1b" kind number "m".
+const (
+	markHeader    = 'H'
+	markLinkStart = 'L'
+	markLinkEnd   = 'l'
+	markCodeStart = 'C'
+	markCodeEnd   = 'c'
+)
+
+// MarkdownToTextIndexed is the same as MarkdownToTextNoMetadata but also
+// returns where each link and header ended up in the returned text, for use by
+// interactive viewers. Links within tables are not included. If opts is nil
+// the defaults will be used.
+func MarkdownToTextIndexed(markdown []byte, opts *Options) ([]byte, []TextLink, []TextHeader) {
+	opts = resolveOpts(opts)
+	var headers []Header
+	rend := newRenderer(opts)
+	rend.index = true
+	rend.headers = &headers
+	txt := renderText(markdown, opts, rend)
+
+	links := make([]TextLink, len(rend.indexLinks))
+	for i, target := range rend.indexLinks {
+		links[i] = TextLink{Target: target, Line: -1}
+	}
+	textHeaders := make([]TextHeader, len(headers))
+	for i, h := range headers {
+		textHeaders[i] = TextHeader{Header: h, Line: -1}
+	}
+	var out bytes.Buffer
+	for lineNum, line := range bytes.Split(txt, []byte("\n")) {
+		if lineNum > 0 {
+			out.WriteByte('\n')
+		}
+		column := 0
+		for i := 0; i < len(line); {
+			if line[i] == '\x1b' {
+				j := bytes.IndexByte(line[i:], 'm')
+				if j == -1 {
+					out.Write(line[i:])
+					break
+				}
+				esc := line[i : i+j+1]
+				i += j + 1
+				kind, n, ok := parseIndexMark(esc)
+				if !ok {
+					out.Write(esc)
+					continue
+				}
+				switch {
+				case kind == markHeader && n < len(textHeaders):
+					textHeaders[n].Line = lineNum
+				case kind == markLinkStart && n < len(links):
+					links[n].Line, links[n].Column = lineNum, column
+				case kind == markLinkEnd && n < len(links):
+					links[n].EndLine, links[n].EndColumn = lineNum, column
+				}
+				continue
+			}
+			_, size := utf8.DecodeRune(line[i:])
+			out.Write(line[i : i+size])
+			i += size
+			column++
+		}
+	}
+	var found []TextLink
+	for _, link := range links {
+		if link.Line >= 0 {
+			found = append(found, link)
+		}
+	}
+	return out.Bytes(), found, textHeaders
+}
+
+// indexLink writes the start mark for a link and returns a func to write the
+// end mark.
+func (rend *renderer) indexLink(out *bytes.Buffer, link []byte) func() {
+	n := len(rend.indexLinks)
+	rend.indexLinks = append(rend.indexLinks, string(link))
+	out.Write(indexMark(markLinkStart, n))
+	return func() {
+		out.Write(indexMark(markLinkEnd, n))
+	}
+}
+
+func indexMark(kind byte, n int) []byte {
+	return []byte("\x1b" + string(kind) + strconv.Itoa(n) + "m")
+}
+
+func parseIndexMark(esc []byte) (byte, int, bool) {
+	if len(esc) < 4 {
+		return 0, 0, false
+	}
+	switch esc[1] {
+	case markHeader, markLinkStart, markLinkEnd, markCodeStart, markCodeEnd:
+	default:
+		return 0, 0, false
+	}
+	n, err := strconv.Atoi(string(esc[2 : len(esc)-1]))
+	if err != nil {
+		return 0, 0, false
+	}
+	return esc[1], n, true
+}
+
+// indexMarks returns just the index marks within the text.
+func indexMarks(text []byte) []byte {
+	var out []byte
+	for i := bytes.IndexByte(text, '\x1b'); i != -1; i = bytes.IndexByte(text, '\x1b') {
+		j := bytes.IndexByte(text[i:], 'm')
+		if j == -1 {
+			break
+		}
+		if _, _, ok := parseIndexMark(text[i : i+j+1]); ok {
+			out = append(out, text[i:i+j+1]...)
+		}
+		text = text[i+j+1:]
+	}
+	return out
+}
+
+// stripIndexMarks returns the text without any index marks.
+func stripIndexMarks(text []byte) []byte {
+	if bytes.IndexByte(text, '\x1b') == -1 {
+		return text
+	}
+	var out []byte
+	for {
+		i := bytes.IndexByte(text, '\x1b')
+		if i == -1 {
+			return append(out, text...)
+		}
+		j := bytes.IndexByte(text[i:], 'm')
+		if j == -1 {
+			return append(out, text...)
+		}
+		out = append(out, text[:i]...)
+		if _, _, ok := parseIndexMark(text[i : i+j+1]); !ok {
+			out = append(out, text[i:i+j+1]...)
+		}
+		text = text[i+j+1:]
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import (
+	"bytes"
+
+	"github.com/rn/utils/mdv/brimtext"
+)
+
+// Line is a line of the text returned by MarkdownToLines.
+type Line struct {
+	// Spans are the runs of text making up the line.
+	Spans []Span
+	// Header is the header whose text is on this line, or nil.
+	Header *Header
+	// Section is the ID of the header of the section the line is in, empty
+	// before the first header.
+	Section string
+	// CodeBlock is the 0 based index, in document order, of the code block
+	// the line is part of, or -1.
+	CodeBlock int
+}
+
+// Span is a run of text within a Line sharing the same style and link.
+type Span struct {
+	Text string
+	// Style is the style the text would be shown in on a terminal; it is
+	// always the default style unless Options.Color was set.
+	Style brimtext.ANSIStyle
+	// Link is the target, as given in the markdown, of the link the text is
+	// part of, or empty. Links within tables are not included.
+	Link string
+}
+
+// String returns the text of the line without any styling.
+func (line *Line) String() string {
+	var text bytes.Buffer
+	for _, span := range line.Spans {
+		text.WriteString(span.Text)
+	}
+	return text.String()
+}
+
+// MarkdownToLines is the same as MarkdownToTextNoMetadata but returns the text
+// as styled spans rather than with ANSI Escape Codes, with each line recording
+// the document element it came from, for applications drawing the text with
+// their own toolkit. Sixel and kitty images can't be represented as spans and
+// are shown as half blocks, their colors approximated by the 16 ANSI colors.
+// If opts is nil the defaults will be used.
+func MarkdownToLines(markdown []byte, opts *Options) []Line {
+	opts = resolveOpts(opts)
+	if opts.Images == ImagesSixel || opts.Images == ImagesKitty {
+		opts.Images = ImagesBlocks
+	}
+	var headers []Header
+	rend := newRenderer(opts)
+	rend.index = true
+	rend.headers = &headers
+	txt := bytes.TrimSuffix(renderText(markdown, opts, rend), []byte("\n"))
+
+	style := brimtext.ANSIStyle{Foreground: -1, Background: -1}
+	var link, section string
+	codeBlock := -1
+	var lines []Line
+	for _, text := range bytes.Split(txt, []byte("\n")) {
+		line := Line{Section: section, CodeBlock: codeBlock}
+		var run bytes.Buffer
+		flush := func() {
+			if run.Len() > 0 {
+				line.Spans = append(line.Spans, Span{Text: run.String(), Style: style, Link: link})
+				run.Reset()
+			}
+		}
+		if indent := len(text) - len(bytes.TrimLeft(text, " ")); link != "" && indent > 0 {
+			// The indent of a link's continuation line isn't part of it.
+			line.Spans = append(line.Spans, Span{Text: string(text[:indent]), Style: style})
+			text = text[indent:]
+		}
+		for i := 0; i < len(text); i++ {
+			if text[i] != '\x1b' {
+				run.WriteByte(text[i])
+				continue
+			}
+			j := bytes.IndexByte(text[i:], 'm')
+			if j == -1 {
+				break
+			}
+			esc := text[i : i+j+1]
+			i += j
+			if kind, n, ok := parseIndexMark(esc); ok {
+				switch {
+				case kind == markHeader && n < len(headers):
+					line.Header = &headers[n]
+					section = headers[n].ID
+					line.Section = section
+				case kind == markLinkStart && n < len(rend.indexLinks):
+					flush()
+					link = rend.indexLinks[n]
+				case kind == markLinkEnd:
+					flush()
+					link = ""
+				case kind == markCodeStart:
+					codeBlock = n
+					line.CodeBlock = n
+				case kind == markCodeEnd:
+					codeBlock = -1
+				}
+				continue
+			}
+			if len(esc) > 2 && esc[1] == '[' {
+				flush()
+				style = style.Apply(string(esc[2 : len(esc)-1]))
+			}
+		}
+		flush()
+		lines = append(lines, line)
+	}
+	return lines
+}
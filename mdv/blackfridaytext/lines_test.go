@@ -0,0 +1,96 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import (
+	"strings"
+	"testing"
+)
+
+const linesDoc = "# Intro\n\nSome **bold** and a [link](https://example.com/a/very/long/path) here.\n\n```\ncode\n```\n\n## Next\n\n    more code\n"
+
+func TestMarkdownToLines(t *testing.T) {
+	link := "https://example.com/a/very/long/path"
+	want := []struct {
+		text      string
+		header    string
+		section   string
+		codeBlock int
+	}{
+		{"--[ Intro ]--", "intro", "intro", -1},
+		{"", "", "intro", -1},
+		{"    Some bold and a [link]", "", "intro", -1},
+		{"    " + link, "", "intro", -1},
+		{"    here.", "", "intro", -1},
+		{"", "", "intro", -1},
+		{"    code", "", "intro", 0},
+		{"", "", "intro", -1},
+		{"    --[ Next ]--", "next", "next", -1},
+		{"", "", "next", -1},
+		{"        more code", "", "next", 1},
+		{"", "", "next", -1},
+	}
+	lines := MarkdownToLines([]byte(linesDoc), &Options{Width: 40, Color: true})
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(want))
+	}
+	for i, line := range lines {
+		header := ""
+		if line.Header != nil {
+			header = line.Header.ID
+		}
+		w := want[i]
+		if line.String() != w.text || header != w.header || line.Section != w.section || line.CodeBlock != w.codeBlock {
+			t.Errorf("line %d: got %q header %q section %q code %d, want %+v", i, line.String(), header, line.Section, line.CodeBlock, w)
+		}
+	}
+
+	// Styles and links are carried by the spans.
+	for _, test := range []struct {
+		line, span int
+		text       string
+		bold       bool
+		foreground int
+		link       string
+	}{
+		{0, 1, "Intro", true, -1, ""},
+		{2, 1, "bold", true, -1, ""},
+		{2, 2, " and a ", false, -1, ""},
+		{2, 3, "[link]", false, 4, link},
+		// The indent of a link's continuation line isn't part of the link.
+		{3, 0, "    ", false, 4, ""},
+		{3, 1, link, false, 4, link},
+		{6, 1, "code", false, 2, ""},
+	} {
+		spans := lines[test.line].Spans
+		if test.span >= len(spans) {
+			t.Errorf("line %d has %d spans: %+v", test.line, len(spans), spans)
+			continue
+		}
+		span := spans[test.span]
+		if span.Text != test.text || span.Style.Bold != test.bold || span.Style.Foreground != test.foreground || span.Link != test.link {
+			t.Errorf("line %d span %d = %+v, want %+v", test.line, test.span, span, test)
+		}
+	}
+}
+
+func TestMarkdownToLinesText(t *testing.T) {
+	// Without color the lines are the text MarkdownToTextNoMetadata returns.
+	opts := &Options{Width: 40}
+	var text []string
+	for _, line := range MarkdownToLines([]byte(linesDoc), opts) {
+		text = append(text, line.String())
+	}
+	if got, want := strings.Join(text, "\n")+"\n", string(MarkdownToTextNoMetadata([]byte(linesDoc), opts)); got != want {
+		t.Errorf("lines:\n%s\ntext:\n%s", got, want)
+	}
+	for _, line := range MarkdownToLines([]byte(linesDoc), opts) {
+		for _, span := range line.Spans {
+			if span.Style.Bold || span.Style.Foreground != -1 {
+				t.Errorf("span %+v styled without Color", span)
+			}
+		}
+	}
+}
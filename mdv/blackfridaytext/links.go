@@ -0,0 +1,203 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rn/utils/mdv/brimtext"
+	"github.com/russross/blackfriday"
+)
+
+// Link is a link or image found by MarkdownLinks.
+type Link struct {
+	// Target is the link destination as given in the markdown, after
+	// resolving any reference.
+	Target string
+	// Image is true for images.
+	Image bool
+	// Auto is true for autolinks, such as a bare URL.
+	Auto bool
+	// Line is the 1 based line number of the link in the markdown; for
+	// reference style links that is where the reference is used, not where
+	// it is defined.
+	Line int
+}
+
+// MarkdownLinks returns the links and images of the markdown, in document
+// order, as parsed with the same configuration as MarkdownToTextNoMetadata.
+// The markdown should not contain any metadata; see MarkdownMetadata.
+func MarkdownLinks(markdown []byte) []Link {
+	collector := &linkCollector{
+		renderer: &renderer{
+			width:             1 << 30,
+			tableAlignOptions: brimtext.NewSimpleAlignOptions(),
+			headerIndent:      []byte("    "),
+		},
+	}
+	blackfriday.Markdown(collector.prepare(markdown, &Options{}), collector, extensions)
+
+	// Blackfriday doesn't say where the links are, so each is matched up
+	// with the next link in the source with the same target, or failing
+	// that with the next occurrence of the target at all.
+	sources := linkSources(markdown)
+	next, offset := 0, 0
+	for i := range collector.links {
+		link := &collector.links[i]
+		found := false
+		for j := next; j < len(sources); j++ {
+			if sources[j].matches(link.Target) {
+				link.Line = sources[j].line
+				next, offset, found = j+1, sources[j].offset, true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		if at := bytes.Index(markdown[offset:], []byte(link.Target)); at != -1 {
+			link.Line = bytes.Count(markdown[:offset+at], []byte("\n")) + 1
+		}
+	}
+	return collector.links
+}
+
+// linkSource is a link written in the markdown source.
+type linkSource struct {
+	target string
+	// auto is true for bare URLs, whose end Blackfriday may trim.
+	auto   bool
+	offset int
+	line   int
+}
+
+func (s *linkSource) matches(target string) bool {
+	return s.target == target || (s.auto && target != "" && strings.HasPrefix(s.target, target))
+}
+
+var (
+	linkRefDefRe = regexp.MustCompile(`^ {0,3}\[([^\]]+)\]:[ \t]*<?([^\s>]+)`)
+	linkInlineRe = regexp.MustCompile(`\]\([ \t]*(<[^>]*>|[^\s)]*)`)
+	linkRefRe    = regexp.MustCompile(`\[([^\]]+)\](?:[ \t]?\[([^\]]*)\])?`)
+	linkAutoRe   = regexp.MustCompile(`<((?:https?|ftp)://[^>\s]+|mailto:[^>\s]+|[^>\s@]+@[^>\s@]+)>|(?:https?|ftp)://[^\s<]+`)
+)
+
+// linkSources returns the links written in the markdown, in source order,
+// skipping code blocks and code spans: inline links and images, uses of
+// link references, and autolinks.
+func linkSources(markdown []byte) []linkSource {
+	lines := bytes.SplitAfter(markdown, []byte("\n"))
+	refs := map[string]string{}
+	for _, line := range lines {
+		if m := linkRefDefRe.FindSubmatch(line); m != nil {
+			refs[strings.ToLower(string(m[1]))] = string(m[2])
+		}
+	}
+
+	var sources []linkSource
+	var fence []byte
+	offset := 0
+	blank := true
+	for i, line := range lines {
+		start := offset
+		offset += len(line)
+		trimmed := bytes.TrimLeft(line, " ")
+		if fence != nil {
+			if bytes.HasPrefix(trimmed, fence) {
+				fence = nil
+			}
+			continue
+		}
+		if bytes.HasPrefix(trimmed, []byte("```")) || bytes.HasPrefix(trimmed, []byte("~~~")) {
+			fence = trimmed[:3]
+			continue
+		}
+		if blank && (len(line)-len(trimmed) >= 4 || bytes.HasPrefix(line, []byte("\t"))) {
+			// An indented code block, which stays one across blank lines.
+			continue
+		}
+		blank = len(bytes.TrimSpace(line)) == 0
+		if linkRefDefRe.Match(line) {
+			continue
+		}
+		line = blankCodeSpans(line)
+		add := func(at int, target string, auto bool) {
+			sources = append(sources, linkSource{target: target, auto: auto, offset: start + at, line: i + 1})
+		}
+		for _, m := range linkInlineRe.FindAllSubmatchIndex(line, -1) {
+			add(m[0], strings.Trim(string(line[m[2]:m[3]]), "<>"), false)
+		}
+		for _, m := range linkRefRe.FindAllSubmatchIndex(line, -1) {
+			if m[1] < len(line) && (line[m[1]] == '(' || line[m[1]] == ':') {
+				continue
+			}
+			id := string(line[m[2]:m[3]])
+			if m[4] != -1 && m[5] > m[4] {
+				id = string(line[m[4]:m[5]])
+			}
+			if target, ok := refs[strings.ToLower(id)]; ok {
+				add(m[0], target, false)
+			}
+		}
+		for _, m := range linkAutoRe.FindAllSubmatchIndex(line, -1) {
+			if m[2] != -1 {
+				add(m[0], string(line[m[2]:m[3]]), true)
+			} else {
+				add(m[0], string(line[m[0]:m[1]]), true)
+			}
+		}
+	}
+	sort.SliceStable(sources, func(i, j int) bool { return sources[i].offset < sources[j].offset })
+	return sources
+}
+
+// blankCodeSpans returns the line with any code spans replaced by spaces.
+func blankCodeSpans(line []byte) []byte {
+	out := append([]byte{}, line...)
+	for i := 0; i < len(out); i++ {
+		if out[i] != '`' {
+			continue
+		}
+		n := 1
+		for i+n < len(out) && out[i+n] == '`' {
+			n++
+		}
+		j := bytes.Index(out[i+n:], out[i:i+n])
+		if j == -1 {
+			i += n - 1
+			continue
+		}
+		end := i + n + j + n
+		for k := i; k < end; k++ {
+			out[k] = ' '
+		}
+		i = end - 1
+	}
+	return out
+}
+
+// linkCollector is the text renderer, additionally recording the links.
+type linkCollector struct {
+	*renderer
+	links []Link
+}
+
+func (rend *linkCollector) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	rend.links = append(rend.links, Link{Target: string(link), Auto: true})
+	rend.renderer.AutoLink(out, link, kind)
+}
+
+func (rend *linkCollector) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+	rend.links = append(rend.links, Link{Target: string(link), Image: true})
+	rend.renderer.Image(out, link, title, alt)
+}
+
+func (rend *linkCollector) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+	rend.links = append(rend.links, Link{Target: string(link)})
+	rend.renderer.Link(out, link, title, content)
+}
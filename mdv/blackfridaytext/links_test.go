@@ -0,0 +1,63 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarkdownLinks(t *testing.T) {
+	doc := `Summary mentions [a](a.md).
+///
+
+See ` + "`a.md`" + ` or a.md, then [again](a.md) and [ref][r].
+
+    [in code](nope.md)
+
+![img](pic.png "title") and <https://example.com/x>
+and https://example.com/y. [a](a.md)
+
+[b]: b.md
+
+[R]: r.md
+[b] is a shortcut reference.
+`
+	want := []Link{
+		{Target: "a.md", Line: 1},
+		{Target: "a.md", Line: 4},
+		{Target: "r.md", Line: 4},
+		{Target: "pic.png", Image: true, Line: 8},
+		{Target: "https://example.com/x", Auto: true, Line: 8},
+		{Target: "https://example.com/y", Auto: true, Line: 9},
+		{Target: "a.md", Line: 9},
+		{Target: "b.md", Line: 14},
+	}
+	if got := MarkdownLinks([]byte(doc)); !reflect.DeepEqual(got, want) {
+		t.Errorf("MarkdownLinks:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestMarkdownLinksSummaryMark(t *testing.T) {
+	// Without removing the mark this is a Setext header followed by a
+	// paragraph, not one paragraph.
+	doc := "[one](1.md)\n///\n[two](2.md)\n"
+	want := []Link{{Target: "1.md", Line: 1}, {Target: "2.md", Line: 3}}
+	if got := MarkdownLinks([]byte(doc)); !reflect.DeepEqual(got, want) {
+		t.Errorf("MarkdownLinks:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestBlankCodeSpans(t *testing.T) {
+	for _, test := range []struct{ line, want string }{
+		{"a `b` c", "a     c"},
+		{"``x ` y`` z", "          z"},
+		{"unclosed ` x", "unclosed ` x"},
+	} {
+		if got := string(blankCodeSpans([]byte(test.line))); got != test.want {
+			t.Errorf("blankCodeSpans(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
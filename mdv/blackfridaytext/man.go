@@ -0,0 +1,459 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"github.com/russross/blackfriday"
+)
+
+// ManOptions contains the configuration for MarkdownToMan. Each value is only
+// used if the markdown has no metadata item of the same name.
+type ManOptions struct {
+	// Title is the name of the page, such as "mdv".
+	Title string
+	// Section is the manual section; "1" if empty.
+	Section string
+	// Date is the date of the last nontrivial change to the page.
+	Date string
+	// Source is the source of the command, such as "mdv 1.0".
+	Source string
+	// Manual is the title of the manual, such as "General Commands Manual".
+	Manual string
+}
+
+// MarkdownToMan parses the markdown, including any metadata, using the
+// Blackfriday Markdown Processor and returns it as a man(7) page, suitable for
+// installing as is. If opts is nil the defaults will be used.
+//
+// The metadata items Title, Section, Date, Source and Manual override those in
+// opts and are used for the .TH line. The metadata items Name and Synopsis, if
+// present, become the NAME and SYNOPSIS sections; Name is typically "cmd -
+// one line description". If there is no Title metadata item, the first word
+// of Name is used in preference to opts.Title.
+//
+// Level 1 headers become sections (.SH), level 2 headers subsections (.SS),
+// and deeper headers bold paragraphs. Tables are emitted for tbl(1), so the
+// page should be formatted with "man -t" or a man that runs tbl, as all
+// common ones do.
+func MarkdownToMan(markdown []byte, opts *ManOptions) []byte {
+	metadata, position := MarkdownMetadata(markdown)
+	ropts := &ManOptions{}
+	if opts != nil {
+		*ropts = *opts
+	}
+	var name, synopsis, title string
+	for _, item := range metadata {
+		switch strings.ToLower(item[0]) {
+		case "title":
+			title = item[1]
+		case "section":
+			ropts.Section = item[1]
+		case "date":
+			ropts.Date = item[1]
+		case "source":
+			ropts.Source = item[1]
+		case "manual":
+			ropts.Manual = item[1]
+		case "name":
+			name = item[1]
+		case "synopsis":
+			synopsis = item[1]
+		}
+	}
+	if title != "" {
+		ropts.Title = title
+	} else if name != "" {
+		ropts.Title = strings.Fields(name)[0]
+	}
+	if ropts.Section == "" {
+		ropts.Section = "1"
+	}
+	rend := &manRenderer{}
+	var out bytes.Buffer
+	fmt.Fprintf(&out, ".TH %s %s %s %s %s\n", manQuote(strings.ToUpper(ropts.Title)), manQuote(ropts.Section), manQuote(ropts.Date), manQuote(ropts.Source), manQuote(ropts.Manual))
+	if name != "" {
+		out.WriteString(".SH NAME\n")
+		out.Write(manEscape([]byte(name)))
+		out.WriteByte('\n')
+	}
+	if synopsis != "" {
+		out.WriteString(".SH SYNOPSIS\n")
+		out.Write(manEscape([]byte(synopsis)))
+		out.WriteByte('\n')
+	}
+	body := removeSummaryMarks(markdown[position:])
+	out.Write(blackfriday.Markdown(body, rend, extensions))
+	return manCleanup(out.Bytes())
+}
+
+// manRenderer is a blackfriday.Renderer producing man(7) output.
+type manRenderer struct {
+	// listCounters tracks the last item number of each nested list; -1 for
+	// unordered lists.
+	listCounters []int
+}
+
+func (rend *manRenderer) BlockCode(out *bytes.Buffer, text []byte, lang string) {
+	text = bytes.TrimSuffix(text, []byte("\n"))
+	manMacro(out, ".PP")
+	manMacro(out, ".RS 4")
+	manMacro(out, ".nf")
+	for _, line := range bytes.Split(text, []byte("\n")) {
+		if len(line) == 0 {
+			out.WriteString("\\&")
+		} else {
+			out.Write(manEscape(line))
+		}
+		out.WriteByte('\n')
+	}
+	manMacro(out, ".fi")
+	manMacro(out, ".RE")
+}
+
+func (rend *manRenderer) BlockQuote(out *bytes.Buffer, text []byte) {
+	manMacro(out, ".RS 4")
+	out.Write(text)
+	manMacro(out, ".RE")
+}
+
+func (rend *manRenderer) BlockHtml(out *bytes.Buffer, text []byte) {
+}
+
+func (rend *manRenderer) Header(out *bytes.Buffer, text func() bool, level int, id string) {
+	oPos := out.Len()
+	switch level {
+	case 1:
+		manMacro(out, ".SH ")
+	case 2:
+		manMacro(out, ".SS ")
+	default:
+		manMacro(out, ".PP")
+		out.WriteString("\\fB")
+	}
+	tPos := out.Len()
+	if !text() {
+		out.Truncate(oPos)
+		return
+	}
+	if level == 1 {
+		title := manUpper(out.Bytes()[tPos:])
+		out.Truncate(tPos)
+		out.Write(title)
+	} else if level > 2 {
+		out.WriteString("\\fP")
+	}
+	out.WriteByte('\n')
+}
+
+func (rend *manRenderer) HRule(out *bytes.Buffer) {
+	manMacro(out, ".sp")
+}
+
+func (rend *manRenderer) List(out *bytes.Buffer, text func() bool, flags int) {
+	oPos := out.Len()
+	counter := 0
+	if flags&blackfriday.LIST_TYPE_ORDERED == 0 {
+		counter = -1
+	}
+	rend.listCounters = append(rend.listCounters, counter)
+	nested := len(rend.listCounters) > 1
+	if nested {
+		manMacro(out, ".RS 4")
+	}
+	ok := text()
+	rend.listCounters = rend.listCounters[:len(rend.listCounters)-1]
+	if !ok {
+		out.Truncate(oPos)
+		return
+	}
+	if nested {
+		manMacro(out, ".RE")
+	}
+}
+
+func (rend *manRenderer) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	text = bytes.TrimPrefix(bytes.TrimSpace(text), []byte(".PP\n"))
+	text = bytes.Replace(text, []byte("\n.PP\n"), []byte("\n.IP\n"), -1)
+	switch {
+	case flags&blackfriday.LIST_TYPE_TERM != 0:
+		manMacro(out, ".TP")
+		out.WriteString("\\fB")
+		out.Write(text)
+		out.WriteString("\\fP\n")
+		return
+	case flags&blackfriday.LIST_TYPE_DEFINITION != 0:
+	default:
+		top := len(rend.listCounters) - 1
+		if top >= 0 && rend.listCounters[top] >= 0 {
+			rend.listCounters[top]++
+			manMacro(out, ".IP "+strconv.Itoa(rend.listCounters[top])+". 4")
+		} else {
+			manMacro(out, ".IP \\(bu 2")
+		}
+	}
+	out.Write(text)
+	out.WriteByte('\n')
+}
+
+func (rend *manRenderer) Paragraph(out *bytes.Buffer, text func() bool) {
+	oPos := out.Len()
+	manMacro(out, ".PP")
+	if !text() {
+		out.Truncate(oPos)
+		return
+	}
+	out.WriteByte('\n')
+}
+
+func (rend *manRenderer) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int) {
+	manMacro(out, ".TS")
+	out.WriteString("allbox tab(\t);\n")
+	var format []string
+	for _, flags := range columnData {
+		switch {
+		case flags&blackfriday.TABLE_ALIGNMENT_CENTER == blackfriday.TABLE_ALIGNMENT_CENTER:
+			format = append(format, "c")
+		case flags&blackfriday.TABLE_ALIGNMENT_RIGHT != 0:
+			format = append(format, "r")
+		default:
+			format = append(format, "l")
+		}
+	}
+	if len(header) > 0 {
+		out.WriteString(strings.Join(format, "B ") + "B\n")
+	}
+	out.WriteString(strings.Join(format, " ") + ".\n")
+	out.Write(header)
+	out.Write(body)
+	manMacro(out, ".TE")
+}
+
+func (rend *manRenderer) TableRow(out *bytes.Buffer, text []byte) {
+	out.Write(bytes.TrimSuffix(text, []byte("\t")))
+	out.WriteByte('\n')
+}
+
+func (rend *manRenderer) TableHeaderCell(out *bytes.Buffer, text []byte, flags int) {
+	rend.TableCell(out, text, flags)
+}
+
+func (rend *manRenderer) TableCell(out *bytes.Buffer, text []byte, flags int) {
+	text = bytes.Replace(text, []byte("\t"), []byte(" "), -1)
+	if bytes.IndexByte(text, '\n') >= 0 {
+		out.WriteString("T{\n")
+		out.Write(text)
+		out.WriteString("\nT}")
+	} else {
+		out.Write(text)
+	}
+	out.WriteByte('\t')
+}
+
+func (rend *manRenderer) Footnotes(out *bytes.Buffer, text func() bool) {
+	oPos := out.Len()
+	manMacro(out, ".SH NOTES")
+	if !text() {
+		out.Truncate(oPos)
+	}
+}
+
+func (rend *manRenderer) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	manMacro(out, ".IP ["+string(name)+"] 4")
+	out.Write(bytes.TrimPrefix(bytes.TrimSpace(text), []byte(".PP\n")))
+	out.WriteByte('\n')
+}
+
+func (rend *manRenderer) TitleBlock(out *bytes.Buffer, text []byte) {
+}
+
+func (rend *manRenderer) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	out.WriteString("\\fI")
+	out.Write(manEscape(bytes.TrimPrefix(link, []byte("mailto:"))))
+	out.WriteString("\\fP")
+}
+
+func (rend *manRenderer) CodeSpan(out *bytes.Buffer, text []byte) {
+	out.WriteString("\\fB")
+	out.Write(manEscape(text))
+	out.WriteString("\\fP")
+}
+
+func (rend *manRenderer) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("\\fB")
+	out.Write(text)
+	out.WriteString("\\fP")
+}
+
+func (rend *manRenderer) Emphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("\\fI")
+	out.Write(text)
+	out.WriteString("\\fP")
+}
+
+func (rend *manRenderer) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+	out.WriteByte('[')
+	if len(alt) > 0 {
+		out.Write(manEscape(alt))
+	} else {
+		out.Write(manEscape(link))
+	}
+	out.WriteByte(']')
+}
+
+func (rend *manRenderer) LineBreak(out *bytes.Buffer) {
+	manMacro(out, ".br")
+}
+
+func (rend *manRenderer) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+	if len(content) > 0 && !bytes.Equal(content, manEscape(link)) {
+		out.Write(content)
+		out.WriteString(" (\\fI")
+		out.Write(manEscape(link))
+		out.WriteString("\\fP)")
+		return
+	}
+	out.WriteString("\\fI")
+	out.Write(manEscape(link))
+	out.WriteString("\\fP")
+}
+
+func (rend *manRenderer) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+}
+
+func (rend *manRenderer) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("\\f(BI")
+	out.Write(text)
+	out.WriteString("\\fP")
+}
+
+func (rend *manRenderer) StrikeThrough(out *bytes.Buffer, text []byte) {
+	out.WriteString("~~")
+	out.Write(text)
+	out.WriteString("~~")
+}
+
+func (rend *manRenderer) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	out.WriteString("[" + strconv.Itoa(id) + "]")
+}
+
+func (rend *manRenderer) Entity(out *bytes.Buffer, entity []byte) {
+	out.Write(manEscape([]byte(html.UnescapeString(string(entity)))))
+}
+
+func (rend *manRenderer) NormalText(out *bytes.Buffer, text []byte) {
+	bs := out.Bytes()
+	out.Write(manEscapeText(text, len(bs) == 0 || bs[len(bs)-1] == '\n'))
+}
+
+func (rend *manRenderer) DocumentHeader(out *bytes.Buffer) {
+}
+
+func (rend *manRenderer) DocumentFooter(out *bytes.Buffer) {
+}
+
+func (rend *manRenderer) GetFlags() int {
+	return 0
+}
+
+// manMacro writes the macro on a line of its own.
+func manMacro(out *bytes.Buffer, macro string) {
+	bs := out.Bytes()
+	if len(bs) > 0 && bs[len(bs)-1] != '\n' {
+		out.WriteByte('\n')
+	}
+	out.WriteString(macro)
+	if !strings.HasSuffix(macro, " ") {
+		out.WriteByte('\n')
+	}
+}
+
+// manEscape escapes text so troff will output it literally. The text is
+// assumed to start at the beginning of a line, where a leading "." or "'"
+// needs escaping.
+func manEscape(text []byte) []byte {
+	return manEscapeText(text, true)
+}
+
+// manEscapeText is manEscape for text that may start in the middle of a line.
+func manEscapeText(text []byte, lineStart bool) []byte {
+	var out bytes.Buffer
+	for _, c := range text {
+		if lineStart && (c == '.' || c == '\'') {
+			out.WriteString("\\&")
+		}
+		switch c {
+		case '\\':
+			out.WriteString("\\e")
+		case '-':
+			out.WriteString("\\-")
+		default:
+			out.WriteByte(c)
+		}
+		lineStart = c == '\n'
+	}
+	return out.Bytes()
+}
+
+// manUpper upper cases the text, leaving any escape sequences intact.
+func manUpper(text []byte) []byte {
+	out := make([]byte, 0, len(text))
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c != '\\' {
+			out = append(out, bytes.ToUpper([]byte{c})...)
+			continue
+		}
+		n := 2
+		if i+2 < len(text) && text[i+1] == 'f' && text[i+2] == '(' {
+			n = 5
+		} else if i+1 < len(text) && text[i+1] == 'f' {
+			n = 3
+		} else if i+1 < len(text) && text[i+1] == '(' {
+			n = 4
+		}
+		if i+n > len(text) {
+			n = len(text) - i
+		}
+		out = append(out, text[i:i+n]...)
+		i += n - 1
+	}
+	return out
+}
+
+// manQuote returns the value as a quoted macro argument.
+func manQuote(value string) string {
+	return `"` + strings.Replace(string(manEscape([]byte(value))), `"`, `\(dq`, -1) + `"`
+}
+
+// manCleanup removes blank lines, which troff would otherwise turn into extra
+// vertical space, and leading whitespace, which troff would keep. Code blocks
+// and tables are left alone.
+func manCleanup(roff []byte) []byte {
+	var out bytes.Buffer
+	verbatim := false
+	for _, line := range bytes.Split(roff, []byte("\n")) {
+		if !verbatim {
+			line = bytes.TrimLeft(line, " \t")
+			if len(line) == 0 {
+				continue
+			}
+		}
+		switch {
+		case bytes.Equal(line, []byte(".nf")), bytes.Equal(line, []byte(".TS")):
+			verbatim = true
+		case bytes.Equal(line, []byte(".fi")), bytes.Equal(line, []byte(".TE")):
+			verbatim = false
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
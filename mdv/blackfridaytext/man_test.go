@@ -0,0 +1,124 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const manDoc = `Name: npterm - serial console terminal
+Synopsis: npterm [options] target
+
+# Description
+
+A *terminal* for **consoles**, see ` + "`" + `npterm -h` + "`" + ` and [the repo](https://github.com/rn/utils).
+.dot at start and a back\slash.
+
+## Options
+
+- one
+- two
+  1. nested
+  2. list
+
+| Flag | Meaning |
+|------|--------:|
+| -a   | append  |
+
+    code line
+    .TH in code
+
+### Deep header
+
+> quoted
+`
+
+const manWant = `.TH "NPTERM" "1" "2024\-01\-02" "" ""
+.SH NAME
+npterm \- serial console terminal
+.SH SYNOPSIS
+npterm [options] target
+.SH DESCRIPTION
+.PP
+A \fIterminal\fP for \fBconsoles\fP, see \fBnpterm \-h\fP and the repo (\fIhttps://github.com/rn/utils\fP).
+\&.dot at start and a back\eslash.
+.SS Options
+.IP \(bu 2
+one
+.IP \(bu 2
+two
+.RS 4
+.IP 1. 4
+nested
+.IP 2. 4
+list
+.RE
+.TS
+allbox tab(	);
+lB rB
+l r.
+Flag	Meaning
+\-a	append
+.TE
+.PP
+.RS 4
+.nf
+code line
+\&.TH in code
+.fi
+.RE
+.PP
+\fBDeep header\fP
+.RS 4
+.PP
+quoted
+.RE
+`
+
+func TestMarkdownToMan(t *testing.T) {
+	got := string(MarkdownToMan([]byte(manDoc), &ManOptions{Title: "ignored", Date: "2024-01-02"}))
+	if got != manWant {
+		t.Errorf("MarkdownToMan:\n%s", lineDiff(got, manWant))
+	}
+}
+
+func TestMarkdownToManOptions(t *testing.T) {
+	for _, test := range []struct {
+		markdown string
+		opts     *ManOptions
+		want     string
+	}{
+		{"text\n", nil, ".TH \"\" \"1\" \"\" \"\" \"\"\n"},
+		{"text\n", &ManOptions{Title: "mdv", Section: "7", Source: "mdv 1.0", Manual: "Misc"}, ".TH \"MDV\" \"7\" \"\" \"mdv 1.0\" \"Misc\"\n"},
+		{"Title: x\nSection: 5\nDate: d\nSource: s\nManual: m\n\ntext\n", &ManOptions{Title: "mdv", Section: "7"}, ".TH \"X\" \"5\" \"d\" \"s\" \"m\"\n"},
+		{"Name: tool - does \"things\"\n\ntext\n", nil, ".TH \"TOOL\" \"1\" \"\" \"\" \"\"\n"},
+	} {
+		got := string(MarkdownToMan([]byte(test.markdown), test.opts))
+		if line := got[:strings.Index(got, "\n")+1]; line != test.want {
+			t.Errorf("MarkdownToMan(%q) starts %q, want %q", test.markdown, line, test.want)
+		}
+	}
+}
+
+// lineDiff returns the first line where got and want differ, with its
+// number.
+func lineDiff(got, want string) string {
+	g, w := strings.Split(got, "\n"), strings.Split(want, "\n")
+	for i := 0; i < len(g) || i < len(w); i++ {
+		var gl, wl string
+		if i < len(g) {
+			gl = g[i]
+		}
+		if i < len(w) {
+			wl = w[i]
+		}
+		if gl != wl {
+			return fmt.Sprintf("line %d:\n got %q\nwant %q", i+1, gl, wl)
+		}
+	}
+	return "same"
+}
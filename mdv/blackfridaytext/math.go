@@ -0,0 +1,973 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rn/utils/mdv/brimtext"
+)
+
+// Math spans are replaced before parsing by marks, "\x1bM" number "m", which
+// Blackfriday passes through untouched, so "_" and "*" within the TeX can't be
+// taken for emphasis. NormalText then replaces the marks with the rendered
+// math.
+const markMath = 'M'
+
+// mathSpan is a TeX math span found by extractMath.
+type mathSpan struct {
+	tex     string
+	display bool
+}
+
+// extractMath replaces the $...$ and $$...$$ math spans of the markdown, other
+// than those in code, with marks and records them in rend.math. Like Pandoc,
+// an opening $ must not be followed by a space and a closing $ must not be
+// preceded by a space or followed by a digit, so prices such as "$5 or $10"
+// are left alone. A $ can also be escaped as \$.
+func (rend *renderer) extractMath(markdown []byte) []byte {
+	if bytes.IndexByte(markdown, '$') == -1 {
+		return markdown
+	}
+	var out bytes.Buffer
+	lines := bytes.SplitAfter(markdown, []byte("\n"))
+	var fence []byte
+	blank := true
+	for l := 0; l < len(lines); l++ {
+		line := lines[l]
+		trimmed := bytes.TrimLeft(line, " ")
+		switch {
+		case fence != nil:
+			if bytes.HasPrefix(trimmed, fence) {
+				fence = nil
+			}
+			out.Write(line)
+			continue
+		case bytes.HasPrefix(trimmed, []byte("```")) || bytes.HasPrefix(trimmed, []byte("~~~")):
+			fence = trimmed[:3]
+			out.Write(line)
+			continue
+		case blank && (bytes.HasPrefix(line, []byte("    ")) || bytes.HasPrefix(line, []byte("\t"))):
+			out.Write(line)
+			continue
+		}
+		blank = len(bytes.TrimSpace(line)) == 0
+		// Display math may span lines, up to the end of the paragraph.
+		text := line
+		end := l
+		if bytes.Contains(line, []byte("$$")) && bytes.Count(line, []byte("$$"))%2 == 1 {
+			for end+1 < len(lines) && len(bytes.TrimSpace(lines[end+1])) > 0 {
+				end++
+				text = append(append([]byte{}, text...), lines[end]...)
+				if bytes.Contains(lines[end], []byte("$$")) {
+					break
+				}
+			}
+			if !bytes.Contains(lines[end], []byte("$$")) || end == l {
+				text, end = line, l
+			}
+		}
+		out.Write(rend.extractMathLine(text))
+		l = end
+	}
+	return out.Bytes()
+}
+
+// extractMathLine replaces the math spans of the text, skipping code spans.
+func (rend *renderer) extractMathLine(text []byte) []byte {
+	var out bytes.Buffer
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text):
+			// Blackfriday doesn't unescape \$ itself.
+			if text[i+1] != '$' {
+				out.WriteByte(c)
+			}
+			out.WriteByte(text[i+1])
+			i++
+			continue
+		case c == '`':
+			n := 1
+			for i+n < len(text) && text[i+n] == '`' {
+				n++
+			}
+			ticks := text[i : i+n]
+			if j := bytes.Index(text[i+n:], ticks); j != -1 {
+				out.Write(text[i : i+n+j+n])
+				i += n + j + n - 1
+				continue
+			}
+			out.Write(ticks)
+			i += n - 1
+			continue
+		case c != '$':
+			out.WriteByte(c)
+			continue
+		}
+		if i+1 < len(text) && text[i+1] == '$' {
+			if j := bytes.Index(text[i+2:], []byte("$$")); j > 0 {
+				rend.writeMathMark(&out, string(text[i+2:i+2+j]), true)
+				i += 2 + j + 1
+				continue
+			}
+			out.WriteString("$$")
+			i++
+			continue
+		}
+		if i+1 >= len(text) || text[i+1] == ' ' || text[i+1] == '\n' {
+			out.WriteByte(c)
+			continue
+		}
+		closing := -1
+		for j := i + 1; j < len(text) && text[j] != '\n'; j++ {
+			if text[j] == '\\' {
+				j++
+				continue
+			}
+			if text[j] == '$' {
+				if text[j-1] != ' ' && (j+1 >= len(text) || text[j+1] < '0' || text[j+1] > '9') {
+					closing = j
+				}
+				break
+			}
+		}
+		if closing == -1 {
+			out.WriteByte(c)
+			continue
+		}
+		rend.writeMathMark(&out, string(text[i+1:closing]), false)
+		i = closing
+	}
+	return out.Bytes()
+}
+
+func (rend *renderer) writeMathMark(out *bytes.Buffer, tex string, display bool) {
+	out.Write(indexMark(markMath, len(rend.math)))
+	rend.math = append(rend.math, mathSpan{tex: strings.TrimSpace(tex), display: display})
+}
+
+// replaceMath replaces the math marks in the text with the rendered math.
+func (rend *renderer) replaceMath(out *bytes.Buffer, text []byte) {
+	for {
+		i := bytes.Index(text, []byte{'\x1b', markMath})
+		if i == -1 {
+			rend.writeText(out, text)
+			return
+		}
+		j := bytes.IndexByte(text[i:], 'm')
+		n, err := -1, error(nil)
+		if j != -1 {
+			n, err = strconv.Atoi(string(text[i+2 : i+j]))
+		}
+		if n < 0 || err != nil || n >= len(rend.math) {
+			rend.writeText(out, text[:i+1])
+			text = text[i+1:]
+			continue
+		}
+		rend.writeText(out, text[:i])
+		text = text[i+j+1:]
+		rend.renderMath(out, rend.math[n])
+	}
+}
+
+// renderMath writes the math as Unicode, display math centered on lines of
+// its own, or the raw TeX in the code style if it uses anything unsupported.
+func (rend *renderer) renderMath(out *bytes.Buffer, span mathSpan) {
+	p := &mathParser{tokens: mathTokens(span.tex), display: span.display, ok: true}
+	b := p.layout(p.parseList(""), false)
+	if p.pos < len(p.tokens) {
+		p.ok = false
+	}
+	if !span.display {
+		if !p.ok || len(b.lines) != 1 {
+			tex := "$" + span.tex + "$"
+			rend.CodeSpan(out, []byte(tex))
+			return
+		}
+		out.Write(bytes.Replace([]byte(b.lines[0]), []byte(" "), []byte{markNBSP}, -1))
+		return
+	}
+	rend.ensureNewLine(out)
+	lines := b.lines
+	pad := ""
+	if !p.ok {
+		lines = strings.Split("$$"+span.tex+"$$", "\n")
+	} else if w := (rend.width - rend.currentIndent - b.width()) / 2; w > 0 {
+		pad = strings.Repeat(string(markNBSP), w)
+	}
+	for _, line := range lines {
+		out.WriteString(pad)
+		if !p.ok && rend.color {
+			out.Write(brimtext.ANSIEscape.FGreen)
+		}
+		out.Write(bytes.Replace([]byte(strings.TrimRight(line, " ")), []byte(" "), []byte{markNBSP}, -1))
+		if !p.ok && rend.color {
+			out.Write(brimtext.ANSIEscape.Reset)
+		}
+		out.WriteByte(markLineBreak)
+	}
+}
+
+// mathBox is laid out math: lines of equal width, with base the index of the
+// line the surrounding text lines up with.
+type mathBox struct {
+	lines []string
+	base  int
+}
+
+func textBox(s string) mathBox {
+	return mathBox{lines: []string{s}}
+}
+
+func (b mathBox) width() int {
+	if len(b.lines) == 0 {
+		return 0
+	}
+	return utf8.RuneCountInString(b.lines[0])
+}
+
+// hconcat lays the boxes out side by side, lined up on their base lines.
+func hconcat(boxes ...mathBox) mathBox {
+	above, below := 0, 0
+	for _, b := range boxes {
+		if b.base > above {
+			above = b.base
+		}
+		if n := len(b.lines) - b.base - 1; n > below {
+			below = n
+		}
+	}
+	lines := make([]string, above+below+1)
+	for r := range lines {
+		var line bytes.Buffer
+		for _, b := range boxes {
+			if i := r - (above - b.base); i >= 0 && i < len(b.lines) {
+				line.WriteString(b.lines[i])
+			} else {
+				line.WriteString(strings.Repeat(" ", b.width()))
+			}
+		}
+		lines[r] = line.String()
+	}
+	return mathBox{lines: lines, base: above}
+}
+
+// vstack stacks the boxes, centered, with base the base of the box at index
+// base.
+func vstack(base int, boxes ...mathBox) mathBox {
+	width := 0
+	for _, b := range boxes {
+		if w := b.width(); w > width {
+			width = w
+		}
+	}
+	var result mathBox
+	for i, b := range boxes {
+		if i == base {
+			result.base = len(result.lines) + b.base
+		}
+		left := (width - b.width()) / 2
+		for _, line := range b.lines {
+			result.lines = append(result.lines, strings.Repeat(" ", left)+line+strings.Repeat(" ", width-left-b.width()))
+		}
+	}
+	return result
+}
+
+// mathKind controls the spacing around an item.
+type mathKind int
+
+const (
+	mathOrd mathKind = iota
+	mathBin
+	mathRel
+	mathOpen
+	mathClose
+	mathPunct
+	mathLargeOp
+)
+
+type mathItem struct {
+	box  mathBox
+	kind mathKind
+	// limits is true for large operators whose scripts go above and below in
+	// display math.
+	limits   bool
+	sup, sub *mathBox
+}
+
+type mathParser struct {
+	tokens  []string
+	pos     int
+	display bool
+	// script is true while parsing a superscript or subscript.
+	script bool
+	ok     bool
+}
+
+// mathTokens splits TeX into commands ("\alpha", "\,"), single characters and
+// braces, dropping white space.
+func mathTokens(tex string) []string {
+	var tokens []string
+	runes := []rune(tex)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+		case r == '\\' && i+1 < len(runes):
+			j := i + 1
+			if unicode.IsLetter(runes[j]) {
+				for j < len(runes) && unicode.IsLetter(runes[j]) {
+					j++
+				}
+			} else {
+				j++
+			}
+			name := string(runes[i+1 : j])
+			tokens = append(tokens, string(runes[i:j]))
+			i = j - 1
+			if !mathTextCommands[name] {
+				continue
+			}
+			// Keep the argument of \text and the like, spaces and all, as a
+			// single "{...}" token.
+			for j < len(runes) && unicode.IsSpace(runes[j]) {
+				j++
+			}
+			if j >= len(runes) || runes[j] != '{' {
+				continue
+			}
+			depth := 0
+			for k := j; k < len(runes); k++ {
+				if runes[k] == '{' {
+					depth++
+				} else if runes[k] == '}' {
+					depth--
+					if depth == 0 {
+						tokens = append(tokens, string(runes[j:k+1]))
+						i = k
+						break
+					}
+				}
+			}
+		default:
+			tokens = append(tokens, string(r))
+		}
+	}
+	return tokens
+}
+
+func (p *mathParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t
+}
+
+func (p *mathParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+// parseList parses items up to the stop token ("}" or "\right"), which is
+// consumed, or the end.
+func (p *mathParser) parseList(stop string) []mathItem {
+	var items []mathItem
+	for p.ok {
+		t := p.peek()
+		if t == "" {
+			if stop != "" {
+				p.ok = false
+			}
+			return items
+		}
+		if t == stop {
+			p.pos++
+			return items
+		}
+		if t == "}" || t == "\\right" {
+			p.ok = false
+			return items
+		}
+		if t == "^" || t == "_" {
+			p.pos++
+			if len(items) == 0 {
+				items = append(items, mathItem{box: textBox("")})
+			}
+			item := &items[len(items)-1]
+			script := p.parseScript()
+			if t == "^" {
+				if item.sup != nil {
+					p.ok = false
+				}
+				item.sup = &script
+			} else {
+				if item.sub != nil {
+					p.ok = false
+				}
+				item.sub = &script
+			}
+			continue
+		}
+		if t == "'" {
+			p.pos++
+			items = append(items, mathItem{box: textBox("′")})
+			continue
+		}
+		items = append(items, p.parseAtom())
+	}
+	return items
+}
+
+// parseScript parses the single token or group after ^ or _.
+func (p *mathParser) parseScript() mathBox {
+	script := p.script
+	p.script = true
+	defer func() { p.script = script }()
+	if p.peek() == "{" {
+		p.pos++
+		return p.layout(p.parseList("}"), true)
+	}
+	item := p.parseAtom()
+	return p.layout([]mathItem{item}, true)
+}
+
+// parseGroup parses a required argument, a group or a single token.
+func (p *mathParser) parseGroup() mathBox {
+	if p.peek() == "{" {
+		p.pos++
+		return p.layout(p.parseList("}"), p.script)
+	}
+	if p.peek() == "" {
+		p.ok = false
+		return textBox("")
+	}
+	return p.layout([]mathItem{p.parseAtom()}, p.script)
+}
+
+// rawGroup returns the text of the argument of \text and the like, which
+// mathTokens keeps as a single "{...}" token.
+func (p *mathParser) rawGroup() string {
+	t := p.next()
+	if len(t) < 2 || t[0] != '{' {
+		p.ok = false
+		return t
+	}
+	return t[1 : len(t)-1]
+}
+
+func (p *mathParser) parseAtom() mathItem {
+	t := p.next()
+	if t == "{" {
+		return mathItem{box: p.layout(p.parseList("}"), p.script)}
+	}
+	if !strings.HasPrefix(t, "\\") {
+		switch t {
+		case "+", "*":
+			if t == "*" {
+				t = "∗"
+			}
+			return mathItem{box: textBox(t), kind: mathBin}
+		case "-":
+			return mathItem{box: textBox("−"), kind: mathBin}
+		case "=", "<", ">":
+			return mathItem{box: textBox(t), kind: mathRel}
+		case ",", ";":
+			return mathItem{box: textBox(t), kind: mathPunct}
+		case "(", "[":
+			return mathItem{box: textBox(t), kind: mathOpen}
+		case ")", "]":
+			return mathItem{box: textBox(t), kind: mathClose}
+		case "&":
+			p.ok = false
+		}
+		return mathItem{box: textBox(t)}
+	}
+	name := t[1:]
+	if s, ok := mathSymbols[name]; ok {
+		return mathItem{box: textBox(s.text), kind: s.kind, limits: s.limits}
+	}
+	if s, ok := mathFunctions[name]; ok {
+		return mathItem{box: textBox(name), kind: mathLargeOp, limits: s}
+	}
+	switch name {
+	case "frac", "dfrac", "tfrac":
+		num := p.parseGroup()
+		den := p.parseGroup()
+		return mathItem{box: p.fraction(num, den)}
+	case "sqrt":
+		index := ""
+		if p.peek() == "[" {
+			p.pos++
+			var b bytes.Buffer
+			for t := p.next(); t != "]"; t = p.next() {
+				if t == "" {
+					p.ok = false
+					break
+				}
+				b.WriteString(t)
+			}
+			index = b.String()
+		}
+		return mathItem{box: p.root(index, p.parseGroup())}
+	case "text", "textrm", "textit", "textbf", "mbox", "operatorname":
+		return mathItem{box: textBox(p.rawGroup())}
+	case "mathrm", "mathit", "mathbf", "mathsf", "mathtt", "mathcal", "boldsymbol":
+		return mathItem{box: p.parseGroup()}
+	case "mathbb":
+		b := p.parseGroup()
+		for i, line := range b.lines {
+			b.lines[i] = strings.Map(func(r rune) rune {
+				if d, ok := doubleStruck[r]; ok {
+					return d
+				}
+				return r
+			}, line)
+		}
+		return mathItem{box: b}
+	case "left", "right", "bigl", "bigr", "Bigl", "Bigr", "big", "Big":
+		if name == "left" {
+			return p.delimited()
+		}
+		// \right without \left, or fixed size delimiters.
+		if name == "right" {
+			p.ok = false
+		}
+		return p.parseAtom()
+	}
+	if accent, ok := mathAccents[name]; ok {
+		b := p.parseGroup()
+		if len(b.lines) != 1 || b.width() != 1 {
+			p.ok = false
+			return mathItem{box: b}
+		}
+		return mathItem{box: textBox(b.lines[0] + accent)}
+	}
+	p.ok = false
+	return mathItem{box: textBox(t)}
+}
+
+// delimited parses \left( ... \right), stretching the delimiters to the
+// height of the content.
+func (p *mathParser) delimited() mathItem {
+	left := p.delimiter(p.next())
+	inner := p.layout(p.parseList("\\right"), p.script)
+	right := p.delimiter(p.next())
+	return mathItem{box: hconcat(stretch(left, inner), inner, stretch(right, inner))}
+}
+
+func (p *mathParser) delimiter(t string) string {
+	switch t {
+	case ".":
+		return ""
+	case "\\{":
+		return "{"
+	case "\\}":
+		return "}"
+	case "\\langle":
+		return "⟨"
+	case "\\rangle":
+		return "⟩"
+	case "\\|":
+		return "‖"
+	case "(", ")", "[", "]", "|":
+		return t
+	}
+	p.ok = false
+	return t
+}
+
+// stretch returns the delimiter as tall as the box.
+func stretch(delim string, b mathBox) mathBox {
+	height := len(b.lines)
+	if delim == "" {
+		return mathBox{lines: make([]string, height), base: b.base}
+	}
+	if height == 1 {
+		return textBox(delim)
+	}
+	pieces := map[string][3]string{
+		"(": {"⎛", "⎜", "⎝"},
+		")": {"⎞", "⎟", "⎠"},
+		"[": {"⎡", "⎢", "⎣"},
+		"]": {"⎤", "⎥", "⎦"},
+		"{": {"⎧", "⎨", "⎩"},
+		"}": {"⎫", "⎬", "⎭"},
+		"|": {"│", "│", "│"},
+		"‖": {"‖", "‖", "‖"},
+		"⟨": {"⟨", "⟨", "⟨"},
+		"⟩": {"⟩", "⟩", "⟩"},
+	}[delim]
+	lines := make([]string, height)
+	for i := range lines {
+		switch {
+		case i == 0:
+			lines[i] = pieces[0]
+		case i == height-1:
+			lines[i] = pieces[2]
+		case delim == "{" || delim == "}":
+			if i == b.base {
+				lines[i] = pieces[1]
+			} else {
+				lines[i] = "⎪"
+			}
+		default:
+			lines[i] = pieces[1]
+		}
+	}
+	return mathBox{lines: lines, base: b.base}
+}
+
+// fraction stacks the numerator over the denominator in display math and
+// writes a⁄b otherwise.
+func (p *mathParser) fraction(num, den mathBox) mathBox {
+	if p.display && !p.script {
+		width := num.width()
+		if w := den.width(); w > width {
+			width = w
+		}
+		rule := textBox(strings.Repeat("─", width))
+		return vstack(1, num, rule, den)
+	}
+	if len(num.lines) != 1 || len(den.lines) != 1 {
+		p.ok = false
+		return num
+	}
+	n, d := num.lines[0], den.lines[0]
+	if v, ok := vulgarFractions[n+"/"+d]; ok {
+		return textBox(v)
+	}
+	return textBox(parenthesize(n) + "⁄" + parenthesize(d))
+}
+
+// root writes √x, or √(x + y), with an overline in display math.
+func (p *mathParser) root(index string, b mathBox) mathBox {
+	sign := "√"
+	switch index {
+	case "":
+	case "3":
+		sign = "∛"
+	case "4":
+		sign = "∜"
+	default:
+		if s, ok := scriptText(index, superscripts); ok {
+			sign = s + sign
+		} else {
+			p.ok = false
+		}
+	}
+	if p.display && !p.script {
+		over := textBox(strings.Repeat("_", b.width()))
+		radicand := vstack(1, over, b)
+		signBox := mathBox{lines: make([]string, len(radicand.lines)), base: radicand.base}
+		for i := range signBox.lines {
+			signBox.lines[i] = strings.Repeat(" ", utf8.RuneCountInString(sign))
+		}
+		signBox.lines[radicand.base] = sign
+		return hconcat(signBox, radicand)
+	}
+	if len(b.lines) != 1 {
+		p.ok = false
+		return b
+	}
+	return textBox(sign + parenthesize(b.lines[0]))
+}
+
+// parenthesize wraps text in parentheses unless it is a single number, a
+// single symbol or already in parentheses.
+func parenthesize(text string) string {
+	if utf8.RuneCountInString(text) <= 1 || strings.HasPrefix(text, "(") && strings.HasSuffix(text, ")") {
+		return text
+	}
+	for _, r := range text {
+		if !unicode.IsDigit(r) && r != '.' {
+			return "(" + text + ")"
+		}
+	}
+	return text
+}
+
+// layout lays out the items side by side, spacing binary operators and
+// relations unless in a script.
+func (p *mathParser) layout(items []mathItem, script bool) mathBox {
+	var boxes []mathBox
+	prev := mathOpen
+	for i, item := range items {
+		b := p.attachScripts(item)
+		kind := item.kind
+		if kind == mathBin && (prev == mathBin || prev == mathRel || prev == mathOpen || prev == mathPunct || i == 0) {
+			// A unary minus or plus.
+			kind = mathOrd
+		}
+		switch {
+		case script:
+		case kind == mathBin || kind == mathRel:
+			if i > 0 {
+				boxes = append(boxes, textBox(" "))
+			}
+			b = hconcat(b, textBox(" "))
+		case kind == mathPunct:
+			b = hconcat(b, textBox(" "))
+		case kind == mathLargeOp && i+1 < len(items) && items[i+1].kind != mathOpen && items[i+1].kind != mathPunct:
+			b = hconcat(b, textBox(" "))
+		}
+		boxes = append(boxes, b)
+		prev = kind
+	}
+	if len(boxes) == 0 {
+		return textBox("")
+	}
+	result := hconcat(boxes...)
+	if !script {
+		for i, line := range result.lines {
+			result.lines[i] = strings.TrimRight(line, " ")
+		}
+		width := 0
+		for _, line := range result.lines {
+			if w := utf8.RuneCountInString(line); w > width {
+				width = w
+			}
+		}
+		for i, line := range result.lines {
+			result.lines[i] = line + strings.Repeat(" ", width-utf8.RuneCountInString(line))
+		}
+	}
+	return result
+}
+
+// attachScripts returns the item's box with its superscript and subscript:
+// above and below large operators in display math, as Unicode super and
+// subscript characters where they all exist, raised and lowered in display
+// math, or as ^(...) and _(...) otherwise.
+func (p *mathParser) attachScripts(item mathItem) mathBox {
+	b := item.box
+	if item.sup == nil && item.sub == nil {
+		return b
+	}
+	if item.limits && p.display && !p.script {
+		var boxes []mathBox
+		base := 0
+		if item.sup != nil {
+			boxes = append(boxes, *item.sup)
+			base = 1
+		}
+		boxes = append(boxes, b)
+		if item.sub != nil {
+			boxes = append(boxes, *item.sub)
+		}
+		return vstack(base, boxes...)
+	}
+	sup, supOK := "", true
+	if item.sup != nil {
+		sup, supOK = boxScript(*item.sup, superscripts)
+	}
+	sub, subOK := "", true
+	if item.sub != nil {
+		sub, subOK = boxScript(*item.sub, subscripts)
+	}
+	if supOK && subOK {
+		return hconcat(b, textBox(sub+sup))
+	}
+	if p.display && !p.script {
+		blank := textBox(strings.Repeat(" ", b.width()))
+		var boxes []mathBox
+		base := 0
+		if item.sup != nil {
+			boxes = append(boxes, *item.sup)
+			base = 1
+		}
+		boxes = append(boxes, blank)
+		if item.sub != nil {
+			boxes = append(boxes, *item.sub)
+		}
+		column := vstack(base, boxes...)
+		for i, line := range column.lines {
+			column.lines[i] = strings.TrimRight(line, " ")
+		}
+		width := 0
+		for _, line := range column.lines {
+			if w := utf8.RuneCountInString(line); w > width {
+				width = w
+			}
+		}
+		for i, line := range column.lines {
+			column.lines[i] = line + strings.Repeat(" ", width-utf8.RuneCountInString(line))
+		}
+		return hconcat(b, column)
+	}
+	text := ""
+	for _, s := range []struct {
+		box    *mathBox
+		prefix string
+	}{{item.sub, "_"}, {item.sup, "^"}} {
+		if s.box == nil {
+			continue
+		}
+		if len(s.box.lines) != 1 {
+			p.ok = false
+			continue
+		}
+		text += s.prefix + parenthesize(s.box.lines[0])
+	}
+	return hconcat(b, textBox(text))
+}
+
+func boxScript(b mathBox, table map[rune]rune) (string, bool) {
+	if len(b.lines) != 1 {
+		return "", false
+	}
+	return scriptText(b.lines[0], table)
+}
+
+// scriptText maps the text to super or subscript characters, if they all
+// exist.
+func scriptText(text string, table map[rune]rune) (string, bool) {
+	var out []rune
+	for _, r := range text {
+		s, ok := table[r]
+		if !ok {
+			return "", false
+		}
+		out = append(out, s)
+	}
+	return string(out), true
+}
+
+type mathSymbol struct {
+	text   string
+	kind   mathKind
+	limits bool
+}
+
+var mathSymbols = map[string]mathSymbol{
+	"alpha": {text: "α"}, "beta": {text: "β"}, "gamma": {text: "γ"}, "delta": {text: "δ"},
+	"epsilon": {text: "ϵ"}, "varepsilon": {text: "ε"}, "zeta": {text: "ζ"}, "eta": {text: "η"},
+	"theta": {text: "θ"}, "vartheta": {text: "ϑ"}, "iota": {text: "ι"}, "kappa": {text: "κ"},
+	"lambda": {text: "λ"}, "mu": {text: "μ"}, "nu": {text: "ν"}, "xi": {text: "ξ"},
+	"pi": {text: "π"}, "varpi": {text: "ϖ"}, "rho": {text: "ρ"}, "varrho": {text: "ϱ"},
+	"sigma": {text: "σ"}, "varsigma": {text: "ς"}, "tau": {text: "τ"}, "upsilon": {text: "υ"},
+	"phi": {text: "ϕ"}, "varphi": {text: "φ"}, "chi": {text: "χ"}, "psi": {text: "ψ"},
+	"omega": {text: "ω"},
+	"Gamma": {text: "Γ"}, "Delta": {text: "Δ"}, "Theta": {text: "Θ"}, "Lambda": {text: "Λ"},
+	"Xi": {text: "Ξ"}, "Pi": {text: "Π"}, "Sigma": {text: "Σ"}, "Upsilon": {text: "Υ"},
+	"Phi": {text: "Φ"}, "Psi": {text: "Ψ"}, "Omega": {text: "Ω"},
+
+	"infty": {text: "∞"}, "partial": {text: "∂"}, "nabla": {text: "∇"}, "hbar": {text: "ℏ"},
+	"ell": {text: "ℓ"}, "aleph": {text: "ℵ"}, "emptyset": {text: "∅"}, "varnothing": {text: "∅"},
+	"forall": {text: "∀"}, "exists": {text: "∃"}, "neg": {text: "¬"}, "lnot": {text: "¬"},
+	"angle": {text: "∠"}, "prime": {text: "′"}, "degree": {text: "°"}, "Re": {text: "ℜ"},
+	"Im": {text: "ℑ"}, "ldots": {text: "…"}, "dots": {text: "…"}, "cdots": {text: "⋯"},
+	"vdots": {text: "⋮"}, "ddots": {text: "⋱"}, "langle": {text: "⟨", kind: mathOpen},
+	"rangle": {text: "⟩", kind: mathClose}, "lfloor": {text: "⌊", kind: mathOpen},
+	"rfloor": {text: "⌋", kind: mathClose}, "lceil": {text: "⌈", kind: mathOpen},
+	"rceil": {text: "⌉", kind: mathClose}, "{": {text: "{", kind: mathOpen},
+	"}": {text: "}", kind: mathClose}, "|": {text: "‖"}, "$": {text: "$"}, "%": {text: "%"},
+	"#": {text: "#"}, "_": {text: "_"}, "&": {text: "&"},
+
+	",": {text: " "}, ":": {text: " "}, ";": {text: " "}, "!": {text: ""}, " ": {text: " "},
+	"quad": {text: "  "}, "qquad": {text: "    "},
+
+	"times": {text: "×", kind: mathBin}, "cdot": {text: "⋅", kind: mathBin},
+	"div": {text: "÷", kind: mathBin}, "pm": {text: "±", kind: mathBin},
+	"mp": {text: "∓", kind: mathBin}, "ast": {text: "∗", kind: mathBin},
+	"star": {text: "⋆", kind: mathBin}, "circ": {text: "∘", kind: mathBin},
+	"bullet": {text: "∙", kind: mathBin}, "cup": {text: "∪", kind: mathBin},
+	"cap": {text: "∩", kind: mathBin}, "setminus": {text: "∖", kind: mathBin},
+	"oplus": {text: "⊕", kind: mathBin}, "otimes": {text: "⊗", kind: mathBin},
+	"wedge": {text: "∧", kind: mathBin}, "land": {text: "∧", kind: mathBin},
+	"vee": {text: "∨", kind: mathBin}, "lor": {text: "∨", kind: mathBin},
+
+	"leq": {text: "≤", kind: mathRel}, "le": {text: "≤", kind: mathRel},
+	"geq": {text: "≥", kind: mathRel}, "ge": {text: "≥", kind: mathRel},
+	"neq": {text: "≠", kind: mathRel}, "ne": {text: "≠", kind: mathRel},
+	"approx": {text: "≈", kind: mathRel}, "equiv": {text: "≡", kind: mathRel},
+	"sim": {text: "∼", kind: mathRel}, "simeq": {text: "≃", kind: mathRel},
+	"cong": {text: "≅", kind: mathRel}, "propto": {text: "∝", kind: mathRel},
+	"ll": {text: "≪", kind: mathRel}, "gg": {text: "≫", kind: mathRel},
+	"in": {text: "∈", kind: mathRel}, "notin": {text: "∉", kind: mathRel},
+	"ni": {text: "∋", kind: mathRel}, "subset": {text: "⊂", kind: mathRel},
+	"subseteq": {text: "⊆", kind: mathRel}, "supset": {text: "⊃", kind: mathRel},
+	"supseteq": {text: "⊇", kind: mathRel}, "perp": {text: "⊥", kind: mathRel},
+	"parallel": {text: "∥", kind: mathRel}, "mid": {text: "∣", kind: mathRel},
+	"to": {text: "→", kind: mathRel}, "rightarrow": {text: "→", kind: mathRel},
+	"leftarrow": {text: "←", kind: mathRel}, "gets": {text: "←", kind: mathRel},
+	"leftrightarrow": {text: "↔", kind: mathRel}, "Rightarrow": {text: "⇒", kind: mathRel},
+	"Leftarrow": {text: "⇐", kind: mathRel}, "Leftrightarrow": {text: "⇔", kind: mathRel},
+	"implies": {text: "⟹", kind: mathRel}, "iff": {text: "⟺", kind: mathRel},
+	"mapsto": {text: "↦", kind: mathRel}, "models": {text: "⊨", kind: mathRel},
+	"vdash": {text: "⊢", kind: mathRel},
+
+	"sum":      {text: "∑", kind: mathLargeOp, limits: true},
+	"prod":     {text: "∏", kind: mathLargeOp, limits: true},
+	"coprod":   {text: "∐", kind: mathLargeOp, limits: true},
+	"bigcup":   {text: "⋃", kind: mathLargeOp, limits: true},
+	"bigcap":   {text: "⋂", kind: mathLargeOp, limits: true},
+	"bigoplus": {text: "⨁", kind: mathLargeOp, limits: true},
+	"int":      {text: "∫", kind: mathLargeOp}, "iint": {text: "∬", kind: mathLargeOp},
+	"iiint": {text: "∭", kind: mathLargeOp}, "oint": {text: "∮", kind: mathLargeOp},
+}
+
+// mathTextCommands take text, rather than math, arguments.
+var mathTextCommands = map[string]bool{
+	"text": true, "textrm": true, "textit": true, "textbf": true, "mbox": true,
+	"operatorname": true,
+}
+
+// mathFunctions are written upright by name; true if they take limits, like
+// \lim_{x \to 0}.
+var mathFunctions = map[string]bool{
+	"sin": false, "cos": false, "tan": false, "cot": false, "sec": false, "csc": false,
+	"arcsin": false, "arccos": false, "arctan": false, "sinh": false, "cosh": false,
+	"tanh": false, "log": false, "ln": false, "lg": false, "exp": false, "deg": false,
+	"dim": false, "ker": false, "arg": false, "gcd": true, "det": true, "Pr": true,
+	"lim": true, "limsup": true, "liminf": true, "max": true, "min": true, "sup": true,
+	"inf": true,
+}
+
+// mathAccents are combining characters for the accent commands.
+var mathAccents = map[string]string{
+	"hat": "̂", "widehat": "̂", "bar": "̄", "overline": "̅",
+	"vec": "⃗", "dot": "̇", "ddot": "̈", "tilde": "̃",
+	"widetilde": "̃",
+}
+
+var doubleStruck = map[rune]rune{
+	'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ',
+}
+
+var vulgarFractions = map[string]string{
+	"1/2": "½", "1/3": "⅓", "2/3": "⅔", "1/4": "¼", "3/4": "¾", "1/5": "⅕",
+	"2/5": "⅖", "3/5": "⅗", "4/5": "⅘", "1/6": "⅙", "5/6": "⅚", "1/8": "⅛",
+	"3/8": "⅜", "5/8": "⅝", "7/8": "⅞",
+}
+
+var superscripts = map[rune]rune{
+	'0': '⁰', '1': '¹', '2': '²', '3': '³', '4': '⁴', '5': '⁵', '6': '⁶', '7': '⁷',
+	'8': '⁸', '9': '⁹', '+': '⁺', '−': '⁻', '-': '⁻', '=': '⁼', '(': '⁽', ')': '⁾',
+	'a': 'ᵃ', 'b': 'ᵇ', 'c': 'ᶜ', 'd': 'ᵈ', 'e': 'ᵉ', 'f': 'ᶠ', 'g': 'ᵍ', 'h': 'ʰ',
+	'i': 'ⁱ', 'j': 'ʲ', 'k': 'ᵏ', 'l': 'ˡ', 'm': 'ᵐ', 'n': 'ⁿ', 'o': 'ᵒ', 'p': 'ᵖ',
+	'r': 'ʳ', 's': 'ˢ', 't': 'ᵗ', 'u': 'ᵘ', 'v': 'ᵛ', 'w': 'ʷ', 'x': 'ˣ', 'y': 'ʸ',
+	'z': 'ᶻ', 'A': 'ᴬ', 'B': 'ᴮ', 'D': 'ᴰ', 'E': 'ᴱ', 'G': 'ᴳ', 'H': 'ᴴ', 'I': 'ᴵ',
+	'J': 'ᴶ', 'K': 'ᴷ', 'L': 'ᴸ', 'M': 'ᴹ', 'N': 'ᴺ', 'O': 'ᴼ', 'P': 'ᴾ', 'R': 'ᴿ',
+	'T': 'ᵀ', 'U': 'ᵁ', 'V': 'ⱽ', 'W': 'ᵂ', 'α': 'ᵅ', 'β': 'ᵝ', 'γ': 'ᵞ', 'δ': 'ᵟ',
+	'θ': 'ᶿ', 'ϕ': 'ᵠ', 'φ': 'ᵠ', 'χ': 'ᵡ', '′': '′', '∗': '*', '∞': '∞',
+}
+
+var subscripts = map[rune]rune{
+	'0': '₀', '1': '₁', '2': '₂', '3': '₃', '4': '₄', '5': '₅', '6': '₆', '7': '₇',
+	'8': '₈', '9': '₉', '+': '₊', '−': '₋', '-': '₋', '=': '₌', '(': '₍', ')': '₎',
+	'a': 'ₐ', 'e': 'ₑ', 'h': 'ₕ', 'i': 'ᵢ', 'j': 'ⱼ', 'k': 'ₖ', 'l': 'ₗ', 'm': 'ₘ',
+	'n': 'ₙ', 'o': 'ₒ', 'p': 'ₚ', 'r': 'ᵣ', 's': 'ₛ', 't': 'ₜ', 'u': 'ᵤ', 'v': 'ᵥ',
+	'x': 'ₓ', 'β': 'ᵦ', 'γ': 'ᵧ', 'ρ': 'ᵨ', 'ϕ': 'ᵩ', 'φ': 'ᵩ', 'χ': 'ᵪ',
+}
@@ -0,0 +1,45 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMath(t *testing.T) {
+	for _, test := range []struct {
+		markdown string
+		want     string
+	}{
+		{"$E = mc^2$", "E = mc²\n"},
+		{"$x_1 + \\alpha$", "x₁ + α\n"},
+		{"$a \\le b \\ne c$", "a ≤ b ≠ c\n"},
+		{"$\\frac{a}{b}$ and $\\sqrt{x}$", "a⁄b and √x\n"},
+		{"$\\text{if } x$", "if x\n"},
+		// Prices, code and escaped dollars aren't math.
+		{"Costs $5 or $10.", "Costs $5 or $10.\n"},
+		{"`$x$` and \\$y$", "\"$x$\" and $y$\n"},
+		// Unsupported math is shown as the TeX source.
+		{"$\\unknown{x}$", "\"$\\unknown{x}$\"\n"},
+		{"$$\\frac{a+b}{2}$$", "                 a + b\n                 ─────\n                   2\n"},
+		{"$$\\sum_{i=1}^{n} i$$", "                  n\n                  ∑  i\n                 i=1\n"},
+	} {
+		got := string(MarkdownToTextNoMetadata([]byte(test.markdown), &Options{Width: 40, Math: true}))
+		if got != test.want {
+			t.Errorf("%q:\n got %q\nwant %q", test.markdown, got, test.want)
+		}
+	}
+	if got := string(MarkdownToTextNoMetadata([]byte("$x^2$"), &Options{Width: 40})); got != "$x^2$\n" {
+		t.Errorf("without Math got %q", got)
+	}
+}
+
+func TestMathTokens(t *testing.T) {
+	want := []string{"\\alpha", "_", "{", "1", "}", "+", "\\,", "\\text", "{a b}", "'"}
+	if got := mathTokens(`\alpha_{1} + \, \text {a b}'`); !reflect.DeepEqual(got, want) {
+		t.Errorf("mathTokens:\n got %q\nwant %q", got, want)
+	}
+}
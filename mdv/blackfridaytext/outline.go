@@ -0,0 +1,248 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/rn/utils/mdv/brimtext"
+	"github.com/russross/blackfriday"
+)
+
+// Header describes a single header found in a Markdown document.
+type Header struct {
+	// Level is the header level, 1 for "#", 2 for "##", etc.
+	Level int
+	// Number is the hierarchical number of the header, such as "1.2.1".
+	// Skipped levels do not add an extra number, so a "###" directly under a
+	// "#" will be numbered "1.1".
+	Number string
+	// Text is the header text with any formatting removed.
+	Text string
+	// ID is the anchor name for the header, as generated by
+	// blackfriday.SanitizedAnchorName.
+	ID string
+}
+
+// MarkdownHeaders returns the headers of the markdown, in document order. The
+// markdown should not contain any metadata; see MarkdownMetadata.
+func MarkdownHeaders(markdown []byte) []Header {
+	var headers []Header
+	rend := &renderer{
+		width:             1 << 30,
+		color:             true,
+		tableAlignOptions: brimtext.NewSimpleAlignOptions(),
+		headerIndent:      []byte("    "),
+		headers:           &headers,
+	}
+	markdown = removeSummaryMarks(markdown)
+	blackfriday.Markdown(markdown, rend, extensions)
+	return headers
+}
+
+// MarkdownSection returns the part of the markdown starting at the header
+// named and ending just before the next header of the same or a higher
+// level; subsections are therefore included. The name is compared case
+// insensitively against the header text and, if it starts with "#", against
+// the header's anchor name. Returns nil if there is no such header.
+func MarkdownSection(markdown []byte, name string) []byte {
+	boundaries := MarkdownBoundaries(markdown)
+	for i, b := range boundaries {
+		if !b.matches(name) {
+			continue
+		}
+		end := len(markdown)
+		for _, b2 := range boundaries[i+1:] {
+			if b2.Level > 0 && b2.Level <= b.Level {
+				end = b2.Start
+				break
+			}
+		}
+		return markdown[b.Start:end]
+	}
+	return nil
+}
+
+// numberer hands out hierarchical header numbers.
+type numberer struct {
+	levels   []int
+	counters []int
+}
+
+func (n *numberer) next(level int) string {
+	for len(n.levels) > 0 && n.levels[len(n.levels)-1] >= level {
+		n.levels = n.levels[:len(n.levels)-1]
+	}
+	n.levels = append(n.levels, level)
+	depth := len(n.levels)
+	if len(n.counters) < depth {
+		n.counters = append(n.counters, 0)
+	}
+	n.counters = n.counters[:depth]
+	n.counters[depth-1]++
+	parts := make([]string, depth)
+	for i, c := range n.counters {
+		parts[i] = strconv.Itoa(c)
+	}
+	return strings.Join(parts, ".")
+}
+
+// Boundary is a header or horizontal rule found by MarkdownBoundaries, along
+// with its position in the markdown source.
+type Boundary struct {
+	// Level is the header level, or 0 for a horizontal rule.
+	Level int
+	// Text is the header text as written in the source.
+	Text string
+	// Start is the byte offset of the first line of the header or rule.
+	Start int
+	// End is the byte offset just after the last line of the header or rule,
+	// including its newline.
+	End int
+	// Line is the 1 based line number of the first line of the header or
+	// rule.
+	Line int
+}
+
+// ID returns the anchor name Blackfriday would give the header.
+func (b *Boundary) ID() string {
+	return blackfriday.SanitizedAnchorName(strings.NewReplacer("`", "", "*", "").Replace(b.Text))
+}
+
+func (b *Boundary) matches(name string) bool {
+	if b.Level == 0 {
+		return false
+	}
+	name = strings.TrimSpace(name)
+	if strings.EqualFold(b.Text, name) {
+		return true
+	}
+	if strings.EqualFold(strings.Trim(strings.NewReplacer("`", "", "*", "").Replace(b.Text), " "), name) {
+		return true
+	}
+	if strings.HasPrefix(name, "#") {
+		return b.ID() == name[1:]
+	}
+	return false
+}
+
+// MarkdownBoundaries does a simple line based scan of the markdown for ATX
+// ("# Title") and Setext ("Title" underlined with "===" or "---") headers and
+// for horizontal rules, skipping fenced and indented code blocks. It is not a
+// full parser, but matches what Blackfriday will treat as headers and rules
+// for all but the most contrived documents.
+func MarkdownBoundaries(markdown []byte) []Boundary {
+	var boundaries []Boundary
+	var fence []byte
+	lines := bytes.SplitAfter(markdown, []byte("\n"))
+	offset := 0
+	prevStart := -1
+	var prevText []byte
+	for i, rawLine := range lines {
+		start := offset
+		offset += len(rawLine)
+		line := bytes.TrimRight(rawLine, "\r\n")
+		trimmed := bytes.TrimLeft(line, " ")
+		if fence != nil {
+			if bytes.HasPrefix(trimmed, fence) {
+				fence = nil
+			}
+			prevStart = -1
+			continue
+		}
+		if bytes.HasPrefix(trimmed, []byte("```")) || bytes.HasPrefix(trimmed, []byte("~~~")) {
+			fence = trimmed[:3]
+			prevStart = -1
+			continue
+		}
+		if len(line)-len(trimmed) >= 4 || bytes.HasPrefix(line, []byte("\t")) {
+			if prevStart == -1 {
+				continue
+			}
+		}
+		if len(line) > 0 && line[0] == '#' {
+			level := 0
+			for level < len(line) && level < 6 && line[level] == '#' {
+				level++
+			}
+			text := bytes.TrimSpace(bytes.TrimRight(line[level:], "# "))
+			boundaries = append(boundaries, Boundary{Level: level, Text: string(text), Start: start, End: offset, Line: i + 1})
+			prevStart = -1
+			continue
+		}
+		if prevStart != -1 && len(trimmed) > 0 && (isUnderline(trimmed, '=') || isUnderline(trimmed, '-')) {
+			level := 1
+			if trimmed[0] == '-' {
+				level = 2
+			}
+			boundaries = append(boundaries, Boundary{Level: level, Text: string(bytes.TrimSpace(prevText)), Start: prevStart, End: offset, Line: i})
+			prevStart = -1
+			continue
+		}
+		if isHRule(trimmed) {
+			boundaries = append(boundaries, Boundary{Start: start, End: offset, Line: i + 1})
+			prevStart = -1
+			continue
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			prevStart = -1
+		} else {
+			prevStart = start
+			prevText = line
+		}
+	}
+	return boundaries
+}
+
+func isUnderline(line []byte, c byte) bool {
+	line = bytes.TrimRight(line, " ")
+	for _, b := range line {
+		if b != c {
+			return false
+		}
+	}
+	return true
+}
+
+// isHRule returns true for three or more "-", "*" or "_", optionally
+// separated by spaces.
+func isHRule(line []byte) bool {
+	if len(line) == 0 || (line[0] != '-' && line[0] != '*' && line[0] != '_') {
+		return false
+	}
+	n := 0
+	for _, b := range line {
+		if b == line[0] {
+			n++
+		} else if b != ' ' {
+			return false
+		}
+	}
+	return n >= 3
+}
+
+// plainText removes any ANSI escapes and internal marks from rendered text.
+func plainText(text []byte) string {
+	var out bytes.Buffer
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\x1b':
+			j := bytes.IndexByte(text[i:], 'm')
+			if j == -1 {
+				return strings.TrimSpace(out.String())
+			}
+			i += j
+		case c == markNBSP || c == '\n':
+			out.WriteByte(' ')
+		case c < ' ' && c != '\t':
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return strings.TrimSpace(out.String())
+}
@@ -0,0 +1,98 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const outlineDoc = `# Intro
+
+Some text.
+
+## Install *quickly*
+
+` + "```" + `
+# not a header
+` + "```" + `
+
+#### Deep
+
+## Usage
+
+    # indented code, not a header
+
+Options
+-------
+
+# Appendix
+`
+
+func TestMarkdownHeaders(t *testing.T) {
+	want := []Header{
+		{Level: 1, Number: "1", Text: "Intro", ID: "intro"},
+		{Level: 2, Number: "1.1", Text: "Install quickly", ID: "install-quickly"},
+		{Level: 4, Number: "1.1.1", Text: "Deep", ID: "deep"},
+		{Level: 2, Number: "1.2", Text: "Usage", ID: "usage"},
+		{Level: 2, Number: "1.3", Text: "Options", ID: "options"},
+		{Level: 1, Number: "2", Text: "Appendix", ID: "appendix"},
+	}
+	if got := MarkdownHeaders([]byte(outlineDoc)); !reflect.DeepEqual(got, want) {
+		t.Errorf("MarkdownHeaders:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestMarkdownBoundaries(t *testing.T) {
+	doc := "# One\ntext\n\n***\n\nTwo\n===\n\n    # code\n"
+	want := []Boundary{
+		{Level: 1, Text: "One", Start: 0, End: 6, Line: 1},
+		{Level: 0, Start: 12, End: 16, Line: 4},
+		{Level: 1, Text: "Two", Start: 17, End: 25, Line: 6},
+	}
+	if got := MarkdownBoundaries([]byte(doc)); !reflect.DeepEqual(got, want) {
+		t.Errorf("MarkdownBoundaries:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestMarkdownSection(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want string
+	}{
+		{"Intro", outlineDoc[:strings.Index(outlineDoc, "# Appendix")]},
+		{"install quickly", "## Install *quickly*\n\n```\n# not a header\n```\n\n#### Deep\n\n"},
+		{"#install-quickly", "## Install *quickly*\n\n```\n# not a header\n```\n\n#### Deep\n\n"},
+		{"  Deep ", "#### Deep\n\n"},
+		{"Options", "Options\n-------\n\n"},
+		{"Appendix", "# Appendix\n"},
+		{"not a header", ""},
+		{"#intro-missing", ""},
+	} {
+		got := MarkdownSection([]byte(outlineDoc), test.name)
+		if test.want == "" {
+			if got != nil {
+				t.Errorf("MarkdownSection(%q) = %q, want nil", test.name, got)
+			}
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("MarkdownSection(%q):\n got %q\nwant %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNumberer(t *testing.T) {
+	var n numberer
+	var got []string
+	for _, level := range []int{2, 3, 3, 1, 3, 2, 1} {
+		got = append(got, n.next(level))
+	}
+	want := []string{"1", "1.1", "1.2", "2", "2.1", "2.2", "3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("numbers %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// renderChunkSize is roughly how much markdown Render parses at a time; chunks
+// only end between top level blocks, so one may be larger.
+const renderChunkSize = 64 * 1024
+
+// referenceDefinition matches a line defining a reference style link, such as
+// "[id]: https://example.com", capturing the id.
+var referenceDefinition = regexp.MustCompile(`^ {0,3}\[([^\]]+)\]:[ \t]*\S`)
+
+// bracketed matches the text of each [...], any of which could be the id of a
+// reference style link.
+var bracketed = regexp.MustCompile(`\[([^\[\]]+)\]`)
+
+// Render reads the markdown from r and writes the formatted text to w, as
+// MarkdownToTextNoMetadata would return it, but a chunk of top level blocks
+// at a time, so memory use stays bounded however large the document is. If
+// opts is nil the defaults will be used.
+//
+// Header numbering and indentation carry across chunks. Reference style link
+// definitions are kept and given to the chunks using them; if r is an
+// io.Seeker it is scanned for them first, so links can be defined after
+// they're used (such as at the end of a changelog), otherwise only earlier
+// definitions resolve. Long lists are split between their items.
+func Render(r io.Reader, w io.Writer, opts *Options) error {
+	opts = resolveOpts(opts)
+	rend := newRenderer(opts)
+	references := map[string][]byte{}
+	scanned := false
+	if s, ok := r.(io.Seeker); ok {
+		if pos, err := s.Seek(0, io.SeekCurrent); err == nil {
+			if err := scanReferences(r, references); err != nil {
+				return err
+			}
+			if _, err := s.Seek(pos, io.SeekStart); err != nil {
+				return err
+			}
+			scanned = true
+		}
+	}
+
+	var chunk bytes.Buffer
+	// The trailing newlines of each chunk's text are held back; they're
+	// replaced by the separator if more text follows: a blank line between
+	// blocks, or just a newline between the items of a list split in two.
+	held := 0
+	separator := "\n\n"
+	started := false
+	flush := func(last bool) error {
+		var txt []byte
+		if chunk.Len() > 0 {
+			if len(references) > 0 {
+				chunk.WriteByte('\n')
+				for _, m := range bracketed.FindAllSubmatch(chunk.Bytes(), -1) {
+					if definition, ok := references[referenceID(m[1])]; ok {
+						chunk.Write(definition)
+					}
+				}
+			}
+			rend.math = nil
+			txt = rend.markdown(chunk.Bytes(), opts)
+			for i := 0; i < rend.level; i++ {
+				txt = append(txt, markIndentStop)
+			}
+			chunk.Reset()
+			txt = finishText(txt, opts, rend)
+		}
+		var out bytes.Buffer
+		if started {
+			txt = bytes.TrimLeft(txt, "\n")
+		}
+		if body := bytes.TrimRight(txt, "\n"); len(body) > 0 {
+			if started {
+				out.WriteString(separator)
+			}
+			out.Write(body)
+			held = len(txt) - len(body)
+			started = true
+		}
+		if last {
+			out.Write(bytes.Repeat([]byte("\n"), held))
+		}
+		_, err := w.Write(out.Bytes())
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	var fences fenceTracker
+	// list is true within a top level list and item just after the first line
+	// of one of its items.
+	blank, list, item := true, false, false
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			code := fences.code(line)
+			starts := !code && startsListItem(line)
+			if !code && chunk.Len() >= renderChunkSize {
+				// A long list is split between its items too; the two lists
+				// render just as the one would. An item only follows directly
+				// on the first line of another, as otherwise it could be a
+				// lazy continuation of a paragraph in the item.
+				next := ""
+				switch {
+				case starts && list && (blank || item):
+					next = "\n"
+				case blank && startsTopLevelBlock(line):
+					next = "\n\n"
+				}
+				if next != "" {
+					if err := flush(false); err != nil {
+						return err
+					}
+					separator = next
+				}
+			}
+			if !code && !scanned {
+				addReference(references, line)
+			}
+			switch {
+			case starts && (blank || list):
+				list = true
+			case blank && !code && len(bytes.TrimSpace(line)) > 0 && line[0] != ' ' && line[0] != '\t':
+				list = false
+			}
+			item = starts && list
+			blank = len(bytes.TrimSpace(line)) == 0
+			chunk.Write(line)
+		}
+		if err == io.EOF {
+			return flush(true)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ReadMarkdownMetadata reads the metadata from the start of r, as
+// MarkdownMetadata returns it, and leaves r at the start of the content, ready
+// to be given to Render. Only the metadata, and a summary if there is one, is
+// held in memory.
+func ReadMarkdownMetadata(r io.ReadSeeker) ([][]string, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(r)
+	var metadata [][]string
+	var pos int64
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		sline := strings.Trim(strings.TrimSuffix(string(line), "\n"), " ")
+		if sline == "" {
+			break
+		}
+		colon := strings.Index(sline, ": ")
+		if colon == -1 {
+			// Since there's no blank line separating the metadata and content,
+			// we assume there wasn't actually any metadata.
+			metadata = make([][]string, 0)
+			pos = 0
+			break
+		}
+		metadata = append(metadata, []string{strings.Trim(sline[:colon], " "), strings.Trim(sline[colon+1:], " ")})
+		pos += int64(len(line))
+		if err == io.EOF {
+			break
+		}
+	}
+
+	// The summary is everything up to a "///" line, other than the first;
+	// it's cut from the content if the line is doubled.
+	if _, err := r.Seek(start+pos, io.SeekStart); err != nil {
+		return nil, err
+	}
+	br.Reset(r)
+	summary, skip := int64(-1), int64(0)
+	offset := int64(0)
+	first := true
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if summary != -1 {
+			if string(line) == "///\n" {
+				skip = offset + int64(len(line))
+			}
+			break
+		}
+		if !first && string(line) == "///\n" {
+			summary = offset - 1
+		}
+		first = false
+		offset += int64(len(line))
+		if err == io.EOF {
+			break
+		}
+	}
+	if summary != -1 {
+		if _, err := r.Seek(start+pos, io.SeekStart); err != nil {
+			return nil, err
+		}
+		value := make([]byte, summary)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, err
+		}
+		metadata = append(metadata, []string{"Summary", string(value)})
+	}
+	_, err = r.Seek(start+pos+skip, io.SeekStart)
+	return metadata, err
+}
+
+// scanReferences adds the reference style link definitions read from r, other
+// than those in fenced code, to references.
+func scanReferences(r io.Reader, references map[string][]byte) error {
+	br := bufio.NewReader(r)
+	var fences fenceTracker
+	for {
+		line, err := br.ReadBytes('\n')
+		if !fences.code(line) {
+			addReference(references, line)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// addReference adds the line to references, by id, if it's a reference style
+// link definition. As with Blackfriday, the last definition of an id wins.
+func addReference(references map[string][]byte, line []byte) {
+	if !bytes.HasPrefix(bytes.TrimLeft(line, " "), []byte("[")) {
+		return
+	}
+	m := referenceDefinition.FindSubmatch(line)
+	if m == nil {
+		return
+	}
+	definition := append([]byte(nil), line...)
+	if definition[len(definition)-1] != '\n' {
+		definition = append(definition, '\n')
+	}
+	references[referenceID(m[1])] = definition
+}
+
+// referenceID returns the id normalized as Blackfriday compares them.
+func referenceID(id []byte) string {
+	return string(bytes.ToLower(id))
+}
+
+// fenceTracker follows fenced code blocks as markdown is read line by line.
+type fenceTracker struct {
+	fence []byte
+}
+
+// code reports whether the line is part of a fenced code block, including its
+// opening and closing fences.
+func (f *fenceTracker) code(line []byte) bool {
+	trimmed := bytes.TrimLeft(line, " ")
+	switch {
+	case f.fence != nil:
+		if bytes.HasPrefix(trimmed, f.fence) {
+			f.fence = nil
+		}
+		return true
+	case bytes.HasPrefix(trimmed, []byte("```")) || bytes.HasPrefix(trimmed, []byte("~~~")):
+		f.fence = append([]byte(nil), trimmed[:3]...)
+		return true
+	}
+	return false
+}
+
+// startsTopLevelBlock reports whether the line, following a blank line, starts
+// a block that can't be a continuation of the one before it: not indented, a
+// list item, a block quote, a definition or a footnote.
+func startsTopLevelBlock(line []byte) bool {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return false
+	}
+	switch line[0] {
+	case ' ', '\t', '>', ':', '-', '*', '+':
+		return false
+	case '[':
+		return !bytes.HasPrefix(line, []byte("[^"))
+	}
+	i := 0
+	for i < len(line) && line[i] >= '0' && line[i] <= '9' {
+		i++
+	}
+	return i == 0 || i == len(line) || (line[i] != '.' && line[i] != ')')
+}
+
+// startsListItem reports whether the line starts an item of a top level list:
+// a bullet or number, not indented, followed by a space.
+func startsListItem(line []byte) bool {
+	i := 0
+	for i < len(line) && line[i] >= '0' && line[i] <= '9' {
+		i++
+	}
+	switch {
+	case i > 0 && i < len(line) && (line[i] == '.' || line[i] == ')'):
+		i++
+	case i == 0 && len(line) > 0 && (line[0] == '-' || line[0] == '*' || line[0] == '+'):
+		if isRule(line) {
+			return false
+		}
+		i++
+	default:
+		return false
+	}
+	return i < len(line) && (line[i] == ' ' || line[i] == '\t')
+}
+
+// isRule reports whether the line is a horizontal rule, such as "---" or
+// "* * *".
+func isRule(line []byte) bool {
+	rule := bytes.Replace(bytes.TrimSpace(line), []byte(" "), nil, -1)
+	return len(rule) >= 3 && (rule[0] == '-' || rule[0] == '*' || rule[0] == '_') &&
+		len(bytes.Trim(rule, string(rule[:1]))) == 0
+}
@@ -0,0 +1,147 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// streamDoc returns a document of about size bytes: sections of paragraphs,
+// lists and code, with reference links defined at the end.
+func streamDoc(size int) []byte {
+	var doc bytes.Buffer
+	for i := 0; doc.Len() < size; i++ {
+		fmt.Fprintf(&doc, "# Section %d\n\nSome *text* with a [link][ref] and `code`.\n\n", i)
+		fmt.Fprintf(&doc, "- item one\n- item two\n\n```\ncode %d\n```\n\n", i)
+	}
+	doc.WriteString("\n[ref]: https://example.com\n")
+	return doc.Bytes()
+}
+
+// listDoc returns a document that is a single list of about size bytes.
+func listDoc(size int, loose bool) []byte {
+	var doc bytes.Buffer
+	for i := 0; doc.Len() < size; i++ {
+		fmt.Fprintf(&doc, "- item %d with [a link](https://example.com/%d)\n", i, i)
+		if loose {
+			doc.WriteString("\n")
+		}
+	}
+	return doc.Bytes()
+}
+
+// countingWriter counts the writes, which Render makes one per chunk.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestRender(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		doc  []byte
+	}{
+		{"small", []byte("# One\n\ntext\n\n- a\n- b\n")},
+		{"sections", streamDoc(4 * renderChunkSize)},
+		{"tight list", listDoc(4*renderChunkSize, false)},
+		{"loose list", listDoc(4*renderChunkSize, true)},
+		{"list after paragraph", append([]byte("para\n\n"), listDoc(2*renderChunkSize, false)...)},
+	} {
+		opts := &Options{Width: 60, Color: true}
+		want := MarkdownToTextNoMetadata(test.doc, opts)
+		var got countingWriter
+		if err := Render(bytes.NewReader(test.doc), &got, opts); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !bytes.Equal(got.Bytes(), want) {
+			t.Errorf("%s: Render differs from MarkdownToTextNoMetadata:\n%s", test.name, lineDiff(string(got.Bytes()), string(want)))
+		}
+		if len(test.doc) > 2*renderChunkSize && got.writes < 2 {
+			t.Errorf("%s: rendered in %d chunks", test.name, got.writes)
+		}
+	}
+}
+
+func TestReadMarkdownMetadata(t *testing.T) {
+	for _, doc := range []string{
+		"",
+		"# No metadata\n\ntext\n",
+		"Title: Test\nAuthor: Me\n\n# Header\n",
+		"Title: Test\nnot metadata\n\ntext\n",
+		"Title: Test\n\nThe summary.\n///\nText.\n",
+		"Title: Test\n\nThe summary.\n///\n///\nText.\n",
+		"The summary.\n///\nText.\n",
+		"///\nText.\n",
+	} {
+		wantMetadata, position := MarkdownMetadata([]byte(doc))
+		r := strings.NewReader(doc)
+		metadata, err := ReadMarkdownMetadata(r)
+		if err != nil {
+			t.Fatalf("%q: %v", doc, err)
+		}
+		if len(metadata) != 0 || len(wantMetadata) != 0 {
+			if !reflect.DeepEqual(metadata, wantMetadata) {
+				t.Errorf("%q: metadata %q, want %q", doc, metadata, wantMetadata)
+			}
+		}
+		rest, _ := ioutil.ReadAll(r)
+		if string(rest) != doc[position:] {
+			t.Errorf("%q: content %q, want %q", doc, rest, doc[position:])
+		}
+	}
+}
+
+func TestStartsListItem(t *testing.T) {
+	for line, want := range map[string]bool{
+		"- item\n":   true,
+		"* item\n":   true,
+		"+ item\n":   true,
+		"12. item\n": true,
+		"1) item\n":  true,
+		"  - item\n": false,
+		"-item\n":    false,
+		"---\n":      false,
+		"* * *\n":    false,
+		"2020 was\n": false,
+	} {
+		if got := startsListItem([]byte(line)); got != want {
+			t.Errorf("startsListItem(%q) = %v", line, got)
+		}
+	}
+}
+
+func BenchmarkRender(b *testing.B) {
+	doc := streamDoc(4 << 20)
+	opts := &Options{Width: 80, Color: true}
+	b.SetBytes(int64(len(doc)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := Render(bytes.NewReader(doc), ioutil.Discard, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRenderList(b *testing.B) {
+	doc := listDoc(4<<20, false)
+	opts := &Options{Width: 80, Color: true}
+	b.SetBytes(int64(len(doc)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := Render(bytes.NewReader(doc), ioutil.Discard, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
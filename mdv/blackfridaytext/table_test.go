@@ -0,0 +1,55 @@
+// Copyright Gregory Holt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackfridaytext
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rn/utils/mdv/brimtext"
+)
+
+func TestTableWidths(t *testing.T) {
+	markdown := []byte("| first column | second column | third column |\n|---|---|---|\n| ünïcödé text | **bold** words | plain words |\n")
+	out := string(MarkdownToTextNoMetadata(markdown, &Options{Width: 60, Color: true}))
+	lines := strings.Split(strings.Trim(out, "\n"), "\n")
+	// Box drawing characters, non-ASCII text and colors take one column
+	// each or none, however many bytes they are.
+	if !strings.Contains(out, "plain words") || !strings.Contains(out, "ünïcödé") {
+		t.Errorf("cells wrapped with room to spare:\n%s", out)
+	}
+	for _, line := range lines {
+		if w := brimtext.DisplayWidth(line); w != brimtext.DisplayWidth(lines[0]) || w > 60 {
+			t.Errorf("line %q is %d wide, first line %d, width 60", line, w, brimtext.DisplayWidth(lines[0]))
+		}
+	}
+}
+
+func TestWideCharacters(t *testing.T) {
+	// CJK and emoji take two columns, so tables line up and paragraphs wrap
+	// by columns rather than characters.
+	markdown := []byte("| 名前 | 説明 |\n|---|---|\n| 🚀 | ロケット |\n| abc | def |\n\n" + strings.Repeat("漢字 ", 20) + "\n")
+	out := string(MarkdownToTextNoMetadata(markdown, &Options{Width: 30}))
+	lines := strings.Split(strings.Trim(out, "\n"), "\n")
+	for _, line := range lines[:5] {
+		if w := brimtext.DisplayWidth(line); w != brimtext.DisplayWidth(lines[0]) {
+			t.Errorf("table line %q is %d wide, first line %d:\n%s", line, w, brimtext.DisplayWidth(lines[0]), out)
+		}
+	}
+	for _, line := range lines[5:] {
+		if w := brimtext.DisplayWidth(line); w > 30 {
+			t.Errorf("line %q is %d wide, width 30:\n%s", line, w, out)
+		}
+	}
+}
+
+func TestAlignToWidth(t *testing.T) {
+	data := [][]string{{"a", "bb"}, nil, {"ccc", "\x1b[1md\x1b[0m"}}
+	opts := brimtext.NewSimpleAlignOptions()
+	AlignToWidth(data, opts, 80)
+	if want := []int{3, 2}; opts.Widths[0] != want[0] || opts.Widths[1] != want[1] {
+		t.Errorf("widths %v, want %v", opts.Widths, want)
+	}
+}
@@ -342,11 +342,11 @@ func Align(data [][]string, opts *AlignOptions) string {
 			continue
 		}
 		for len(row) > len(widths) {
-			widths = append(widths, len(row[len(widths)]))
+			widths = append(widths, DisplayWidth(row[len(widths)]))
 		}
 		for c, v := range row {
-			if utf8.RuneCountInString(v) > widths[c] {
-				widths[c] = utf8.RuneCountInString(v)
+			if DisplayWidth(v) > widths[c] {
+				widths[c] = DisplayWidth(v)
 			}
 		}
 	}
@@ -427,24 +427,24 @@ func Align(data [][]string, opts *AlignOptions) string {
 			}
 			switch alignments[c] {
 			case Right:
-				for i := widths[c] - utf8.RuneCountInString(v); i > 0; i-- {
+				for i := widths[c] - DisplayWidth(v); i > 0; i-- {
 					buf.WriteRune(' ')
 				}
 				buf.WriteString(v)
 			case Center:
-				for i := (widths[c] - utf8.RuneCountInString(v)) / 2; i > 0; i-- {
+				for i := (widths[c] - DisplayWidth(v)) / 2; i > 0; i-- {
 					buf.WriteRune(' ')
 				}
 				buf.WriteString(v)
 				if opts.LeaveTrailingWhitespace || c < len(row)-1 {
-					for i := widths[c] - ((widths[c]-utf8.RuneCountInString(v))/2 + utf8.RuneCountInString(v)); i > 0; i-- {
+					for i := widths[c] - ((widths[c]-DisplayWidth(v))/2 + DisplayWidth(v)); i > 0; i-- {
 						buf.WriteRune(' ')
 					}
 				}
 			default:
 				buf.WriteString(v)
 				if opts.LeaveTrailingWhitespace || c < len(row)-1 {
-					for i := widths[c] - utf8.RuneCountInString(v); i > 0; i-- {
+					for i := widths[c] - DisplayWidth(v); i > 0; i-- {
 						buf.WriteRune(' ')
 					}
 				}
@@ -0,0 +1,362 @@
+package brimtext
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// ANSIStyle is the display style in effect for a run of text, as set by ANSI
+// SGR (Select Graphic Rendition) Escape Codes.
+type ANSIStyle struct {
+	// Foreground is the foreground color, 0-7 for the normal colors, 8-15
+	// for the bright colors, or -1 for the default color.
+	Foreground int
+	// Background is the background color, as with Foreground.
+	Background    int
+	Bold          bool
+	Underline     bool
+	StrikeThrough bool
+}
+
+// ANSISpan is a run of text sharing the same ANSIStyle.
+type ANSISpan struct {
+	Text  string
+	Style ANSIStyle
+}
+
+// ParseANSI splits the text into lines and each line into spans of text
+// sharing the same style. Styles carry over from one line to the next, as
+// they would on a terminal. Escape sequences other than SGR sequences are
+// dropped.
+func ParseANSI(text string) [][]ANSISpan {
+	style := ANSIStyle{Foreground: -1, Background: -1}
+	var lines [][]ANSISpan
+	for _, line := range strings.Split(text, "\n") {
+		var spans []ANSISpan
+		var run bytes.Buffer
+		flush := func() {
+			if run.Len() > 0 {
+				spans = append(spans, ANSISpan{Text: run.String(), Style: style})
+				run.Reset()
+			}
+		}
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			if c == '\r' {
+				continue
+			}
+			if c != '\x1b' {
+				run.WriteByte(c)
+				continue
+			}
+			if i+1 >= len(line) || line[i+1] != '[' {
+				continue
+			}
+			j := i + 2
+			for j < len(line) && (line[j] < 0x40 || line[j] > 0x7e) {
+				j++
+			}
+			if j >= len(line) {
+				break
+			}
+			if line[j] == 'm' {
+				flush()
+				style = applySGR(style, line[i+2:j])
+			}
+			i = j
+		}
+		flush()
+		lines = append(lines, spans)
+	}
+	return lines
+}
+
+// Apply returns the style after applying the parameters of an SGR Escape
+// Code, such as "1;31" for "\x1b[1;31m".
+func (style ANSIStyle) Apply(params string) ANSIStyle {
+	return applySGR(style, params)
+}
+
+func applySGR(style ANSIStyle, params string) ANSIStyle {
+	if params == "" {
+		params = "0"
+	}
+	fields := strings.Split(params, ";")
+	for i := 0; i < len(fields); i++ {
+		n, err := strconv.Atoi(fields[i])
+		if err != nil {
+			continue
+		}
+		switch {
+		case n == 38 || n == 48:
+			// Extended colors, "5;index" or "2;r;g;b", are approximated with
+			// the nearest of the 16 colors.
+			c, used := extendedColor(fields[i+1:])
+			i += used
+			if c < 0 {
+				continue
+			}
+			if n == 38 {
+				style.Foreground = c
+			} else {
+				style.Background = c
+			}
+		case n == 0:
+			style = ANSIStyle{Foreground: -1, Background: -1}
+		case n == 1:
+			style.Bold = true
+		case n == 4:
+			style.Underline = true
+		case n == 9:
+			style.StrikeThrough = true
+		case n == 22:
+			style.Bold = false
+		case n == 24:
+			style.Underline = false
+		case n == 29:
+			style.StrikeThrough = false
+		case n >= 30 && n <= 37:
+			style.Foreground = n - 30
+		case n == 39:
+			style.Foreground = -1
+		case n >= 40 && n <= 47:
+			style.Background = n - 40
+		case n == 49:
+			style.Background = -1
+		case n >= 90 && n <= 97:
+			style.Foreground = n - 90 + 8
+		case n >= 100 && n <= 107:
+			style.Background = n - 100 + 8
+		}
+	}
+	return style
+}
+
+// extendedColor parses the parameters following a 38 or 48 SGR parameter and
+// returns the nearest ANSIPalette index, or -1, and how many parameters it
+// used.
+func extendedColor(fields []string) (int, int) {
+	if len(fields) == 0 {
+		return -1, 0
+	}
+	var r, g, b int
+	switch fields[0] {
+	case "5":
+		if len(fields) < 2 {
+			return -1, len(fields)
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 0 || n > 255 {
+			return -1, 2
+		}
+		switch {
+		case n < 16:
+			return n, 2
+		case n < 232:
+			n -= 16
+			levels := []int{0, 95, 135, 175, 215, 255}
+			r, g, b = levels[n/36], levels[n/6%6], levels[n%6]
+		default:
+			r = 8 + (n-232)*10
+			g, b = r, r
+		}
+		return nearestColor(r, g, b), 2
+	case "2":
+		if len(fields) < 4 {
+			return -1, len(fields)
+		}
+		r, _ = strconv.Atoi(fields[1])
+		g, _ = strconv.Atoi(fields[2])
+		b, _ = strconv.Atoi(fields[3])
+		return nearestColor(r, g, b), 4
+	}
+	return -1, 1
+}
+
+// nearestColor returns the ANSIPalette index closest to the color.
+func nearestColor(r, g, b int) int {
+	best, bestDistance := 0, -1
+	for i, hex := range ANSIPalette {
+		var pr, pg, pb int
+		fmt.Sscanf(hex, "#%02x%02x%02x", &pr, &pg, &pb)
+		distance := (r-pr)*(r-pr) + (g-pg)*(g-pg) + (b-pb)*(b-pb)
+		if bestDistance == -1 || distance < bestDistance {
+			best, bestDistance = i, distance
+		}
+	}
+	return best
+}
+
+// ANSIPalette is the CSS colors used for the 16 ANSI colors when exporting.
+var ANSIPalette = [16]string{
+	"#000000", "#cd0000", "#00cd00", "#cdcd00",
+	"#0000ee", "#cd00cd", "#00cdcd", "#e5e5e5",
+	"#7f7f7f", "#ff0000", "#00ff00", "#ffff00",
+	"#5c5cff", "#ff00ff", "#00ffff", "#ffffff",
+}
+
+// ANSIExportOptions controls ANSIToHTMLDocument and ANSIToSVG.
+type ANSIExportOptions struct {
+	// Title is the document title; may be empty.
+	Title string
+	// FontFamily is the CSS font-family; "monospace" if empty.
+	FontFamily string
+	// FontSize is the font size in pixels; 14 if 0.
+	FontSize float64
+	// Columns is the width of the output in characters; if 0 the longest
+	// line is used.
+	Columns int
+	// Foreground is the default CSS text color; "#e5e5e5" if empty.
+	Foreground string
+	// Background is the CSS background color; "#1c1c1c" if empty.
+	Background string
+}
+
+func resolveExportOptions(opts *ANSIExportOptions) *ANSIExportOptions {
+	ropts := &ANSIExportOptions{}
+	if opts != nil {
+		*ropts = *opts
+	}
+	if ropts.FontFamily == "" {
+		ropts.FontFamily = "monospace"
+	}
+	if ropts.FontSize <= 0 {
+		ropts.FontSize = 14
+	}
+	if ropts.Foreground == "" {
+		ropts.Foreground = "#e5e5e5"
+	}
+	if ropts.Background == "" {
+		ropts.Background = "#1c1c1c"
+	}
+	return ropts
+}
+
+// css returns the style as CSS declarations, using the property names given
+// for the colors; an empty background property omits the background.
+func (style ANSIStyle) css(foreground, background string) string {
+	var decls []string
+	if style.Foreground >= 0 {
+		decls = append(decls, foreground+":"+ANSIPalette[style.Foreground])
+	}
+	if style.Background >= 0 && background != "" {
+		decls = append(decls, background+":"+ANSIPalette[style.Background])
+	}
+	if style.Bold {
+		decls = append(decls, "font-weight:bold")
+	}
+	if style.Underline && style.StrikeThrough {
+		decls = append(decls, "text-decoration:underline line-through")
+	} else if style.Underline {
+		decls = append(decls, "text-decoration:underline")
+	} else if style.StrikeThrough {
+		decls = append(decls, "text-decoration:line-through")
+	}
+	return strings.Join(decls, ";")
+}
+
+// ANSIToHTML converts text containing ANSI Escape Codes into HTML, with the
+// styles translated to inline CSS. The result is meant to be placed within a
+// <pre> element.
+func ANSIToHTML(text string) string {
+	var out bytes.Buffer
+	for i, line := range ParseANSI(text) {
+		if i > 0 {
+			out.WriteByte('\n')
+		}
+		for _, span := range line {
+			css := span.Style.css("color", "background-color")
+			if css == "" {
+				out.WriteString(html.EscapeString(span.Text))
+				continue
+			}
+			fmt.Fprintf(&out, `<span style="%s">%s</span>`, css, html.EscapeString(span.Text))
+		}
+	}
+	return out.String()
+}
+
+// ANSIToHTMLDocument converts text containing ANSI Escape Codes into a
+// standalone HTML document showing the text in a <pre> element. If opts is nil
+// the defaults will be used.
+func ANSIToHTMLDocument(text string, opts *ANSIExportOptions) string {
+	opts = resolveExportOptions(opts)
+	var out bytes.Buffer
+	out.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&out, "<title>%s</title>\n", html.EscapeString(opts.Title))
+	out.WriteString("</head>\n<body>\n")
+	width := ""
+	if opts.Columns > 0 {
+		width = fmt.Sprintf("width:%dch;", opts.Columns)
+	}
+	fmt.Fprintf(&out, "<pre style=\"%sfont-family:%s;font-size:%spx;color:%s;background-color:%s;padding:1em\">", width, html.EscapeString(opts.FontFamily), px(opts.FontSize), opts.Foreground, opts.Background)
+	out.WriteString(ANSIToHTML(text))
+	out.WriteString("</pre>\n</body>\n</html>\n")
+	return out.String()
+}
+
+// ANSIToSVG converts text containing ANSI Escape Codes into an SVG image of
+// the text as it would appear on a terminal. Each character takes up the cells
+// DisplayWidth gives it, two for wide characters such as CJK and emoji. If
+// opts is nil the defaults will be used.
+func ANSIToSVG(text string, opts *ANSIExportOptions) string {
+	opts = resolveExportOptions(opts)
+	lines := ParseANSI(strings.TrimRight(text, "\n"))
+	columns := opts.Columns
+	if columns == 0 {
+		for _, line := range lines {
+			n := 0
+			for _, span := range line {
+				n += DisplayWidth(span.Text)
+			}
+			if n > columns {
+				columns = n
+			}
+		}
+	}
+	cellWidth := opts.FontSize * 0.6
+	lineHeight := opts.FontSize * 1.2
+	width := float64(columns)*cellWidth + 2*cellWidth
+	height := float64(len(lines))*lineHeight + lineHeight
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%s\" height=\"%s\" viewBox=\"0 0 %s %s\">\n", px(width), px(height), px(width), px(height))
+	if opts.Title != "" {
+		fmt.Fprintf(&out, "<title>%s</title>\n", html.EscapeString(opts.Title))
+	}
+	fmt.Fprintf(&out, "<rect width=\"100%%\" height=\"100%%\" fill=\"%s\"/>\n", opts.Background)
+	fmt.Fprintf(&out, "<g font-family=\"%s\" font-size=\"%s\" fill=\"%s\" xml:space=\"preserve\">\n", html.EscapeString(opts.FontFamily), px(opts.FontSize), opts.Foreground)
+	for i, line := range lines {
+		y := lineHeight/2 + float64(i)*lineHeight
+		column := 0
+		for _, span := range line {
+			n := DisplayWidth(span.Text)
+			if span.Style.Background >= 0 {
+				fmt.Fprintf(&out, "<rect x=\"%s\" y=\"%s\" width=\"%s\" height=\"%s\" fill=\"%s\"/>\n", px(cellWidth+float64(column)*cellWidth), px(y), px(float64(n)*cellWidth), px(lineHeight), ANSIPalette[span.Style.Background])
+			}
+			column += n
+		}
+		fmt.Fprintf(&out, "<text x=\"%s\" y=\"%s\">", px(cellWidth), px(y+opts.FontSize))
+		column = 0
+		for _, span := range line {
+			// Backgrounds were drawn as rects above.
+			css := span.Style.css("fill", "")
+			attrs := fmt.Sprintf(" x=\"%s\"", px(cellWidth+float64(column)*cellWidth))
+			if css != "" {
+				attrs += fmt.Sprintf(" style=\"%s\"", css)
+			}
+			fmt.Fprintf(&out, "<tspan%s>%s</tspan>", attrs, html.EscapeString(span.Text))
+			column += DisplayWidth(span.Text)
+		}
+		out.WriteString("</text>\n")
+	}
+	out.WriteString("</g>\n</svg>\n")
+	return out.String()
+}
+
+// px formats a coordinate without spurious precision.
+func px(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 32)
+}
@@ -0,0 +1,97 @@
+package brimtext
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseANSI(t *testing.T) {
+	plain := ANSIStyle{Foreground: -1, Background: -1}
+	bold := ANSIStyle{Foreground: -1, Background: -1, Bold: true}
+	boldRed := ANSIStyle{Foreground: 1, Background: -1, Bold: true}
+	for _, test := range []struct {
+		text string
+		want [][]ANSISpan
+	}{
+		{"", [][]ANSISpan{nil}},
+		{"plain\r", [][]ANSISpan{{{"plain", plain}}}},
+		{"a\x1b[1mb\x1b[0mc", [][]ANSISpan{{{"a", plain}, {"b", bold}, {"c", plain}}}},
+		// Styles carry over lines and combine.
+		{"\x1b[1mx\ny\x1b[31mz\x1b[m", [][]ANSISpan{{{"x", bold}}, {{"y", bold}, {"z", boldRed}}}},
+		// Non-SGR sequences are dropped, as are truncated ones.
+		{"a\x1b[2Kb\x1b]c\x1b[", [][]ANSISpan{{{"ab]c", plain}}}},
+		{"\x1b[4;9;93;104mu\x1b[24;29;39;49mv", [][]ANSISpan{{
+			{"u", ANSIStyle{Foreground: 11, Background: 12, Underline: true, StrikeThrough: true}},
+			{"v", plain},
+		}}},
+	} {
+		if got := ParseANSI(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseANSI(%q):\n got %+v\nwant %+v", test.text, got, test.want)
+		}
+	}
+}
+
+func TestApplyExtendedColors(t *testing.T) {
+	plain := ANSIStyle{Foreground: -1, Background: -1}
+	for _, test := range []struct {
+		params     string
+		foreground int
+		background int
+	}{
+		{"38;5;9", 9, -1},
+		{"38;5;196", 9, -1},
+		{"48;5;21", -1, 4},
+		{"38;2;0;205;0", 2, -1},
+		{"48;2;255;255;255", -1, 15},
+		{"38;5;232", 0, -1},
+		{"38;5", -1, -1},
+		{"38;5;300;1", -1, -1},
+	} {
+		got := plain.Apply(test.params)
+		if got.Foreground != test.foreground || got.Background != test.background {
+			t.Errorf("Apply(%q) = %+v, want foreground %d, background %d", test.params, got, test.foreground, test.background)
+		}
+	}
+}
+
+func TestANSIToHTML(t *testing.T) {
+	got := ANSIToHTML("<a>\x1b[1;31m&b\x1b[0m\n\x1b[4;9mc")
+	want := `&lt;a&gt;<span style="color:#cd0000;font-weight:bold">&amp;b</span>` + "\n" +
+		`<span style="text-decoration:underline line-through">c</span>`
+	if got != want {
+		t.Errorf("ANSIToHTML:\n got %q\nwant %q", got, want)
+	}
+	doc := ANSIToHTMLDocument("x", &ANSIExportOptions{Title: "<t>", Columns: 40})
+	for _, s := range []string{"<title>&lt;t&gt;</title>", "width:40ch;", "font-size:14px", "<pre"} {
+		if !strings.Contains(doc, s) {
+			t.Errorf("ANSIToHTMLDocument doesn't contain %q:\n%s", s, doc)
+		}
+	}
+}
+
+func TestANSIToSVG(t *testing.T) {
+	got := ANSIToSVG("ab\x1b[41mcd\x1b[0m\n", &ANSIExportOptions{FontSize: 10})
+	for _, s := range []string{
+		// 4 columns and 1 line, with a margin of a cell either side and
+		// half a line above and below.
+		`width="36" height="24"`,
+		`<rect x="18" y="6" width="12" height="12" fill="#cd0000"/>`,
+		`<tspan x="6">ab</tspan><tspan x="18">cd</tspan>`,
+	} {
+		if !strings.Contains(got, s) {
+			t.Errorf("ANSIToSVG doesn't contain %q:\n%s", s, got)
+		}
+	}
+	// Wide characters take two cells.
+	got = ANSIToSVG("漢字\x1b[41mx\x1b[0m\n", &ANSIExportOptions{FontSize: 10})
+	for _, s := range []string{
+		`width="42" height="24"`,
+		`<rect x="30" y="6" width="6" height="12" fill="#cd0000"/>`,
+		`<tspan x="6">漢字</tspan><tspan x="30">x</tspan>`,
+	} {
+		if !strings.Contains(got, s) {
+			t.Errorf("ANSIToSVG doesn't contain %q:\n%s", s, got)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -143,28 +144,13 @@ func wrap(text []byte, width int, indent1 []byte, indent2 []byte) []byte {
 		lineLen := 0
 		start := true
 		for _, word := range bytes.Split(par, []byte{' '}) {
-			wordLen := utf8.RuneCount(word)
-			if wordLen == 0 {
+			if len(word) == 0 {
 				continue
 			}
-			scan := word
-			for len(scan) > 1 {
-				i := bytes.IndexByte(scan, '\x1b')
-				if i == -1 {
-					break
-				}
-				j := bytes.IndexByte(scan[i+1:], 'm')
-				if j == -1 {
-					i++
-				} else {
-					j += 2
-					wordLen -= j
-					scan = scan[i+j:]
-				}
-			}
+			wordLen := DisplayWidth(string(word))
 			if start {
 				out.Write(indent1)
-				lineLen += utf8.RuneCount(indent1)
+				lineLen += DisplayWidth(string(indent1))
 				out.Write(word)
 				lineLen += wordLen
 				start = false
@@ -172,7 +158,7 @@ func wrap(text []byte, width int, indent1 []byte, indent2 []byte) []byte {
 				out.WriteByte('\n')
 				out.Write(indent2)
 				out.Write(word)
-				lineLen = utf8.RuneCount(indent2) + wordLen
+				lineLen = DisplayWidth(string(indent2)) + wordLen
 			} else {
 				out.WriteByte(' ')
 				out.Write(word)
@@ -185,6 +171,91 @@ func wrap(text []byte, width int, indent1 []byte, indent2 []byte) []byte {
 	return out.Bytes()
 }
 
+// DisplayWidth returns the number of terminal columns the text takes up, not
+// counting ANSI Escape Codes of the "\x1b...m" form, which take no room on a
+// terminal. See RuneWidth; a character followed by the emoji variation
+// selector (U+FE0F) is shown as a wide emoji too.
+func DisplayWidth(text string) int {
+	width := 0
+	last := 0
+	for i := 0; i < len(text); {
+		if text[i] == '\x1b' {
+			if j := strings.IndexByte(text[i:], 'm'); j != -1 {
+				i += j + 1
+				continue
+			}
+		}
+		r, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+		w := RuneWidth(r)
+		if r == emojiPresentation && last == 1 {
+			width++
+			last = 2
+			continue
+		}
+		width += w
+		last = w
+	}
+	return width
+}
+
+// emojiPresentation is the variation selector asking for a character to be
+// shown as an emoji.
+const emojiPresentation = '\ufe0f'
+
+// RuneWidth returns the number of terminal columns the rune takes up: 2 for
+// East Asian wide and fullwidth characters, emoji included, 0 for combining
+// marks and other zero width characters and 1 otherwise.
+func RuneWidth(r rune) int {
+	switch {
+	case r < 0x300:
+		return 1
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	}
+	lo, hi := 0, len(wideRunes)
+	for lo < hi {
+		m := (lo + hi) / 2
+		switch {
+		case r < wideRunes[m][0]:
+			hi = m
+		case r > wideRunes[m][1]:
+			lo = m + 1
+		default:
+			return 2
+		}
+	}
+	return 1
+}
+
+// wideRunes are the ranges of East Asian wide and fullwidth characters, as in
+// Unicode's EastAsianWidth.txt, which include the emoji shown as such by
+// default.
+var wideRunes = [][2]rune{
+	{0x1100, 0x115f}, {0x231a, 0x231b}, {0x2329, 0x232a}, {0x23e9, 0x23ec},
+	{0x23f0, 0x23f0}, {0x23f3, 0x23f3}, {0x25fd, 0x25fe}, {0x2614, 0x2615},
+	{0x2648, 0x2653}, {0x267f, 0x267f}, {0x2693, 0x2693}, {0x26a1, 0x26a1},
+	{0x26aa, 0x26ab}, {0x26bd, 0x26be}, {0x26c4, 0x26c5}, {0x26ce, 0x26ce},
+	{0x26d4, 0x26d4}, {0x26ea, 0x26ea}, {0x26f2, 0x26f3}, {0x26f5, 0x26f5},
+	{0x26fa, 0x26fa}, {0x26fd, 0x26fd}, {0x2705, 0x2705}, {0x270a, 0x270b},
+	{0x2728, 0x2728}, {0x274c, 0x274c}, {0x274e, 0x274e}, {0x2753, 0x2755},
+	{0x2757, 0x2757}, {0x2795, 0x2797}, {0x27b0, 0x27b0}, {0x27bf, 0x27bf},
+	{0x2b1b, 0x2b1c}, {0x2b50, 0x2b50}, {0x2b55, 0x2b55}, {0x2e80, 0x303e},
+	{0x3041, 0x33ff}, {0x3400, 0x4dbf}, {0x4e00, 0x9fff}, {0xa000, 0xa4cf},
+	{0xa960, 0xa97f}, {0xac00, 0xd7a3}, {0xf900, 0xfaff}, {0xfe10, 0xfe19},
+	{0xfe30, 0xfe6f}, {0xff00, 0xff60}, {0xffe0, 0xffe6}, {0x16fe0, 0x16fe4},
+	{0x17000, 0x18cff}, {0x1b000, 0x1b2ff}, {0x1f004, 0x1f004}, {0x1f0cf, 0x1f0cf},
+	{0x1f18e, 0x1f18e}, {0x1f191, 0x1f19a}, {0x1f200, 0x1f2ff}, {0x1f300, 0x1f320},
+	{0x1f32d, 0x1f335}, {0x1f337, 0x1f37c}, {0x1f37e, 0x1f393}, {0x1f3a0, 0x1f3ca},
+	{0x1f3cf, 0x1f3d3}, {0x1f3e0, 0x1f3f0}, {0x1f3f4, 0x1f3f4}, {0x1f3f8, 0x1f43e},
+	{0x1f440, 0x1f440}, {0x1f442, 0x1f4fc}, {0x1f4ff, 0x1f53d}, {0x1f54b, 0x1f54e},
+	{0x1f550, 0x1f567}, {0x1f57a, 0x1f57a}, {0x1f595, 0x1f596}, {0x1f5a4, 0x1f5a4},
+	{0x1f5fb, 0x1f64f}, {0x1f680, 0x1f6c5}, {0x1f6cc, 0x1f6cc}, {0x1f6d0, 0x1f6d2},
+	{0x1f6d5, 0x1f6d7}, {0x1f6dc, 0x1f6df}, {0x1f6eb, 0x1f6ec}, {0x1f6f4, 0x1f6fc},
+	{0x1f7e0, 0x1f7eb}, {0x1f7f0, 0x1f7f0}, {0x1f90c, 0x1f93a}, {0x1f93c, 0x1f945},
+	{0x1f947, 0x1f9ff}, {0x1fa70, 0x1faff}, {0x20000, 0x2fffd}, {0x30000, 0x3fffd},
+}
+
 // AllEqual returns true if all the values are equal strings; no strings,
 // AllEqual() or AllEqual([]string{}...), are considered AllEqual.
 func AllEqual(values ...string) bool {
@@ -0,0 +1,49 @@
+package brimtext
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDisplayWidth(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"ünïcödé", 7},
+		{"\x1b[1mbold\x1b[0m", 4},
+		// CJK and emoji take two columns, combining marks none.
+		{"漢字", 4},
+		{"한국어", 6},
+		{"ｆｕｌｌ", 8},
+		{"Go 🚀 👍", 8},
+		{"e\u0301", 1},
+		// An emoji variation selector makes a narrow character wide.
+		{"❤️", 2},
+		{"❤", 1},
+	} {
+		if got := DisplayWidth(test.text); got != test.want {
+			t.Errorf("DisplayWidth(%q) = %d, want %d", test.text, got, test.want)
+		}
+	}
+}
+
+func TestAlignWide(t *testing.T) {
+	data := [][]string{{"name", "x"}, {"漢字テキスト", "y"}, {"🚀 rocket", "z"}}
+	out := Align(data, NewUnicodeBoxedAlignOptions())
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	for _, line := range lines {
+		if w := DisplayWidth(line); w != DisplayWidth(lines[0]) {
+			t.Errorf("line %q is %d wide, first line %d:\n%s", line, w, DisplayWidth(lines[0]), out)
+		}
+	}
+}
+
+func TestWrapWide(t *testing.T) {
+	got := Wrap("漢字 漢字 漢字 漢字", 10, "", "")
+	if want := "漢字 漢字\n漢字 漢字"; got != want {
+		t.Errorf("Wrap = %q, want %q", got, want)
+	}
+}
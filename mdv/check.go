@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/rn/utils/mdv/blackfridaytext"
+)
+
+var (
+	refDefRe   = regexp.MustCompile(`^ {0,3}\[([^\]]+)\]:`)
+	refUseRe   = regexp.MustCompile(`\[([^\]]+)\]\[([^\]]*)\]`)
+	codeSpanRe = regexp.MustCompile("`+[^`]*`+")
+)
+
+// checker checks the links of markdown files, reporting problems as
+// "file:line: message".
+type checker struct {
+	out      io.Writer
+	problems int
+	// found holds the problems of the current file, to be reported in line
+	// order.
+	found []problem
+	// anchors caches the anchors of each file checked or linked to.
+	anchors map[string]map[string]bool
+}
+
+type problem struct {
+	line    int
+	message string
+}
+
+// check checks the markdown file, or all markdown files under the directory,
+// and returns the number of problems found.
+func check(path string, out io.Writer) (int, error) {
+	c := &checker{out: out, anchors: map[string]map[string]bool{}}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if !fi.IsDir() {
+		return c.problems, c.checkFile(path)
+	}
+	err = filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if p != path && (fi.Name() == "vendor" || strings.HasPrefix(fi.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isMarkdown(p) {
+			return c.checkFile(p)
+		}
+		return nil
+	})
+	return c.problems, err
+}
+
+func isMarkdown(fileName string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	return ext == ".md" || ext == ".markdown"
+}
+
+func (c *checker) report(line int, format string, args ...interface{}) {
+	c.found = append(c.found, problem{line: line, message: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) checkFile(fileName string) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	_, position := blackfridaytext.MarkdownMetadata(data)
+	lineOffset := bytes.Count(data[:position], []byte("\n"))
+	markdown := data[position:]
+
+	seen := map[string]int{}
+	for _, b := range blackfridaytext.MarkdownBoundaries(markdown) {
+		if b.Level == 0 {
+			continue
+		}
+		id := b.ID()
+		if first, ok := seen[id]; ok {
+			c.report(b.Line+lineOffset, "duplicate anchor #%s, first used on line %d", id, first)
+			continue
+		}
+		seen[id] = b.Line + lineOffset
+	}
+
+	for _, link := range blackfridaytext.MarkdownLinks(markdown) {
+		c.checkLink(fileName, link.Line+lineOffset, link)
+	}
+	c.checkReferences(markdown, lineOffset)
+	sort.SliceStable(c.found, func(i, j int) bool { return c.found[i].line < c.found[j].line })
+	for _, p := range c.found {
+		fmt.Fprintf(c.out, "%s:%d: %s\n", fileName, p.line, p.message)
+	}
+	c.problems += len(c.found)
+	c.found = nil
+	return nil
+}
+
+func (c *checker) checkLink(fileName string, line int, link blackfridaytext.Link) {
+	if link.Auto {
+		return
+	}
+	u, err := url.Parse(link.Target)
+	if err != nil {
+		c.report(line, "invalid link %q: %v", link.Target, err)
+		return
+	}
+	if u.Scheme != "" || u.Host != "" || strings.HasPrefix(u.Path, "/") {
+		return
+	}
+	target := fileName
+	if u.Path != "" {
+		target = filepath.Join(filepath.Dir(fileName), filepath.FromSlash(u.Path))
+		fi, err := os.Stat(target)
+		if err != nil {
+			if link.Image {
+				c.report(line, "image %s does not exist", link.Target)
+			} else {
+				c.report(line, "link to missing file %s", link.Target)
+			}
+			return
+		}
+		if fi.IsDir() {
+			return
+		}
+	}
+	if u.Fragment == "" || link.Image || !isMarkdown(target) {
+		return
+	}
+	if !c.fileAnchors(target)[u.Fragment] {
+		c.report(line, "no anchor #%s in %s", u.Fragment, filepath.Base(target))
+	}
+}
+
+// fileAnchors returns the header anchors of the markdown file: both the
+// anchors blackfriday generates and those GitHub generates.
+func (c *checker) fileAnchors(fileName string) map[string]bool {
+	if anchors, ok := c.anchors[fileName]; ok {
+		return anchors
+	}
+	anchors := map[string]bool{}
+	if data, err := ioutil.ReadFile(fileName); err == nil {
+		_, position := blackfridaytext.MarkdownMetadata(data)
+		for _, h := range blackfridaytext.MarkdownHeaders(data[position:]) {
+			anchors[h.ID] = true
+			anchors[githubAnchor(h.Text)] = true
+		}
+	}
+	c.anchors[fileName] = anchors
+	return anchors
+}
+
+// githubAnchor returns the anchor GitHub generates for a header: lower case,
+// with spaces replaced by "-" and punctuation other than "-" and "_" dropped.
+func githubAnchor(text string) string {
+	var out []rune
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case r == ' ':
+			out = append(out, '-')
+		case r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsNumber(r):
+			out = append(out, r)
+		}
+	}
+	return string(out)
+}
+
+// checkReferences reports reference style links, [text][ref] and [ref][], that
+// have no definition. Blackfriday renders those as plain text, so they can
+// only be found in the source.
+func (c *checker) checkReferences(markdown []byte, lineOffset int) {
+	defined := map[string]bool{}
+	type use struct {
+		ref  string
+		line int
+	}
+	var uses []use
+	inFence := false
+	for i, line := range strings.Split(string(markdown), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+			continue
+		}
+		if inFence || strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+		if m := refDefRe.FindStringSubmatch(line); m != nil {
+			defined[strings.ToLower(m[1])] = true
+			continue
+		}
+		line = codeSpanRe.ReplaceAllString(line, "")
+		for _, m := range refUseRe.FindAllStringSubmatch(line, -1) {
+			ref := m[2]
+			if ref == "" {
+				ref = m[1]
+			}
+			uses = append(uses, use{ref: ref, line: i + 1 + lineOffset})
+		}
+	}
+	for _, u := range uses {
+		if !defined[strings.ToLower(u.ref)] {
+			c.report(u.line, "reference [%s] is not defined", u.ref)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mdv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"README.md": "Title: test\n\n# Intro\n\nSee other.md, [other](other.md#usage)\nand [missing](missing.md).\n\n" +
+			"Again [other](other.md#nope) and [dir](sub) and ![pic](pic.png).\n\n# Intro\n\n[undefined][nowhere]\n",
+		"other.md":   "# Usage\n",
+		"sub/doc.md": "[up](../README.md#intro) [web](https://example.com/missing.md)\n",
+	}
+	for name, text := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(name), 0755)
+		if err := ioutil.WriteFile(name, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	problems, err := check(dir, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readme := filepath.Join(dir, "README.md")
+	want := strings.Join([]string{
+		readme + ":6: link to missing file missing.md",
+		readme + ":8: no anchor #nope in other.md",
+		readme + ":8: image pic.png does not exist",
+		readme + ":10: duplicate anchor #intro, first used on line 3",
+		readme + ":12: reference [nowhere] is not defined",
+	}, "\n") + "\n"
+	if out.String() != want || problems != 5 {
+		t.Errorf("check found %d problems:\n%s\nwant:\n%s", problems, out.String(), want)
+	}
+}
+
+func TestGithubAnchor(t *testing.T) {
+	for text, want := range map[string]string{
+		"Known issues":         "known-issues",
+		"What's new in v1.2?":  "whats-new-in-v12",
+		"snake_case - Ünïcode": "snake_case---ünïcode",
+	} {
+		if got := githubAnchor(text); got != want {
+			t.Errorf("githubAnchor(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
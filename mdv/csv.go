@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rn/utils/mdv/blackfridaytext"
+	"github.com/rn/utils/mdv/brimtext"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// csvPageRows is how many rows are read and aligned at a time when not paging
+// on a terminal.
+const csvPageRows = 1000
+
+// isTable reports whether the file name is that of a CSV or TSV file.
+func isTable(fileName string) bool {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv", ".tsv":
+		return true
+	}
+	return false
+}
+
+// csvTable renders the CSV (or, for .tsv files, tab separated) file as boxed
+// tables of width columns, with the first row as the header and numeric
+// columns right aligned. The file is read a page at a time: if pager is true
+// and the terminal allows, a screenful is shown at a time, waiting for a key
+// press before the next.
+func csvTable(fileName string, out io.Writer, width int, pager bool) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	if strings.ToLower(filepath.Ext(fileName)) == ".tsv" {
+		r.Comma = '\t'
+		r.LazyQuotes = true
+	}
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if width < 1 {
+		width += brimtext.GetTTYWidth() - 1
+	}
+
+	rows := csvPageRows
+	var state *terminal.State
+	inFd := int(os.Stdin.Fd())
+	if pager && terminal.IsTerminal(inFd) && terminal.IsTerminal(int(os.Stdout.Fd())) {
+		if _, height, err := terminal.GetSize(int(os.Stdout.Fd())); err == nil && height > 8 {
+			// Leave room for the borders, the header and the prompt.
+			rows = height - 5
+		}
+		if state, err = terminal.MakeRaw(inFd); err != nil {
+			return err
+		}
+		defer terminal.Restore(inFd, state)
+	}
+
+	var widths []int
+	var alignments []brimtext.Alignment
+	for {
+		page := [][]string{header, nil}
+		for len(page) < rows+2 {
+			record, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			page = append(page, record)
+		}
+		if len(page) == 2 && widths != nil {
+			return nil
+		}
+		// Column widths and alignments are worked out from the first page and
+		// kept, so the columns of later pages line up.
+		if widths == nil {
+			widths, alignments = csvColumns(page)
+		}
+		for _, row := range page {
+			for c := len(widths); c < len(row); c++ {
+				widths = append(widths, brimtext.DisplayWidth(row[c]))
+				alignments = append(alignments, brimtext.Left)
+			}
+		}
+		// Records may have fewer fields than others, or than the header,
+		// but every row needs a cell in every column to draw the grid.
+		for i, row := range page {
+			if row != nil {
+				page[i] = padRow(row, len(widths))
+			}
+		}
+		opts := brimtext.NewUnicodeBoxedAlignOptions()
+		// One line per record, rather than a rule between each, fits more of
+		// the data on the screen.
+		opts.NilBetweenEveryRow = false
+		opts.Widths = append([]int(nil), widths...)
+		opts.Alignments = alignments
+		text := blackfridaytext.AlignToWidth(page, opts, width)
+		if state != nil {
+			text = strings.Replace(text, "\n", "\r\n", -1)
+		}
+		if _, err := io.WriteString(out, text); err != nil {
+			return err
+		}
+		if len(page) < rows+2 {
+			return nil
+		}
+		if state != nil && !morePrompt() {
+			return nil
+		}
+	}
+}
+
+// csvColumns returns the width of each column and right aligns those where
+// every non empty cell, other than the header, is a number.
+func csvColumns(page [][]string) ([]int, []brimtext.Alignment) {
+	var widths []int
+	var numeric []bool
+	for i, row := range page {
+		for c, cell := range row {
+			for c >= len(widths) {
+				widths = append(widths, 0)
+				numeric = append(numeric, true)
+			}
+			if n := brimtext.DisplayWidth(cell); n > widths[c] {
+				widths[c] = n
+			}
+			if i > 0 && numeric[c] && strings.TrimSpace(cell) != "" {
+				if _, err := strconv.ParseFloat(strings.TrimSpace(cell), 64); err != nil {
+					numeric[c] = false
+				}
+			}
+		}
+	}
+	alignments := make([]brimtext.Alignment, len(widths))
+	for c := range alignments {
+		if numeric[c] && len(page) > 2 {
+			alignments[c] = brimtext.Right
+		}
+	}
+	return widths, alignments
+}
+
+// padRow returns the row with empty cells added to make it n cells long.
+func padRow(row []string, n int) []string {
+	for len(row) < n {
+		row = append(row, "")
+	}
+	return row
+}
+
+// morePrompt waits for a key press, in raw mode, and returns false if it was
+// q, Escape or Ctrl-C.
+func morePrompt() bool {
+	os.Stdout.WriteString("\x1b[7m-- More -- (space: next page, q: quit)\x1b[0m")
+	b := make([]byte, 8)
+	n, err := os.Stdin.Read(b)
+	os.Stdout.WriteString("\r\x1b[K")
+	if err != nil || n == 0 {
+		return false
+	}
+	switch b[0] {
+	case 'q', 'Q', 0x1b, 0x03:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rn/utils/mdv/brimtext"
+)
+
+func TestCSVTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mdv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, text := range map[string]string{
+		"ragged.csv": "a,b\n1,2,3,4\nx\n\"quoted, comma\",5\n",
+		"tabs.tsv":   "name\tsize\nünïcode\t10\nplain\t2.5\n",
+	} {
+		fileName := filepath.Join(dir, name)
+		ioutil.WriteFile(fileName, []byte(text), 0644)
+		var out bytes.Buffer
+		if err := csvTable(fileName, &out, 60, false); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		lines := strings.Split(strings.TrimRight(out.String(), "\n"), "\n")
+		for _, line := range lines[1:] {
+			if brimtext.DisplayWidth(line) != brimtext.DisplayWidth(lines[0]) {
+				t.Errorf("%s: ragged table:\n%s", name, out.String())
+				break
+			}
+		}
+	}
+}
+
+func TestCSVColumns(t *testing.T) {
+	page := [][]string{
+		{"name", "size", "note"},
+		nil,
+		{"ünïcode", "10", ""},
+		{"plain", " 2.5", "x", "extra"},
+		{"z", "", "3"},
+	}
+	widths, alignments := csvColumns(page)
+	if want := []int{7, 4, 4, 5}; !reflect.DeepEqual(widths, want) {
+		t.Errorf("widths %v, want %v", widths, want)
+	}
+	want := []brimtext.Alignment{brimtext.Left, brimtext.Right, brimtext.Left, brimtext.Left}
+	if !reflect.DeepEqual(alignments, want) {
+		t.Errorf("alignments %v, want %v", alignments, want)
+	}
+}
+
+func TestIsTable(t *testing.T) {
+	for name, want := range map[string]bool{"a.csv": true, "A.TSV": true, "a.md": false, "csv": false} {
+		if isTable(name) != want {
+			t.Errorf("isTable(%q) = %v", name, !want)
+		}
+	}
+}
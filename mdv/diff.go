@@ -0,0 +1,737 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/rn/utils/mdv/blackfridaytext"
+	"github.com/rn/utils/mdv/brimtext"
+)
+
+// The diff is marked in the merged markdown with fake ANSI Escape Codes, which
+// the renderer passes through and treats as zero width, and diffStyles
+// replaces them with real ones once rendered.
+const (
+	markInsert     = "\x1bIm"
+	markDelete     = "\x1bDm"
+	markEnd        = "\x1bEm"
+	markCell       = "\x1bTm"
+	markCellEnd    = "\x1btm"
+	markCollapsed  = "\x1bSm"
+	styleInsert    = "\x1b[32m"
+	styleDelete    = "\x1b[31;9m"
+	styleEnd       = "\x1b[39;29m"
+	styleCell      = "\x1b[100m"
+	styleCellEnd   = "\x1b[49m"
+	styleCollapsed = "\x1b[90m"
+)
+
+// maxDiffCells limits the size of the table diffOps uses; larger changes are
+// shown as a deletion and an insertion rather than diffed.
+const maxDiffCells = 4000000
+
+var (
+	// linePrefix matches the indent and block markers, such as "# ", "- ",
+	// "1. " and "> ", at the start of a line, which are never marked.
+	linePrefix = regexp.MustCompile(`^[ \t]*(?:(?:#{1,6}|[-*+]|\d+[.)])[ \t]+|>[ \t]?)*`)
+	// layoutLine matches lines which are all markup: fences, setext header
+	// underlines, horizontal rules and reference definitions.
+	layoutLine = regexp.MustCompile("^ {0,3}(?:```|~~~|=+[ \t]*$|-+[ \t]*$|(?:[-*_][ \t]*){3,}$|\\[[^\\]]+\\]:)")
+	// atxHeader matches an ATX header line.
+	atxHeader = regexp.MustCompile(`^ {0,3}#{1,6}(?:[ \t]|$)`)
+	// tableSeparator matches the line under a table's header row.
+	tableSeparator = regexp.MustCompile(`^[ \t]*\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+)
+
+// diffSource returns the markdown to compare the file against: the other file
+// if two were given, otherwise the file as of the last git commit. It also
+// returns a label for it.
+func diffSource(args []string) ([]byte, string, error) {
+	if len(args) == 2 {
+		data, err := ioutil.ReadFile(args[0])
+		return data, args[0], err
+	}
+	fileName := args[0]
+	cmd := exec.Command("git", "show", "HEAD:./"+filepath.Base(fileName))
+	cmd.Dir = filepath.Dir(fileName)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	data, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, "", errors.New(msg)
+		}
+		return nil, "", err
+	}
+	return data, "HEAD:" + fileName, nil
+}
+
+// diffToText renders a word diff of the old and new markdown: inserted text
+// in green, deleted text in red strikethrough and changed table cells
+// highlighted. Unchanged blocks more than context blocks away from a change
+// are collapsed, other than headers, unless context is negative.
+func diffToText(old, new []byte, opt *blackfridaytext.Options, context int) []byte {
+	merged := diffMarkdown(old, new, context)
+	return diffStyles(blackfridaytext.MarkdownToTextNoMetadata(merged, opt))
+}
+
+type blockKind int
+
+const (
+	blockText blockKind = iota
+	blockHeader
+	blockFence
+	blockTable
+)
+
+type diffBlock struct {
+	text string
+	kind blockKind
+}
+
+// diffItem is a block of the merged markdown.
+type diffItem struct {
+	text    string
+	changed bool
+	header  bool
+}
+
+// diffMarkdown returns markdown merging the old and new markdown, with the
+// changes marked.
+func diffMarkdown(old, new []byte, context int) []byte {
+	a, b := splitBlocks(old), splitBlocks(new)
+	ops := diffOps(len(a), len(b), func(i, j int) bool { return a[i].text == b[j].text })
+	var items []diffItem
+	for k := 0; k < len(ops); {
+		if ops[k].kind == '=' {
+			block := b[ops[k].b]
+			items = append(items, diffItem{text: block.text, header: block.kind == blockHeader})
+			k++
+			continue
+		}
+		var deleted, inserted []diffBlock
+		for ; k < len(ops) && ops[k].kind != '='; k++ {
+			if ops[k].kind == '-' {
+				deleted = append(deleted, a[ops[k].a])
+			} else {
+				inserted = append(inserted, b[ops[k].b])
+			}
+		}
+		// Changed blocks are paired up in order where they're similar enough
+		// for a word diff to be useful.
+		for len(deleted) > 0 || len(inserted) > 0 {
+			if len(deleted) > 0 && len(inserted) > 0 {
+				if text, ok := diffBlocks(deleted[0], inserted[0]); ok {
+					items = append(items, diffItem{text: text, changed: true})
+					deleted, inserted = deleted[1:], inserted[1:]
+					continue
+				}
+			}
+			if len(deleted) > 0 {
+				items = append(items, diffItem{text: markBlock(deleted[0], markDelete), changed: true})
+				deleted = deleted[1:]
+			} else {
+				items = append(items, diffItem{text: markBlock(inserted[0], markInsert), changed: true})
+				inserted = inserted[1:]
+			}
+		}
+	}
+
+	show := make([]bool, len(items))
+	for i, item := range items {
+		switch {
+		case context < 0 || item.changed || item.header:
+			show[i] = true
+		default:
+			for j := i - context; j <= i+context; j++ {
+				if j >= 0 && j < len(items) && items[j].changed {
+					show[i] = true
+					break
+				}
+			}
+		}
+	}
+	var out bytes.Buffer
+	for i := 0; i < len(items); {
+		if out.Len() > 0 {
+			out.WriteString("\n\n")
+		}
+		if show[i] {
+			out.WriteString(items[i].text)
+			i++
+			continue
+		}
+		n := 0
+		for ; i < len(items) && !show[i]; i++ {
+			n++
+		}
+		s := "s"
+		if n == 1 {
+			s = ""
+		}
+		fmt.Fprintf(&out, "%s⋯ %d unchanged block%s ⋯%s", markCollapsed, n, s, markEnd)
+	}
+	out.WriteString("\n")
+	return out.Bytes()
+}
+
+// splitBlocks splits the markdown into blocks at blank lines outside fenced
+// code, with ATX headers as blocks of their own.
+func splitBlocks(markdown []byte) []diffBlock {
+	var blocks []diffBlock
+	var lines []string
+	flush := func() {
+		if len(lines) == 0 {
+			return
+		}
+		block := diffBlock{text: strings.Join(lines, "\n")}
+		first := strings.TrimLeft(lines[0], " ")
+		switch {
+		case strings.HasPrefix(first, "```") || strings.HasPrefix(first, "~~~"):
+			block.kind = blockFence
+		case atxHeader.MatchString(lines[0]):
+			block.kind = blockHeader
+		case len(lines) > 1 && strings.Contains(lines[0], "|") && tableSeparator.MatchString(lines[1]):
+			block.kind = blockTable
+		}
+		blocks = append(blocks, block)
+		lines = nil
+	}
+	var fence string
+	for _, line := range strings.Split(strings.Replace(string(markdown), "\r\n", "\n", -1), "\n") {
+		line = strings.TrimRight(line, " \t")
+		trimmed := strings.TrimLeft(line, " ")
+		switch {
+		case fence != "":
+			lines = append(lines, line)
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+				flush()
+			}
+			continue
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			flush()
+			fence = trimmed[:3]
+			lines = append(lines, line)
+			continue
+		case line == "":
+			flush()
+			continue
+		case atxHeader.MatchString(line):
+			flush()
+			lines = append(lines, line)
+			flush()
+			continue
+		}
+		lines = append(lines, line)
+	}
+	flush()
+	return blocks
+}
+
+type diffOp struct {
+	kind byte
+	a, b int
+}
+
+// diffOps returns the edit script turning a sequence of n elements into one
+// of m elements, using equal to compare them: '=' for an element kept, '-' for
+// one deleted from the first and '+' for one inserted from the second.
+func diffOps(n, m int, equal func(i, j int) bool) []diffOp {
+	var ops []diffOp
+	start := 0
+	for start < n && start < m && equal(start, start) {
+		ops = append(ops, diffOp{'=', start, start})
+		start++
+	}
+	endA, endB := n, m
+	for endA > start && endB > start && equal(endA-1, endB-1) {
+		endA--
+		endB--
+	}
+	rows, cols := endA-start, endB-start
+	if rows*cols > maxDiffCells {
+		for i := start; i < endA; i++ {
+			ops = append(ops, diffOp{'-', i, -1})
+		}
+		for j := start; j < endB; j++ {
+			ops = append(ops, diffOp{'+', -1, j})
+		}
+	} else {
+		// lengths[i][j] is the length of the longest common subsequence of
+		// the elements from i and j on.
+		lengths := make([]int32, (rows+1)*(cols+1))
+		at := func(i, j int) int32 { return lengths[i*(cols+1)+j] }
+		for i := rows - 1; i >= 0; i-- {
+			for j := cols - 1; j >= 0; j-- {
+				switch {
+				case equal(start+i, start+j):
+					lengths[i*(cols+1)+j] = at(i+1, j+1) + 1
+				case at(i+1, j) >= at(i, j+1):
+					lengths[i*(cols+1)+j] = at(i+1, j)
+				default:
+					lengths[i*(cols+1)+j] = at(i, j+1)
+				}
+			}
+		}
+		for i, j := 0, 0; i < rows || j < cols; {
+			switch {
+			case i < rows && j < cols && equal(start+i, start+j):
+				ops = append(ops, diffOp{'=', start + i, start + j})
+				i++
+				j++
+			case i < rows && (j == cols || at(i+1, j) >= at(i, j+1)):
+				ops = append(ops, diffOp{'-', start + i, -1})
+				i++
+			default:
+				ops = append(ops, diffOp{'+', -1, start + j})
+				j++
+			}
+		}
+	}
+	for k := 0; k < n-endA; k++ {
+		ops = append(ops, diffOp{'=', endA + k, endB + k})
+	}
+	return ops
+}
+
+// diffBlocks returns the word diff of two versions of a block, or false if
+// they're too different for one to be useful.
+func diffBlocks(a, b diffBlock) (string, bool) {
+	if a.kind != b.kind {
+		return "", false
+	}
+	switch a.kind {
+	case blockTable:
+		return diffTable(a.text, b.text)
+	case blockFence:
+		return diffCode(a.text, b.text), true
+	}
+	text, same, words := diffWords(a.text, b.text)
+	if same*10 < words*3 {
+		return "", false
+	}
+	return text, true
+}
+
+// token is a word ('w'), a run of spaces ('s'), a line break ('n') or markup
+// at the start of a line ('p').
+type token struct {
+	text string
+	kind byte
+}
+
+func tokenize(text string) []token {
+	var tokens []token
+	for l, line := range strings.Split(text, "\n") {
+		if l > 0 {
+			tokens = append(tokens, token{"\n", 'n'})
+		}
+		if layoutLine.MatchString(line) {
+			tokens = append(tokens, token{line, 'p'})
+			continue
+		}
+		if prefix := linePrefix.FindString(line); prefix != "" {
+			tokens = append(tokens, token{prefix, 'p'})
+			line = line[len(prefix):]
+		}
+		for len(line) > 0 {
+			kind := byte('w')
+			n := wordLength(line)
+			if n == 0 {
+				kind = 's'
+				n = len(line) - len(strings.TrimLeft(line, " \t"))
+			}
+			tokens = append(tokens, token{line[:n], kind})
+			line = line[n:]
+		}
+	}
+	return tokens
+}
+
+// wordLength returns the length of the word at the start of the text. Code
+// spans, links and emphasis count as one word, spaces and all, so the marks
+// of a word diff never split them.
+func wordLength(text string) int {
+	i := 0
+	for i < len(text) && text[i] != ' ' && text[i] != '\t' {
+		c := text[i]
+		switch {
+		case c == '`' || ((c == '*' || c == '_' || c == '~') && (i == 0 || strings.IndexByte("([\"'", text[i-1]) != -1)):
+			n := 1
+			for i+n < len(text) && text[i+n] == c {
+				n++
+			}
+			delimiter := text[i : i+n]
+			i += n
+			for j := i; j < len(text); {
+				k := strings.Index(text[j:], delimiter)
+				if k == -1 {
+					break
+				}
+				j += k
+				if c == '`' || (text[j-1] != ' ' && text[j-1] != '\t') {
+					i = j + n
+					break
+				}
+				j += n
+			}
+		case c == '[':
+			depth := 0
+			j := i
+			for ; j < len(text); j++ {
+				if text[j] == '[' {
+					depth++
+				} else if text[j] == ']' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			if j == len(text) {
+				i++
+				continue
+			}
+			i = j + 1
+			if i < len(text) && (text[i] == '(' || text[i] == '[') {
+				closing := byte(')')
+				if text[i] == '[' {
+					closing = ']'
+				}
+				if k := strings.IndexByte(text[i:], closing); k != -1 {
+					i += k + 1
+				}
+			}
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+// diffCode returns the merged fenced code block, with deleted and inserted
+// lines marked.
+func diffCode(a, b string) string {
+	bodyA, bodyB := codeBody(a), codeBody(b)
+	linesB := strings.Split(b, "\n")
+	out := []string{linesB[0]}
+	ops := diffOps(len(bodyA), len(bodyB), func(i, j int) bool { return bodyA[i] == bodyB[j] })
+	for _, op := range ops {
+		switch op.kind {
+		case '=':
+			out = append(out, bodyB[op.b])
+		case '-':
+			out = append(out, markLine(bodyA[op.a], markDelete))
+		case '+':
+			out = append(out, markLine(bodyB[op.b], markInsert))
+		}
+	}
+	if len(linesB) > 1 && len(bodyB) == len(linesB)-2 {
+		out = append(out, linesB[len(linesB)-1])
+	}
+	return strings.Join(out, "\n")
+}
+
+// codeBody returns the lines of a fenced code block between its fences.
+func codeBody(code string) []string {
+	lines := strings.Split(code, "\n")[1:]
+	if n := len(lines); n > 0 && layoutLine.MatchString(lines[n-1]) {
+		lines = lines[:n-1]
+	}
+	return lines
+}
+
+// markLine returns the line of code marked after its indent.
+func markLine(line string, mark string) string {
+	text := strings.TrimLeft(line, " \t")
+	if text == "" {
+		return line
+	}
+	return line[:len(line)-len(text)] + mark + text + markEnd
+}
+
+// diffWords returns the merged text of the two versions with the changed
+// words marked, along with how many words were kept and the word count of
+// the longer version.
+func diffWords(a, b string) (string, int, int) {
+	ta, tb := tokenize(a), tokenize(b)
+	ops := diffOps(len(ta), len(tb), func(i, j int) bool { return ta[i] == tb[j] })
+	var w diffWriter
+	same, wordsA, wordsB := 0, 0, 0
+	for k := 0; k < len(ops); {
+		if ops[k].kind == '=' {
+			t := tb[ops[k].b]
+			if t.kind == 'w' {
+				same++
+				wordsA++
+				wordsB++
+			}
+			w.write(t, "")
+			k++
+			continue
+		}
+		var deleted, inserted []token
+		multiline := false
+		for ; k < len(ops) && ops[k].kind != '='; k++ {
+			var t token
+			if ops[k].kind == '-' {
+				t = ta[ops[k].a]
+				deleted = append(deleted, t)
+				if t.kind == 'w' {
+					wordsA++
+				}
+			} else {
+				t = tb[ops[k].b]
+				inserted = append(inserted, t)
+				if t.kind == 'w' {
+					wordsB++
+				}
+			}
+			if t.kind == 'n' {
+				multiline = true
+			}
+		}
+		// Within a line, replaced markup such as "- " becoming "1. " only
+		// keeps the new version.
+		skipMarkup := false
+		if !multiline {
+			for _, t := range inserted {
+				if t.kind == 'p' {
+					skipMarkup = true
+				}
+			}
+		}
+		for _, t := range deleted {
+			if !skipMarkup || t.kind != 'p' {
+				w.write(t, markDelete)
+			}
+		}
+		if len(deleted) > 0 && len(inserted) > 0 {
+			w.write(token{" ", 's'}, "")
+		}
+		for _, t := range inserted {
+			w.write(t, markInsert)
+		}
+	}
+	w.write(token{}, "")
+	words := wordsA
+	if wordsB > words {
+		words = wordsB
+	}
+	return w.out.String(), same, words
+}
+
+// diffWriter writes tokens, marking the words of inserted and deleted ones.
+type diffWriter struct {
+	out bytes.Buffer
+	// mark is the mark of the open run of inserted or deleted words, if any,
+	// and start where its text starts.
+	mark  string
+	start int
+}
+
+func (w *diffWriter) write(t token, mark string) {
+	switch t.kind {
+	case 's':
+		w.out.WriteString(t.text)
+		return
+	case 'w':
+		if mark == w.mark {
+			w.out.WriteString(t.text)
+			return
+		}
+	}
+	if w.mark != "" {
+		// The end mark goes before any closing emphasis, strikethrough or code
+		// span, which Blackfriday wouldn't recognize if followed by it.
+		b := w.out.Bytes()
+		end := len(b)
+		for end > w.start && strings.IndexByte("*_~`", b[end-1]) != -1 {
+			end--
+		}
+		tail := string(b[end:])
+		w.out.Truncate(end)
+		w.out.WriteString(markEnd)
+		w.out.WriteString(tail)
+		w.mark = ""
+	}
+	if t.kind == 'w' && mark != "" {
+		w.out.WriteString(mark)
+		w.mark = mark
+		w.start = w.out.Len()
+	}
+	w.out.WriteString(t.text)
+}
+
+// markBlock returns the block with all its words marked.
+func markBlock(block diffBlock, mark string) string {
+	if block.kind == blockTable {
+		lines := strings.Split(block.text, "\n")
+		out := []string{tableRow(markCells(parseRow(lines[0]), mark)), lines[1]}
+		for _, line := range lines[2:] {
+			out = append(out, tableRow(markCells(parseRow(line), mark)))
+		}
+		return strings.Join(out, "\n")
+	}
+	var w diffWriter
+	for _, t := range tokenize(block.text) {
+		w.write(t, mark)
+	}
+	w.write(token{}, "")
+	return w.out.String()
+}
+
+func markCells(cells []string, mark string) []string {
+	marked := make([]string, len(cells))
+	for i, cell := range cells {
+		marked[i] = markBlock(diffBlock{text: cell}, mark)
+	}
+	return marked
+}
+
+// diffTable returns the merged table, with deleted and inserted rows marked
+// and changed cells highlighted with a word diff of their text, or false if
+// the tables have different columns.
+func diffTable(a, b string) (string, bool) {
+	linesA, linesB := strings.Split(a, "\n"), strings.Split(b, "\n")
+	headerA, headerB := parseRow(linesA[0]), parseRow(linesB[0])
+	if len(headerA) != len(headerB) {
+		return "", false
+	}
+	rowsA, rowsB := [][]string{headerA}, [][]string{headerB}
+	for _, line := range linesA[2:] {
+		rowsA = append(rowsA, parseRow(line))
+	}
+	for _, line := range linesB[2:] {
+		rowsB = append(rowsB, parseRow(line))
+	}
+	out := []string{tableRow(diffCells(headerA, headerB)), linesB[1]}
+	ops := diffOps(len(rowsA)-1, len(rowsB)-1, func(i, j int) bool {
+		return strings.Join(rowsA[i+1], "|") == strings.Join(rowsB[j+1], "|")
+	})
+	for k := 0; k < len(ops); {
+		if ops[k].kind == '=' {
+			out = append(out, tableRow(rowsB[ops[k].b+1]))
+			k++
+			continue
+		}
+		var deleted, inserted [][]string
+		for ; k < len(ops) && ops[k].kind != '='; k++ {
+			if ops[k].kind == '-' {
+				deleted = append(deleted, rowsA[ops[k].a+1])
+			} else {
+				inserted = append(inserted, rowsB[ops[k].b+1])
+			}
+		}
+		for len(deleted) > 0 && len(inserted) > 0 {
+			out = append(out, tableRow(diffCells(deleted[0], inserted[0])))
+			deleted, inserted = deleted[1:], inserted[1:]
+		}
+		for _, row := range deleted {
+			out = append(out, tableRow(markCells(row, markDelete)))
+		}
+		for _, row := range inserted {
+			out = append(out, tableRow(markCells(row, markInsert)))
+		}
+	}
+	return strings.Join(out, "\n"), true
+}
+
+// diffCells returns the cells of the new row, with those that changed from
+// the old row highlighted and word diffed.
+func diffCells(a, b []string) []string {
+	cells := make([]string, len(b))
+	for i := range b {
+		if i < len(a) && a[i] == b[i] {
+			cells[i] = b[i]
+			continue
+		}
+		old := ""
+		if i < len(a) {
+			old = a[i]
+		}
+		text, _, _ := diffWords(old, b[i])
+		cells[i] = markCell + text + markCellEnd
+	}
+	return cells
+}
+
+// parseRow returns the trimmed cells of a table row.
+func parseRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, "\\|") {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '|':
+			cells = append(cells, strings.TrimSpace(line[start:i]))
+			start = i + 1
+		}
+	}
+	return append(cells, strings.TrimSpace(line[start:]))
+}
+
+func tableRow(cells []string) string {
+	return "| " + strings.Join(cells, " | ") + " |"
+}
+
+// diffStyles replaces the diff marks in the rendered text with ANSI Escape
+// Codes, restoring the diff styles after any resets within their spans.
+func diffStyles(text []byte) []byte {
+	var out bytes.Buffer
+	var style, cell string
+	for {
+		i := bytes.IndexByte(text, '\x1b')
+		if i == -1 {
+			out.Write(text)
+			return out.Bytes()
+		}
+		j := bytes.IndexByte(text[i:], 'm')
+		if j == -1 {
+			out.Write(text)
+			return out.Bytes()
+		}
+		out.Write(text[:i])
+		esc := string(text[i : i+j+1])
+		text = text[i+j+1:]
+		switch esc {
+		case markInsert:
+			style = styleInsert
+			out.WriteString(style)
+		case markDelete:
+			style = styleDelete
+			out.WriteString(style)
+		case markCollapsed:
+			style = styleCollapsed
+			out.WriteString(style)
+		case markEnd:
+			style = ""
+			out.WriteString(styleEnd)
+		case markCell:
+			cell = styleCell
+			out.WriteString(cell)
+		case markCellEnd:
+			cell = ""
+			out.WriteString(styleCellEnd)
+		default:
+			out.WriteString(esc)
+			if esc == string(brimtext.ANSIEscape.Reset) {
+				out.WriteString(cell)
+				out.WriteString(style)
+			}
+		}
+	}
+}
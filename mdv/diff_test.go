@@ -0,0 +1,123 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// showMarks returns the text with the diff marks made readable: {+inserted},
+// {-deleted}, [changed cell] and {~collapsed}.
+func showMarks(text string) string {
+	return strings.NewReplacer(markInsert, "{+", markDelete, "{-", markEnd, "}", markCell, "[", markCellEnd, "]", markCollapsed, "{~").Replace(text)
+}
+
+func TestDiffOps(t *testing.T) {
+	a, b := "abcxdef", "abydefg"
+	var got []string
+	for _, op := range diffOps(len(a), len(b), func(i, j int) bool { return a[i] == b[j] }) {
+		switch op.kind {
+		case '=', '-':
+			got = append(got, string(op.kind)+a[op.a:op.a+1])
+		case '+':
+			got = append(got, string(op.kind)+b[op.b:op.b+1])
+		}
+	}
+	want := []string{"=a", "=b", "-c", "-x", "+y", "=d", "=e", "=f", "+g"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffOps = %v, want %v", got, want)
+	}
+}
+
+func TestSplitBlocks(t *testing.T) {
+	markdown := "# H\ntext\nmore\n\n```\ncode\n\nstill\n```\n| a | b |\n|---|---|\n| 1 | 2 |\n"
+	want := []diffBlock{
+		{"# H", blockHeader},
+		{"text\nmore", blockText},
+		{"```\ncode\n\nstill\n```", blockFence},
+		{"| a | b |\n|---|---|\n| 1 | 2 |", blockTable},
+	}
+	if got := splitBlocks([]byte(markdown)); !reflect.DeepEqual(got, want) {
+		t.Errorf("splitBlocks:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestDiffWords(t *testing.T) {
+	for _, test := range []struct {
+		a, b        string
+		want        string
+		same, words int
+	}{
+		{"The quick brown fox.", "The slow brown fox jumps.", "The {-quick }{+slow }brown {-fox. }{+fox jumps.}", 2, 5},
+		// Code spans and links are single words, so never split by marks.
+		{"Some `code span` and *emphasis here*.", "Some `other code` and *emphasis there*.", "Some {-`code span` }{+`other code` }and {-*emphasis here*. }{+*emphasis there*.}", 2, 4},
+		{"See [the docs](a.md) now", "See [the guide](b.md) now", "See {-[the docs](a.md) }{+[the guide](b.md) }now", 2, 3},
+		// Changed list markers keep just the new one.
+		{"- one\n- two", "1. one\n2. two", " 1. one\n 2. two", 2, 2},
+	} {
+		got, same, words := diffWords(test.a, test.b)
+		if showMarks(got) != test.want || same != test.same || words != test.words {
+			t.Errorf("diffWords(%q, %q) = %q, %d, %d, want %q, %d, %d", test.a, test.b, showMarks(got), same, words, test.want, test.same, test.words)
+		}
+	}
+}
+
+func TestWordLength(t *testing.T) {
+	for text, want := range map[string]int{
+		"word rest":          4,
+		"[a link](x y) rest": 13,
+		"`a b` c":            5,
+		"*a b* c":            5,
+		"[unclosed rest":     9,
+	} {
+		if got := wordLength(text); got != want {
+			t.Errorf("wordLength(%q) = %d, want %d", text, got, want)
+		}
+	}
+}
+
+func TestDiffCode(t *testing.T) {
+	got := diffCode("```go\na\nb\nc\n```", "```go\na\nB\nc\nd\n```")
+	if want := "```go\na\n{-b}\n{+B}\nc\n{+d}\n```"; showMarks(got) != want {
+		t.Errorf("diffCode = %q, want %q", showMarks(got), want)
+	}
+}
+
+func TestDiffTable(t *testing.T) {
+	got, ok := diffTable("| a | b |\n|---|---|\n| 1 | 2 |\n| 3 | 4 |", "| a | b |\n|---|---|\n| 1 | 5 |\n| 3 | 4 |\n| 6 | 7 |")
+	if want := "| a | b |\n|---|---|\n| 1 | [{-2 }{+5}] |\n| 3 | 4 |\n| {+6} | {+7} |"; !ok || showMarks(got) != want {
+		t.Errorf("diffTable = %q, %v, want %q", showMarks(got), ok, want)
+	}
+	if _, ok := diffTable("| a | b |\n|---|---|", "| a |\n|---|"); ok {
+		t.Errorf("diffTable of different columns ok")
+	}
+	if got, want := parseRow("| a | b\\|c |  d  |"), []string{"a", "b\\|c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRow = %q, want %q", got, want)
+	}
+}
+
+func TestDiffMarkdown(t *testing.T) {
+	old := "# Title\n\nOne.\n\nTwo.\n\nThree.\n\nFour.\n\n## Sub\n\nFive.\n"
+	new := "# Title\n\nOne.\n\nTwo.\n\nThree changed.\n\nFour.\n\n## Sub\n\nFive.\n"
+	for _, test := range []struct {
+		context int
+		want    string
+	}{
+		{0, "# Title\n\n{~⋯ 2 unchanged blocks ⋯}\n\n{-Three.}\n\n{+Three changed.}\n\n{~⋯ 1 unchanged block ⋯}\n\n## Sub\n\n{~⋯ 1 unchanged block ⋯}\n"},
+		{1, "# Title\n\n{~⋯ 1 unchanged block ⋯}\n\nTwo.\n\n{-Three.}\n\n{+Three changed.}\n\nFour.\n\n## Sub\n\n{~⋯ 1 unchanged block ⋯}\n"},
+		{-1, "# Title\n\nOne.\n\nTwo.\n\n{-Three.}\n\n{+Three changed.}\n\nFour.\n\n## Sub\n\nFive.\n"},
+	} {
+		if got := showMarks(string(diffMarkdown([]byte(old), []byte(new), test.context))); got != test.want {
+			t.Errorf("context %d:\n got %q\nwant %q", test.context, got, test.want)
+		}
+	}
+}
+
+func TestDiffStyles(t *testing.T) {
+	// Styles are restored after resets within their spans.
+	got := string(diffStyles([]byte("a " + markInsert + "b \x1b[0m c" + markEnd + " " + markCell + "x" + markCellEnd)))
+	want := "a " + styleInsert + "b \x1b[0m" + styleInsert + " c" + styleEnd + " " + styleCell + "x" + styleCellEnd
+	if got != want {
+		t.Errorf("diffStyles = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/rn/utils/mdv/blackfridaytext"
+)
+
+// autoEmojiMode returns Unicode emoji unless the locale isn't UTF-8 or the
+// terminal is the Linux console, which has no glyphs for them, in which case
+// ASCII approximations are used.
+func autoEmojiMode() blackfridaytext.EmojiMode {
+	if os.Getenv("TERM") == "linux" {
+		return blackfridaytext.EmojiASCII
+	}
+	locale := os.Getenv("LC_ALL")
+	if locale == "" {
+		locale = os.Getenv("LC_CTYPE")
+	}
+	if locale == "" {
+		locale = os.Getenv("LANG")
+	}
+	locale = strings.ToLower(locale)
+	if strings.Contains(locale, "utf-8") || strings.Contains(locale, "utf8") {
+		return blackfridaytext.EmojiUnicode
+	}
+	return blackfridaytext.EmojiASCII
+}
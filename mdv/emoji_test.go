@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/rn/utils/mdv/blackfridaytext"
+)
+
+func TestAutoEmojiMode(t *testing.T) {
+	for _, name := range []string{"TERM", "LC_ALL", "LC_CTYPE", "LANG"} {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+	for _, test := range []struct {
+		term, all, ctype, lang string
+		want                   blackfridaytext.EmojiMode
+	}{
+		{"xterm", "", "", "en_US.UTF-8", blackfridaytext.EmojiUnicode},
+		{"xterm", "", "C.utf8", "", blackfridaytext.EmojiUnicode},
+		{"xterm", "C", "", "en_US.UTF-8", blackfridaytext.EmojiASCII},
+		{"xterm", "", "", "", blackfridaytext.EmojiASCII},
+		{"linux", "", "", "en_US.UTF-8", blackfridaytext.EmojiASCII},
+	} {
+		os.Setenv("TERM", test.term)
+		os.Setenv("LC_ALL", test.all)
+		os.Setenv("LC_CTYPE", test.ctype)
+		os.Setenv("LANG", test.lang)
+		if got := autoEmojiMode(); got != test.want {
+			t.Errorf("autoEmojiMode with %+v = %d", test, got)
+		}
+	}
+}
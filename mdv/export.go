@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/rn/utils/mdv/brimtext"
+)
+
+// export writes the ANSI text to fileName, as HTML or SVG depending on the
+// file extension.
+func export(fileName string, text string, opts *brimtext.ANSIExportOptions) error {
+	var doc string
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".html", ".htm":
+		doc = brimtext.ANSIToHTMLDocument(text, opts)
+	case ".svg":
+		doc = brimtext.ANSIToSVG(text, opts)
+	default:
+		return fmt.Errorf("unknown export format %q, use .html or .svg", filepath.Ext(fileName))
+	}
+	return ioutil.WriteFile(fileName, []byte(doc), 0644)
+}
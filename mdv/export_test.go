@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mdv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, prefix := range map[string]string{
+		"out.html": "<!DOCTYPE html>",
+		"out.HTM":  "<!DOCTYPE html>",
+		"out.svg":  "<svg ",
+	} {
+		name = filepath.Join(dir, name)
+		if err := export(name, "\x1b[1mhi\x1b[0m", nil); err != nil {
+			t.Errorf("export %s: %v", name, err)
+			continue
+		}
+		if b, _ := ioutil.ReadFile(name); !strings.HasPrefix(string(b), prefix) {
+			t.Errorf("export %s wrote %.20q, want %q...", name, b, prefix)
+		}
+	}
+	if err := export(filepath.Join(dir, "out.txt"), "hi", nil); err == nil {
+		t.Errorf("export to .txt succeeded")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rn/utils/mdv/blackfridaytext"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// autoImageMode returns the kitty graphics protocol or sixel image mode if
+// graphics is true and the terminal supports them, otherwise Unicode half
+// blocks.
+func autoImageMode(graphics bool) blackfridaytext.ImageMode {
+	if !graphics || !terminal.IsTerminal(int(os.Stdout.Fd())) {
+		return blackfridaytext.ImagesBlocks
+	}
+	if kittyTerminal() {
+		return blackfridaytext.ImagesKitty
+	}
+	if sixelTerminal() {
+		return blackfridaytext.ImagesSixel
+	}
+	return blackfridaytext.ImagesBlocks
+}
+
+// kittyTerminal reports whether the environment indicates a terminal known to
+// support the kitty graphics protocol.
+func kittyTerminal() bool {
+	if os.Getenv("KITTY_WINDOW_ID") != "" {
+		return true
+	}
+	switch os.Getenv("TERM") {
+	case "xterm-kitty", "xterm-ghostty":
+		return true
+	}
+	return os.Getenv("TERM_PROGRAM") == "WezTerm"
+}
+
+// sixelTerminal asks the terminal for its Primary Device Attributes and
+// reports whether they include sixel graphics (4).
+func sixelTerminal() bool {
+	if term := os.Getenv("TERM"); term == "" || term == "dumb" {
+		return false
+	}
+	inFd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(inFd) {
+		return false
+	}
+	state, err := terminal.MakeRaw(inFd)
+	if err != nil {
+		return false
+	}
+	defer terminal.Restore(inFd, state)
+	reply := make(chan string, 1)
+	go func() {
+		var response []byte
+		b := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(b); err != nil {
+				break
+			}
+			response = append(response, b[0])
+			if b[0] == 'c' {
+				break
+			}
+		}
+		reply <- string(response)
+	}()
+	os.Stdout.WriteString("\x1b[c")
+	select {
+	case response := <-reply:
+		response = strings.TrimSuffix(strings.TrimPrefix(response, "\x1b[?"), "c")
+		for _, attr := range strings.Split(response, ";") {
+			if attr == "4" {
+				return true
+			}
+		}
+	case <-time.After(200 * time.Millisecond):
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestKittyTerminal(t *testing.T) {
+	saved := map[string]string{}
+	for _, name := range []string{"KITTY_WINDOW_ID", "TERM", "TERM_PROGRAM"} {
+		saved[name] = os.Getenv(name)
+		defer os.Setenv(name, saved[name])
+	}
+	for _, test := range []struct {
+		window, term, program string
+		want                  bool
+	}{
+		{"1", "xterm-256color", "", true},
+		{"", "xterm-kitty", "", true},
+		{"", "xterm-ghostty", "", true},
+		{"", "xterm-256color", "WezTerm", true},
+		{"", "xterm-256color", "iTerm.app", false},
+	} {
+		os.Setenv("KITTY_WINDOW_ID", test.window)
+		os.Setenv("TERM", test.term)
+		os.Setenv("TERM_PROGRAM", test.program)
+		if got := kittyTerminal(); got != test.want {
+			t.Errorf("kittyTerminal with %+v = %v", test, got)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rn/utils/mdv/blackfridaytext"
+	"github.com/rn/utils/mdv/brimtext"
+)
+
+// readmeNames are tried, in order, when a link points at a directory.
+var readmeNames = []string{"README.md", "readme.md", "Readme.md", "index.md"}
+
+// page is a rendered document shown by the interactive viewer.
+type page struct {
+	fileName string
+	// section, if not empty, is the only section of the file shown.
+	section  string
+	lines    []string
+	links    []blackfridaytext.TextLink
+	headers  []blackfridaytext.TextHeader
+	top      int
+	selected int
+}
+
+// viewer is the interactive mode: a pager which can follow links between
+// markdown files.
+type viewer struct {
+	scr     *screen
+	opt     *blackfridaytext.Options
+	current *page
+	history []*page
+	status  string
+}
+
+// interactive shows fileName, or just its section if section isn't empty, in
+// a pager until the user quits. Tab and Shift-Tab select links, Enter follows
+// them and Backspace goes back.
+func interactive(fileName, section string, opt *blackfridaytext.Options) error {
+	scr, err := openScreen()
+	if err != nil {
+		return err
+	}
+	defer scr.close()
+	v := &viewer{scr: scr, opt: opt}
+	if v.current, err = v.load(fileName, section); err != nil {
+		return err
+	}
+	for {
+		v.draw()
+		select {
+		case <-scr.resize:
+			scr.width, scr.height = scr.size()
+			v.rerender()
+		case k, ok := <-scr.keys:
+			if !ok {
+				return nil
+			}
+			v.status = ""
+			if !v.handle(k) {
+				return nil
+			}
+		}
+	}
+}
+
+// handle acts on a key press and returns false if the viewer should quit.
+func (v *viewer) handle(k key) bool {
+	p := v.current
+	rows := v.rows()
+	switch k {
+	case 'q', keyCtrlC:
+		return false
+	case keyDown, 'j':
+		v.scroll(1)
+	case keyUp, 'k':
+		v.scroll(-1)
+	case keyPageDown, ' ':
+		v.scroll(rows)
+	case keyPageUp, 'b':
+		v.scroll(-rows)
+	case keyHome, 'g':
+		p.top = 0
+	case keyEnd, 'G':
+		v.scroll(len(p.lines))
+	case keyTab:
+		v.selectLink(1)
+	case keyBackTab:
+		v.selectLink(-1)
+	case keyEnter:
+		if p.selected >= 0 && p.selected < len(p.links) {
+			v.follow(p.links[p.selected].Target)
+		}
+	case keyBackspace, 0x08:
+		if len(v.history) == 0 {
+			v.status = "No previous document"
+			break
+		}
+		v.current = v.history[len(v.history)-1]
+		v.history = v.history[:len(v.history)-1]
+		v.rerender()
+	}
+	return true
+}
+
+// load reads and renders the markdown file, or just its section if section
+// isn't empty, for the current screen width.
+func (v *viewer) load(fileName, section string) (*page, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	_, position := blackfridaytext.MarkdownMetadata(data)
+	data = data[position:]
+	if section != "" {
+		if data = blackfridaytext.MarkdownSection(data, section); data == nil {
+			return nil, fmt.Errorf("no section %q in %s", section, fileName)
+		}
+	}
+	opt := *v.opt
+	opt.Width = v.scr.width - 1
+	opt.ImageDir = filepath.Dir(fileName)
+	text, links, headers := blackfridaytext.MarkdownToTextIndexed(data, &opt)
+	return &page{
+		fileName: fileName,
+		section:  section,
+		lines:    strings.Split(strings.TrimRight(string(text), "\n"), "\n"),
+		links:    links,
+		headers:  headers,
+		selected: -1,
+	}, nil
+}
+
+// rerender renders the current page again, such as after a resize, keeping
+// the position as far as possible.
+func (v *viewer) rerender() {
+	old := v.current
+	p, err := v.load(old.fileName, old.section)
+	if err != nil {
+		v.status = err.Error()
+		return
+	}
+	if len(old.lines) > 0 {
+		p.top = old.top * len(p.lines) / len(old.lines)
+	}
+	if old.selected < len(p.links) {
+		p.selected = old.selected
+	}
+	v.current = p
+}
+
+func (v *viewer) rows() int {
+	return v.scr.height - 1
+}
+
+func (v *viewer) scroll(n int) {
+	p := v.current
+	p.top += n
+	if max := len(p.lines) - v.rows(); p.top > max {
+		p.top = max
+	}
+	if p.top < 0 {
+		p.top = 0
+	}
+}
+
+// selectLink moves the selection to the next (dir 1) or previous (dir -1)
+// link, scrolling it into view.
+func (v *viewer) selectLink(dir int) {
+	p := v.current
+	if len(p.links) == 0 {
+		v.status = "No links"
+		return
+	}
+	p.selected += dir
+	if p.selected >= len(p.links) {
+		p.selected = 0
+	} else if p.selected < 0 {
+		p.selected = len(p.links) - 1
+	}
+	line := p.links[p.selected].Line
+	if line < p.top || line >= p.top+v.rows() {
+		p.top = line - v.rows()/2
+		v.scroll(0)
+	}
+}
+
+// follow opens the link target: an anchor in the current document, a
+// markdown file, or a directory with a README, relative to the current file.
+func (v *viewer) follow(target string) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		v.status = "Not a local link: " + target
+		return
+	}
+	if u.Path == "" {
+		v.gotoAnchor(u.Fragment)
+		return
+	}
+	fileName := u.Path
+	if !filepath.IsAbs(fileName) {
+		fileName = filepath.Join(filepath.Dir(v.current.fileName), filepath.FromSlash(fileName))
+	}
+	if fi, err := os.Stat(fileName); err == nil && fi.IsDir() {
+		found := false
+		for _, name := range readmeNames {
+			if _, err := os.Stat(filepath.Join(fileName, name)); err == nil {
+				fileName = filepath.Join(fileName, name)
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.status = "No README in " + target
+			return
+		}
+	}
+	if !isMarkdown(fileName) {
+		v.status = "Not a markdown file: " + target
+		return
+	}
+	p, err := v.load(fileName, "")
+	if err != nil {
+		v.status = err.Error()
+		return
+	}
+	v.history = append(v.history, v.current)
+	v.current = p
+	if u.Fragment != "" {
+		v.gotoAnchor(u.Fragment)
+	}
+}
+
+func (v *viewer) gotoAnchor(anchor string) {
+	for _, h := range v.current.headers {
+		if h.ID == anchor && h.Line >= 0 {
+			v.current.top = h.Line
+			v.scroll(0)
+			return
+		}
+	}
+	v.status = "No such anchor: #" + anchor
+}
+
+func (v *viewer) draw() {
+	v.scr.draw(v.frame())
+}
+
+// frame returns the lines of the screen: the page's lines showing, cut to
+// the screen's width, as code blocks and tables aren't wrapped, and then
+// the status line.
+func (v *viewer) frame() []string {
+	p := v.current
+	rows := v.rows()
+	lines := make([]string, 0, rows+1)
+	for i := p.top; i < p.top+rows; i++ {
+		if i >= len(p.lines) {
+			lines = append(lines, "")
+			continue
+		}
+		line := p.lines[i]
+		if p.selected >= 0 && p.selected < len(p.links) {
+			link := p.links[p.selected]
+			if i >= link.Line && i <= link.EndLine {
+				from, to := 0, visibleWidth(line)
+				if i == link.Line {
+					from = link.Column
+				}
+				if i == link.EndLine {
+					to = link.EndColumn
+				}
+				line = highlight(line, from, to)
+			}
+		}
+		lines = append(lines, truncate(line, v.scr.width))
+	}
+	status := v.status
+	if status == "" && p.selected >= 0 && p.selected < len(p.links) {
+		status = "-> " + p.links[p.selected].Target
+	}
+	percent := 100
+	if len(p.lines) > rows {
+		percent = (p.top + rows) * 100 / len(p.lines)
+		if percent > 100 {
+			percent = 100
+		}
+	}
+	left := " " + p.fileName
+	if status != "" {
+		left += "  " + status
+	}
+	return append(lines, statusLine(left, fmt.Sprintf(" %d%% ", percent), v.scr.width))
+}
+
+// statusLine returns the status line for a screen width columns wide, in
+// reverse video, with left cut short if there isn't room for all of it.
+func statusLine(left, right string, width int) string {
+	runes := []rune(left)
+	gap := width - len(runes) - utf8.RuneCountInString(right)
+	if gap < 0 {
+		n := len(runes) + gap
+		if n < 0 {
+			n = 0
+		}
+		left = string(runes[:n])
+		gap = 0
+	}
+	return truncate("\x1b[7m"+left+strings.Repeat(" ", gap)+right+string(brimtext.ANSIEscape.Reset), width)
+}
+
+// highlight shows the display columns from up to to of the line in reverse
+// video, leaving any ANSI Escape Codes intact.
+func highlight(line string, from, to int) string {
+	var out bytes.Buffer
+	column := 0
+	on := false
+	for i := 0; i < len(line); {
+		if column == from && !on && from < to {
+			out.WriteString("\x1b[7m")
+			on = true
+		}
+		if column == to && on {
+			out.WriteString("\x1b[27m")
+			on = false
+		}
+		if line[i] == '\x1b' {
+			j := strings.IndexByte(line[i:], 'm')
+			if j == -1 {
+				out.WriteString(line[i:])
+				break
+			}
+			out.WriteString(line[i : i+j+1])
+			if on && line[i:i+j+1] == string(brimtext.ANSIEscape.Reset) {
+				out.WriteString("\x1b[7m")
+			}
+			i += j + 1
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(line[i:])
+		out.WriteString(line[i : i+size])
+		i += size
+		column++
+	}
+	if on {
+		out.WriteString("\x1b[27m")
+	}
+	return out.String()
+}
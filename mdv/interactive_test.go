@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rn/utils/mdv/blackfridaytext"
+)
+
+func TestStatusLine(t *testing.T) {
+	for _, test := range []struct {
+		left  string
+		width int
+		want  string
+	}{
+		{" doc.md", 20, " doc.md        100% "},
+		{" doc.md", 12, " doc.m 100% "},
+		{" dôc.md  -> ünïcödé.md", 14, " dôc.md  100% "},
+		{" doc.md", 4, " 100"},
+		{" doc.md", 0, ""},
+	} {
+		got := statusLine(test.left, " 100% ", test.width)
+		if got != "\x1b[7m"+test.want+"\x1b[0m" {
+			t.Errorf("statusLine(%q, %d) = %q, want %q", test.left, test.width, got, test.want)
+		}
+		if w := visibleWidth(got); w > test.width {
+			t.Errorf("statusLine(%q, %d) is %d wide", test.left, test.width, w)
+		}
+	}
+}
+
+func TestViewerLoadSection(t *testing.T) {
+	f, err := ioutil.TempFile("", "mdv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("Title: doc\n\n# First\n\none\n\n# Second\n\ntwo\n")
+	f.Close()
+	v := &viewer{scr: &screen{width: 40, height: 10}, opt: &blackfridaytext.Options{}}
+	p, err := v.load(f.Name(), "Second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := strings.Join(p.lines, "\n")
+	if strings.Contains(text, "First") || !strings.Contains(text, "Second") {
+		t.Errorf("section Second:\n%s", text)
+	}
+	if _, err := v.load(f.Name(), "Missing"); err == nil {
+		t.Errorf("loading a missing section succeeded")
+	}
+}
+
+func TestViewerFrameWidth(t *testing.T) {
+	f, err := ioutil.TempFile("", "mdv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	// Code blocks and tables aren't wrapped.
+	f.WriteString("Title: doc\n\n# Wide\n\n```\n" + strings.Repeat("code ", 40) + "\n```\n\n" +
+		"| a | b |\n|---|---|\n| " + strings.Repeat("x", 100) + " | [link](other.md) |\n\nend\n")
+	f.Close()
+	v := &viewer{scr: &screen{width: 40, height: 10}, opt: &blackfridaytext.Options{}}
+	p, err := v.load(f.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.selected = 0
+	v.current = p
+	lines := v.frame()
+	if len(lines) != v.scr.height {
+		t.Errorf("%d lines for a screen %d high", len(lines), v.scr.height)
+	}
+	for _, line := range lines {
+		if w := visibleWidth(line); w > v.scr.width {
+			t.Errorf("line %d wide on a screen %d wide: %q", w, v.scr.width, line)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rn/utils/mdv/blackfridaytext"
+)
+
+// manOptions returns the man page defaults for fileName: the title is taken
+// from the file name ("npterm.1.md" gives "npterm" in section "1", "npterm.md"
+// gives "npterm" in the default section) and the date from its modification
+// time. The markdown metadata can override either.
+func manOptions(fileName string) *blackfridaytext.ManOptions {
+	opts := &blackfridaytext.ManOptions{}
+	name := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	if ext := filepath.Ext(name); len(ext) == 2 && ext[1] >= '1' && ext[1] <= '9' {
+		opts.Section = ext[1:]
+		name = strings.TrimSuffix(name, ext)
+	}
+	opts.Title = name
+	if fi, err := os.Stat(fileName); err == nil {
+		opts.Date = fi.ModTime().Format("2006-01-02")
+	}
+	return opts
+}
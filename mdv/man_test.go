@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mdv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, test := range []struct {
+		file, title, section string
+	}{
+		{"npterm.1.md", "npterm", "1"},
+		{"npterm.md", "npterm", ""},
+		{"foo.conf.5.markdown", "foo.conf", "5"},
+		{"v1.0.md", "v1.0", ""},
+	} {
+		name := filepath.Join(dir, test.file)
+		if err := ioutil.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		opts := manOptions(name)
+		if opts.Title != test.title || opts.Section != test.section || len(opts.Date) != len("2006-01-02") {
+			t.Errorf("manOptions(%q) = %+v, want title %q, section %q and a date", test.file, opts, test.title, test.section)
+		}
+	}
+}
@@ -1,39 +1,255 @@
 package main
 
 import (
+	"bytes"
+	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 
-	"github.com/gholt/blackfridaytext"
+	"github.com/rn/utils/mdv/blackfridaytext"
+	"github.com/rn/utils/mdv/brimtext"
 )
 
 func main() {
-	if len(os.Args) != 2 {
+	tocPtr := flag.Bool("toc", false, "Print the outline of the document instead of rendering it")
+	sectionPtr := flag.String("section", "", "Only render the section with this header (or #anchor) and its subsections")
+	numberPtr := flag.Bool("number", false, "Number headers hierarchically (1, 1.1, 1.1.2)")
+	stylePtr := flag.String("header-style", "plain", "Header style: plain, setext or boxed")
+	noIndentPtr := flag.Bool("no-indent", false, "Do not indent content under headers")
+	widthPtr := flag.Int("width", 0, "Width to render to (default: terminal width)")
+	exportPtr := flag.String("export", "", "Export the rendered output to a .html or .svg file")
+	fontPtr := flag.String("font", "", "Font family for --export (default: monospace)")
+	fontSizePtr := flag.Float64("font-size", 0, "Font size in pixels for --export (default: 14)")
+	var interactiveFlag bool
+	flag.BoolVar(&interactiveFlag, "i", false, "Interactive mode: page through the document and follow links (same as --interactive)")
+	flag.BoolVar(&interactiveFlag, "interactive", false, "Interactive mode: page through the document and follow links")
+	presentPtr := flag.Bool("present", false, "Show the document as slides, split at horizontal rules or level 1 and 2 headers")
+	manPtr := flag.Bool("man", false, "Write the document as a man page (roff) instead of rendering it")
+	imagesPtr := flag.String("images", "auto", "How to show local images: auto (terminal graphics if supported, else blocks), blocks or off")
+	smartPtr := flag.Bool("smart", false, "Use typographic quotes, dashes and ellipses")
+	emojiPtr := flag.String("emoji", "auto", "How to show :shortcode: emoji: auto (unicode if the terminal can show it, else ascii), unicode, ascii or off")
+	diffPtr := flag.Bool("diff", false, "Show the changes from the first file to the second, or from the last git commit of the file, as a rendered word diff")
+	diffContextPtr := flag.Int("diff-context", 1, "How many unchanged blocks to show around each change with --diff; -1 shows them all")
+	checkPtr := flag.Bool("check", false, "Check the links and anchors of the file, or of all markdown files in the directory, instead of rendering it")
+	flag.Parse()
+
+	if *diffPtr {
+		if flag.NArg() != 1 && flag.NArg() != 2 {
+			log.Fatal("Please specify the old and new file names, or *one* file name to compare with git, for --diff")
+		}
+	} else if flag.NArg() != 1 {
 		log.Fatal("Please specify *one* file name to render")
 	}
-	
-	data, err := ioutil.ReadFile(os.Args[1])
-	if err != nil {
-		log.Fatalf("Could not read %s: %v\n", os.Args[1], err)
+	// With two files to --diff, the second is the one rendered.
+	fileName := flag.Arg(flag.NArg() - 1)
+
+	if *diffPtr && (isTable(fileName) || isNotebook(fileName) || interactiveFlag || *presentPtr || *manPtr || *tocPtr || *sectionPtr != "") {
+		log.Fatalf("Only rendering and --export are supported with --diff: %s\n", fileName)
+	}
+
+	if *checkPtr {
+		problems, err := check(fileName, os.Stdout)
+		if err != nil {
+			log.Fatalf("Could not check %s: %v\n", fileName, err)
+		}
+		if problems > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if isTable(fileName) {
+		if interactiveFlag || *presentPtr || *manPtr || *tocPtr || *sectionPtr != "" {
+			log.Fatalf("Only rendering and --export are supported for tables: %s\n", fileName)
+		}
+		if *exportPtr == "" {
+			if err := csvTable(fileName, os.Stdout, *widthPtr, true); err != nil {
+				log.Fatalf("Could not render %s: %v\n", fileName, err)
+			}
+			return
+		}
+		var out bytes.Buffer
+		if err := csvTable(fileName, &out, *widthPtr, false); err != nil {
+			log.Fatalf("Could not render %s: %v\n", fileName, err)
+		}
+		exportOpts := &brimtext.ANSIExportOptions{
+			Title:      filepath.Base(fileName),
+			FontFamily: *fontPtr,
+			FontSize:   *fontSizePtr,
+			Columns:    *widthPtr,
+		}
+		if err := export(*exportPtr, out.String(), exportOpts); err != nil {
+			log.Fatalf("Could not export to %s: %v\n", *exportPtr, err)
+		}
+		return
+	}
+
+	if isNotebook(fileName) && (*manPtr || *sectionPtr != "" || *tocPtr || interactiveFlag || *presentPtr) {
+		log.Fatalf("Only rendering and --export are supported for notebooks: %s\n", fileName)
+	}
+
+	// Plain rendering reads the document as it goes, so large documents are
+	// never held in memory whole; everything else needs all of it.
+	stream := !isNotebook(fileName) && !*manPtr && *sectionPtr == "" && !*tocPtr &&
+		!interactiveFlag && !*presentPtr && !*diffPtr && *exportPtr == ""
+	var data []byte
+	var file *os.File
+	var metadata [][]string
+	var err error
+	if stream {
+		if file, err = os.Open(fileName); err != nil {
+			log.Fatalf("Could not read %s: %v\n", fileName, err)
+		}
+		defer file.Close()
+		if metadata, err = blackfridaytext.ReadMarkdownMetadata(file); err != nil {
+			log.Fatalf("Could not read %s: %v\n", fileName, err)
+		}
+	} else if data, err = ioutil.ReadFile(fileName); err != nil {
+		log.Fatalf("Could not read %s: %v\n", fileName, err)
+	}
+
+	if *manPtr {
+		os.Stdout.Write(blackfridaytext.MarkdownToMan(data, manOptions(fileName)))
+		return
+	}
+
+	if !stream && !isNotebook(fileName) {
+		var position int
+		metadata, position = blackfridaytext.MarkdownMetadata(data)
+		data = data[position:]
+	}
+
+	if *sectionPtr != "" {
+		data = blackfridaytext.MarkdownSection(data, *sectionPtr)
+		if data == nil {
+			log.Fatalf("No section %q in %s\n", *sectionPtr, fileName)
+		}
+		metadata = nil
+	}
+
+	if *tocPtr {
+		printTOC(os.Stdout, blackfridaytext.MarkdownHeaders(data))
+		return
 	}
 
-	// Hard coded configuration
 	opt := &blackfridaytext.Options{
-		Color: true,
-		HeaderPrefix: []byte("-["),
-		HeaderSuffix: []byte("]-"),
+		Color:         true,
+		HeaderPrefix:  []byte("-["),
+		HeaderSuffix:  []byte("]-"),
+		NumberHeaders: *numberPtr,
+		Math:          true,
+		Smartypants:   *smartPtr,
+	}
+	switch *stylePtr {
+	case "plain":
+	case "setext":
+		opt.HeaderStyles = blackfridaytext.NewSetextHeaderStyles()
+	case "boxed":
+		opt.HeaderStyles = blackfridaytext.NewBoxedHeaderStyles(true)
+	default:
+		log.Fatalf("Unknown header style: %s\n", *stylePtr)
+	}
+	if *noIndentPtr {
+		opt.HeaderIndent = []byte{}
+	}
+
+	if *widthPtr != 0 {
+		opt.Width = *widthPtr
+	}
+
+	switch *imagesPtr {
+	case "auto":
+		// Terminal graphics only make sense when writing straight to the
+		// terminal.
+		opt.Images = autoImageMode(!interactiveFlag && !*presentPtr && *exportPtr == "")
+	case "blocks":
+		opt.Images = blackfridaytext.ImagesBlocks
+	case "off":
+	default:
+		log.Fatalf("Unknown image mode: %s\n", *imagesPtr)
+	}
+	opt.ImageDir = filepath.Dir(fileName)
+
+	switch *emojiPtr {
+	case "auto":
+		opt.Emoji = autoEmojiMode()
+	case "unicode":
+		opt.Emoji = blackfridaytext.EmojiUnicode
+	case "ascii":
+		opt.Emoji = blackfridaytext.EmojiASCII
+	case "off":
+	default:
+		log.Fatalf("Unknown emoji mode: %s\n", *emojiPtr)
+	}
+
+	if interactiveFlag {
+		if err := interactive(fileName, *sectionPtr, opt); err != nil {
+			log.Fatalf("Could not view %s: %v\n", fileName, err)
+		}
+		return
+	}
+
+	if *presentPtr {
+		if err := present(filepath.Base(fileName), data, opt); err != nil {
+			log.Fatalf("Could not present %s: %v\n", fileName, err)
+		}
+		return
 	}
 
-	metadata, output := blackfridaytext.MarkdownToText(data, opt)
+	var out bytes.Buffer
 	for _, item := range metadata {
 		name, value := item[0], item[1]
-		os.Stdout.WriteString(name)
-		os.Stdout.WriteString(":\n    ")
-		os.Stdout.WriteString(value)
+		out.WriteString(name)
+		out.WriteString(":\n    ")
+		out.WriteString(value)
+		out.WriteString("\n")
+	}
+	out.WriteString("\n")
+
+	if stream {
+		os.Stdout.Write(out.Bytes())
+		if err := blackfridaytext.Render(file, os.Stdout, opt); err != nil {
+			log.Fatalf("Could not render %s: %v\n", fileName, err)
+		}
 		os.Stdout.WriteString("\n")
+		return
+	}
+
+	var output []byte
+	switch {
+	case *diffPtr:
+		old, label, err := diffSource(flag.Args())
+		if err != nil {
+			log.Fatalf("Could not read the old version of %s: %v\n", fileName, err)
+		}
+		_, position := blackfridaytext.MarkdownMetadata(old)
+		fmt.Fprintf(&out, "%s--- %s%s\n", brimtext.ANSIEscape.FRed, label, brimtext.ANSIEscape.Reset)
+		fmt.Fprintf(&out, "%s+++ %s%s\n\n", brimtext.ANSIEscape.FGreen, fileName, brimtext.ANSIEscape.Reset)
+		output = diffToText(old[position:], data, opt, *diffContextPtr)
+	case isNotebook(fileName):
+		if output, err = notebookToText(data, opt); err != nil {
+			log.Fatalf("Could not parse %s: %v\n", fileName, err)
+		}
+	default:
+		output = blackfridaytext.MarkdownToTextNoMetadata(data, opt)
+	}
+	out.Write(output)
+	out.WriteString("\n")
+
+	if *exportPtr != "" {
+		exportOpts := &brimtext.ANSIExportOptions{
+			Title:      filepath.Base(fileName),
+			FontFamily: *fontPtr,
+			FontSize:   *fontSizePtr,
+			Columns:    opt.Width,
+		}
+		if err := export(*exportPtr, out.String(), exportOpts); err != nil {
+			log.Fatalf("Could not export to %s: %v\n", *exportPtr, err)
+		}
+		return
 	}
-	os.Stdout.WriteString("\n")
-	os.Stdout.Write(output)
-	os.Stdout.WriteString("\n")
+	os.Stdout.Write(out.Bytes())
 }
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rn/utils/mdv/blackfridaytext"
+	"github.com/rn/utils/mdv/brimtext"
+)
+
+// notebook is the part of a Jupyter notebook (nbformat 4) that is rendered.
+type notebook struct {
+	Metadata struct {
+		KernelSpec struct {
+			Language string `json:"language"`
+		} `json:"kernelspec"`
+		LanguageInfo struct {
+			Name string `json:"name"`
+		} `json:"language_info"`
+	} `json:"metadata"`
+	Cells []notebookCell `json:"cells"`
+}
+
+type notebookCell struct {
+	CellType       string           `json:"cell_type"`
+	Source         multiline        `json:"source"`
+	ExecutionCount *int             `json:"execution_count"`
+	Outputs        []notebookOutput `json:"outputs"`
+}
+
+type notebookOutput struct {
+	OutputType     string               `json:"output_type"`
+	Name           string               `json:"name"`
+	Text           multiline            `json:"text"`
+	Data           map[string]multiline `json:"data"`
+	ExecutionCount *int                 `json:"execution_count"`
+	Ename          string               `json:"ename"`
+	Evalue         string               `json:"evalue"`
+	Traceback      []string             `json:"traceback"`
+}
+
+// multiline is notebook text, which may be stored as a string or as a list of
+// lines.
+type multiline string
+
+func (m *multiline) UnmarshalJSON(data []byte) error {
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err == nil {
+		*m = multiline(strings.Join(lines, ""))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		// Some outputs, such as application/json, aren't text at all.
+		*m = multiline(data)
+		return nil
+	}
+	*m = multiline(s)
+	return nil
+}
+
+// isNotebook reports whether the file name is that of a Jupyter notebook.
+func isNotebook(fileName string) bool {
+	return strings.HasSuffix(strings.ToLower(fileName), ".ipynb")
+}
+
+// notebookToText renders the notebook: markdown cells through blackfridaytext,
+// code cells as fenced code in the kernel's language with an "In [n]:" gutter,
+// and their outputs below them.
+func notebookToText(data []byte, opt *blackfridaytext.Options) ([]byte, error) {
+	var nb notebook
+	if err := json.Unmarshal(data, &nb); err != nil {
+		return nil, err
+	}
+	language := nb.Metadata.LanguageInfo.Name
+	if language == "" {
+		language = nb.Metadata.KernelSpec.Language
+	}
+	gutter := 0
+	for _, c := range nb.Cells {
+		if c.CellType != "code" {
+			continue
+		}
+		if w := len(inPrompt(c.ExecutionCount)); w > gutter {
+			gutter = w
+		}
+		for _, o := range c.Outputs {
+			if w := len(outPrompt(o)); w > gutter {
+				gutter = w
+			}
+		}
+	}
+	copt := *opt
+	if copt.Width < 1 {
+		copt.Width += brimtext.GetTTYWidth() - 1
+	}
+	copt.Width -= gutter
+	// Each cell is rendered on its own, so content can't stay indented under
+	// headers from earlier cells.
+	copt.HeaderIndent = []byte{}
+
+	var out bytes.Buffer
+	for _, c := range nb.Cells {
+		switch c.CellType {
+		case "markdown":
+			text := blackfridaytext.MarkdownToTextNoMetadata([]byte(c.Source), &copt)
+			writeGutter(&out, "", nil, gutter, text)
+		case "code":
+			fence := "```"
+			for strings.Contains(string(c.Source), fence) {
+				fence += "`"
+			}
+			code := fence + language + "\n" + string(c.Source) + "\n" + fence + "\n"
+			text := blackfridaytext.MarkdownToTextNoMetadata([]byte(code), &copt)
+			writeGutter(&out, inPrompt(c.ExecutionCount), brimtext.ANSIEscape.FBlue, gutter, text)
+			for _, o := range c.Outputs {
+				writeNotebookOutput(&out, o, gutter, &copt)
+			}
+		default:
+			writeGutter(&out, "", nil, gutter, []byte(c.Source))
+		}
+		out.WriteString("\n")
+	}
+	return out.Bytes(), nil
+}
+
+func inPrompt(count *int) string {
+	if count == nil {
+		return "In [ ]: "
+	}
+	return fmt.Sprintf("In [%d]: ", *count)
+}
+
+func outPrompt(o notebookOutput) string {
+	if o.OutputType != "execute_result" || o.ExecutionCount == nil {
+		return ""
+	}
+	return fmt.Sprintf("Out[%d]: ", *o.ExecutionCount)
+}
+
+// writeNotebookOutput writes a code cell output: stream text in cyan (red for
+// stderr), results with an "Out[n]:" gutter, errors with their traceback and
+// images and other rich output as placeholders.
+func writeNotebookOutput(out *bytes.Buffer, o notebookOutput, gutter int, opt *blackfridaytext.Options) {
+	switch o.OutputType {
+	case "stream":
+		color := brimtext.ANSIEscape.FCyan
+		if o.Name == "stderr" {
+			color = brimtext.ANSIEscape.FRed
+		}
+		writeGutter(out, "", nil, gutter, styleLines(string(o.Text), color))
+	case "error":
+		text := o.Ename + ": " + o.Evalue
+		if len(o.Traceback) > 0 {
+			text = strings.Join(o.Traceback, "\n")
+		}
+		writeGutter(out, "", nil, gutter, styleLines(text, brimtext.ANSIEscape.FRed))
+	case "execute_result", "display_data":
+		prompt := outPrompt(o)
+		var text []byte
+		switch {
+		case o.Data["text/markdown"] != "":
+			text = bytes.Trim(blackfridaytext.MarkdownToTextNoMetadata([]byte(o.Data["text/markdown"]), opt), "\n")
+		case o.Data["text/plain"] != "" && !hasImage(o.Data):
+			text = styleLines(string(o.Data["text/plain"]), brimtext.ANSIEscape.FCyan)
+		default:
+			var types []string
+			for t := range o.Data {
+				if t != "text/plain" {
+					types = append(types, t)
+				}
+			}
+			sort.Strings(types)
+			placeholder := "[" + strings.Join(types, ", ") + " output]"
+			if alt := strings.TrimSpace(string(o.Data["text/plain"])); alt != "" {
+				placeholder = "[" + alt + "] " + strings.Join(types, ", ")
+			}
+			text = styleLines(placeholder, brimtext.ANSIEscape.FMagenta)
+		}
+		writeGutter(out, prompt, brimtext.ANSIEscape.FRed, gutter, text)
+	}
+}
+
+func hasImage(data map[string]multiline) bool {
+	for t := range data {
+		if strings.HasPrefix(t, "image/") {
+			return true
+		}
+	}
+	return false
+}
+
+// styleLines colors each line of the text, so the color survives the gutter
+// written in front of it.
+func styleLines(text string, color []byte) []byte {
+	var out bytes.Buffer
+	for i, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if i > 0 {
+			out.WriteByte('\n')
+		}
+		out.Write(color)
+		out.WriteString(line)
+		out.Write(brimtext.ANSIEscape.Reset)
+	}
+	return out.Bytes()
+}
+
+// writeGutter writes the text with the prompt, in color, in front of its first
+// line and the other lines indented to match.
+func writeGutter(out *bytes.Buffer, prompt string, color []byte, width int, text []byte) {
+	text = bytes.Trim(text, "\n")
+	if len(text) == 0 {
+		return
+	}
+	for i, line := range bytes.Split(text, []byte("\n")) {
+		if i == 0 && prompt != "" {
+			out.Write(color)
+			out.WriteString(prompt)
+			out.Write(brimtext.ANSIEscape.Reset)
+			out.WriteString(strings.Repeat(" ", width-len(prompt)))
+		} else if len(line) > 0 {
+			out.WriteString(strings.Repeat(" ", width))
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/rn/utils/mdv/blackfridaytext"
+	"github.com/rn/utils/mdv/brimtext"
+)
+
+func TestMultiline(t *testing.T) {
+	for data, want := range map[string]string{
+		`"one string"`:           "one string",
+		`["line 1\n", "line 2"]`: "line 1\nline 2",
+		`{"a": 1}`:               `{"a": 1}`,
+	} {
+		var m multiline
+		if err := json.Unmarshal([]byte(data), &m); err != nil || string(m) != want {
+			t.Errorf("unmarshal %s = %q, %v, want %q", data, m, err, want)
+		}
+	}
+}
+
+const testNotebook = `{
+ "metadata": {"language_info": {"name": "python"}},
+ "cells": [
+  {"cell_type": "markdown", "source": ["# Title\n", "Some *text*."]},
+  {"cell_type": "code", "execution_count": 12, "source": "print('hi')\n1 + 1",
+   "outputs": [
+    {"output_type": "stream", "name": "stdout", "text": ["hi\n"]},
+    {"output_type": "execute_result", "execution_count": 12, "data": {"text/plain": "2"}},
+    {"output_type": "display_data", "data": {"image/png": "iVBOR", "text/plain": "<Figure>"}},
+    {"output_type": "error", "ename": "ValueError", "evalue": "bad"}
+   ]},
+  {"cell_type": "code", "execution_count": null, "source": "", "outputs": []},
+  {"cell_type": "raw", "source": "raw text"}
+ ]
+}`
+
+func TestNotebookToText(t *testing.T) {
+	out, err := notebookToText([]byte(testNotebook), &blackfridaytext.Options{Width: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, line := range brimtext.ParseANSI(string(out)) {
+		var text string
+		for _, span := range line {
+			text += span.Text
+		}
+		lines = append(lines, strings.TrimRight(text, " "))
+	}
+	got := strings.Join(lines, "\n")
+	for _, want := range []string{
+		"         --[ Title ]--\n\n         Some *text*.",
+		"In [12]: print('hi')\n         1 + 1",
+		"\n         hi\n",
+		"Out[12]: 2\n",
+		"         [<Figure>] image/png\n",
+		"         ValueError: bad\n",
+		"         raw text\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("notebook output doesn't contain %q:\n%s", want, got)
+		}
+	}
+	if _, err := notebookToText([]byte("not json"), &blackfridaytext.Options{}); err == nil {
+		t.Errorf("rendering a bad notebook succeeded")
+	}
+}
+
+func TestWriteGutter(t *testing.T) {
+	var out bytes.Buffer
+	writeGutter(&out, "In [1]: ", nil, 9, []byte("\nfirst\n\nthird\n"))
+	writeGutter(&out, "", nil, 9, []byte("\n\n"))
+	want := "In [1]: \x1b[0m first\n\n         third\n"
+	if out.String() != want {
+		t.Errorf("writeGutter = %q, want %q", out.String(), want)
+	}
+}
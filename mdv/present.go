@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rn/utils/mdv/blackfridaytext"
+	"github.com/rn/utils/mdv/brimtext"
+)
+
+// slide is a single page of a presentation.
+type slide struct {
+	title string
+	body  []byte
+	notes []string
+}
+
+var commentRe = regexp.MustCompile(`(?s)<!--(.*?)-->`)
+
+// splitSlides splits the markdown into slides at horizontal rules or, if
+// there are none, before each level 1 and 2 header. The first header of each
+// slide becomes its title and HTML comments become its speaker notes.
+func splitSlides(markdown []byte) []slide {
+	boundaries := blackfridaytext.MarkdownBoundaries(markdown)
+	hasRules := false
+	for _, b := range boundaries {
+		if b.Level == 0 {
+			hasRules = true
+		}
+	}
+	var slides []slide
+	start := 0
+	add := func(end int) {
+		if s, ok := newSlide(markdown[start:end]); ok {
+			slides = append(slides, s)
+		}
+	}
+	for _, b := range boundaries {
+		switch {
+		case hasRules && b.Level == 0:
+			add(b.Start)
+			start = b.End
+		case !hasRules && b.Level > 0 && b.Level <= 2:
+			add(b.Start)
+			start = b.Start
+		}
+	}
+	add(len(markdown))
+	return slides
+}
+
+func newSlide(markdown []byte) (slide, bool) {
+	var s slide
+	for _, m := range commentRe.FindAllSubmatch(markdown, -1) {
+		if note := strings.TrimSpace(string(m[1])); note != "" {
+			s.notes = append(s.notes, note)
+		}
+	}
+	markdown = commentRe.ReplaceAll(markdown, nil)
+	if len(bytes.TrimSpace(markdown)) == 0 {
+		return s, false
+	}
+	boundaries := blackfridaytext.MarkdownBoundaries(markdown)
+	if len(boundaries) > 0 && boundaries[0].Level > 0 && len(bytes.TrimSpace(markdown[:boundaries[0].Start])) == 0 {
+		if headers := blackfridaytext.MarkdownHeaders(markdown[:boundaries[0].End]); len(headers) > 0 {
+			s.title = headers[0].Text
+		}
+		markdown = markdown[boundaries[0].End:]
+	}
+	s.body = markdown
+	return s, true
+}
+
+// present shows the slides full screen until the user quits.
+func present(fileName string, markdown []byte, opt *blackfridaytext.Options) error {
+	slides := splitSlides(markdown)
+	if len(slides) == 0 {
+		return fmt.Errorf("no slides in %s", fileName)
+	}
+	scr, err := openScreen()
+	if err != nil {
+		return err
+	}
+	defer scr.close()
+
+	current := 0
+	showNotes := false
+	for {
+		scr.draw(renderSlide(slides[current], current, len(slides), showNotes, fileName, scr.width, scr.height, opt))
+		select {
+		case <-scr.resize:
+			scr.width, scr.height = scr.size()
+		case k, ok := <-scr.keys:
+			if !ok {
+				return nil
+			}
+			switch k {
+			case 'q', keyCtrlC, keyEscape:
+				return nil
+			case keyRight, keyDown, keyPageDown, ' ', keyEnter, 'l', 'j':
+				if current < len(slides)-1 {
+					current++
+				}
+			case keyLeft, keyUp, keyPageUp, keyBackspace, 'h', 'k':
+				if current > 0 {
+					current--
+				}
+			case keyHome, 'g':
+				current = 0
+			case keyEnd, 'G':
+				current = len(slides) - 1
+			case 'n':
+				showNotes = !showNotes
+			}
+		}
+	}
+}
+
+// renderSlide lays out the slide for a width x height screen: the title in
+// double size letters, the body centered below it, optionally the speaker
+// notes, and a footer with the slide counter on the last row.
+func renderSlide(s slide, index, count int, showNotes bool, fileName string, width, height int, opt *blackfridaytext.Options) []string {
+	sopt := *opt
+	sopt.Width = width * 4 / 5
+	if sopt.Width < 40 {
+		sopt.Width = width - 2
+	}
+	sopt.HeaderIndent = []byte{}
+	body := blackfridaytext.MarkdownToTextNoMetadata(s.body, &sopt)
+	bodyLines := strings.Split(strings.Trim(string(body), "\n"), "\n")
+
+	var content []string
+	if s.title != "" {
+		content = append(content, bigText(s.title, width)...)
+		content = append(content, "")
+	}
+	// Code blocks and tables aren't wrapped, so may be wider than the
+	// screen.
+	bodyWidth := 0
+	for i, line := range bodyLines {
+		bodyLines[i] = truncate(line, width)
+		if w := visibleWidth(bodyLines[i]); w > bodyWidth {
+			bodyWidth = w
+		}
+	}
+	pad := strings.Repeat(" ", (width-bodyWidth)/2)
+	for _, line := range bodyLines {
+		content = append(content, pad+line)
+	}
+
+	var notes []string
+	if showNotes && len(s.notes) > 0 {
+		notes = append(notes, string(brimtext.ANSIEscape.FYellow)+strings.Repeat("-", width)+string(brimtext.ANSIEscape.Reset))
+		for _, note := range s.notes {
+			for _, line := range wrap(note, width-1) {
+				notes = append(notes, string(brimtext.ANSIEscape.FYellow)+line+string(brimtext.ANSIEscape.Reset))
+			}
+		}
+	}
+
+	available := height - 1 - len(notes)
+	if available < 1 {
+		notes = nil
+		available = height - 1
+	}
+	if len(content) > available {
+		content = content[:available]
+	}
+	lines := make([]string, (available-len(content))/2, height)
+	lines = append(lines, content...)
+	for len(lines) < available {
+		lines = append(lines, "")
+	}
+	lines = append(lines, notes...)
+
+	counter := fmt.Sprintf(" %d/%d ", index+1, count)
+	name := " " + fileName
+	gap := width - utf8.RuneCountInString(name) - len(counter)
+	if gap < 0 {
+		name = ""
+		gap = width - len(counter)
+	}
+	if gap < 0 {
+		gap = 0
+	}
+	lines = append(lines, truncate("\x1b[7m"+name+strings.Repeat(" ", gap)+counter+string(brimtext.ANSIEscape.Reset), width))
+	return lines
+}
+
+// bigText returns the text centered in double width, double height letters,
+// using the DEC line attributes most terminal emulators support, or in bold,
+// wrapped to the width, if it would not fit.
+func bigText(text string, width int) []string {
+	n := utf8.RuneCountInString(text)
+	bold := string(brimtext.ANSIEscape.Bold)
+	reset := string(brimtext.ANSIEscape.Reset)
+	if n*2 > width {
+		var lines []string
+		for _, line := range wrap(text, width) {
+			pad := strings.Repeat(" ", (width-utf8.RuneCountInString(line))/2)
+			lines = append(lines, pad+bold+line+reset)
+		}
+		return lines
+	}
+	pad := strings.Repeat(" ", (width/2-n)/2)
+	return []string{
+		"\x1b#3" + pad + bold + text + reset,
+		"\x1b#4" + pad + bold + text + reset,
+	}
+}
+
+// wrap wraps the plain text to lines of at most width columns, cutting words
+// that are longer.
+func wrap(text string, width int) []string {
+	if width < 1 {
+		width = 1
+	}
+	var lines []string
+	for _, line := range strings.Split(brimtext.Wrap(text, width, "", ""), "\n") {
+		for utf8.RuneCountInString(line) > width {
+			r := []rune(line)
+			lines = append(lines, string(r[:width]))
+			line = string(r[width:])
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// truncate cuts the text to at most width columns, keeping any ANSI Escape
+// Codes and resetting the style if anything was cut.
+func truncate(text string, width int) string {
+	if visibleWidth(text) <= width {
+		return text
+	}
+	var out bytes.Buffer
+	column := 0
+	for i := 0; i < len(text); {
+		if text[i] == '\x1b' {
+			j := strings.IndexByte(text[i:], 'm')
+			if j == -1 {
+				break
+			}
+			out.WriteString(text[i : i+j+1])
+			i += j + 1
+			continue
+		}
+		// The width of a character, with any emoji variation selector after
+		// it, is worked out as DisplayWidth does.
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if next, n := utf8.DecodeRuneInString(text[i+size:]); next == '\ufe0f' && brimtext.RuneWidth(r) == 1 {
+			size += n
+		}
+		w := brimtext.DisplayWidth(text[i : i+size])
+		if column+w > width {
+			break
+		}
+		out.WriteString(text[i : i+size])
+		i += size
+		column += w
+	}
+	out.Write(brimtext.ANSIEscape.Reset)
+	return out.String()
+}
+
+// visibleWidth returns the number of columns the text takes up, ignoring ANSI
+// Escape Codes.
+func visibleWidth(text string) int {
+	n := 0
+	for _, line := range brimtext.ParseANSI(text) {
+		for _, span := range line {
+			n += brimtext.DisplayWidth(span.Text)
+		}
+	}
+	return n
+}
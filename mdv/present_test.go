@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rn/utils/mdv/blackfridaytext"
+)
+
+func TestSplitSlides(t *testing.T) {
+	for _, test := range []struct {
+		markdown string
+		titles   []string
+		notes    [][]string
+	}{
+		{"# One\n\ntext\n\n## Two\n\n<!-- say hi -->\n\n### Three\n", []string{"One", "Two"}, [][]string{nil, {"say hi"}}},
+		{"intro\n\n---\n\n# A\n\nx\n\n# B\n\n---\n\n<!-- only a note -->\n", []string{"", "A"}, [][]string{nil, nil}},
+		{"\n\n", nil, nil},
+	} {
+		var titles []string
+		var notes [][]string
+		for _, s := range splitSlides([]byte(test.markdown)) {
+			titles = append(titles, s.title)
+			notes = append(notes, s.notes)
+		}
+		if !reflect.DeepEqual(titles, test.titles) || !reflect.DeepEqual(notes, test.notes) {
+			t.Errorf("splitSlides(%q) titles %q notes %q, want %q and %q", test.markdown, titles, notes, test.titles, test.notes)
+		}
+	}
+}
+
+func TestRenderSlideNarrow(t *testing.T) {
+	opt := &blackfridaytext.Options{Color: true}
+	s := slide{
+		title: strings.Repeat("Title ", 16) + strings.Repeat("x", 60),
+		body:  []byte("text\n\n    " + strings.Repeat("code", 50) + "\n\n| a | b |\n|---|---|\n| " + strings.Repeat("wide ", 30) + " | c |\n"),
+		notes: []string{strings.Repeat("n", 100)},
+	}
+	for _, width := range []int{1, 5, 40, 80} {
+		lines := renderSlide(s, 0, 1, true, "slides.md", width, 24, opt)
+		if len(lines) != 24 {
+			t.Errorf("width %d: %d lines, want 24", width, len(lines))
+		}
+		for _, line := range lines {
+			if strings.HasPrefix(line, "\x1b#") {
+				continue
+			}
+			if w := visibleWidth(line); w > width {
+				t.Errorf("width %d: line %q is %d wide", width, line, w)
+			}
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	for _, test := range []struct {
+		text  string
+		width int
+		want  string
+	}{
+		{"short", 10, "short"},
+		{"abcdef", 3, "abc\x1b[0m"},
+		{"\x1b[1mαβγδ\x1b[0m", 2, "\x1b[1mαβ\x1b[0m"},
+		{"abc", 0, "\x1b[0m"},
+		// Wide characters aren't cut in half.
+		{"漢字テキスト", 5, "漢字\x1b[0m"},
+		{"a❤️b🚀", 4, "a❤️b\x1b[0m"},
+	} {
+		if got := truncate(test.text, test.width); got != test.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", test.text, test.width, got, test.want)
+		}
+	}
+}
+
+func TestBigText(t *testing.T) {
+	if got := bigText("Hi", 40); len(got) != 2 || !strings.HasPrefix(got[0], "\x1b#3"+strings.Repeat(" ", 9)) {
+		t.Errorf("bigText(Hi, 40) = %q, want double size centered", got)
+	}
+	for _, line := range bigText(strings.Repeat("word ", 20)+strings.Repeat("y", 50), 40) {
+		if w := visibleWidth(line); w > 40 {
+			t.Errorf("bigText line %q is %d wide", line, w)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// key is a key press read from the terminal. Printable keys and control
+// characters are their rune value, other keys are one of the negative
+// constants below.
+type key rune
+
+const (
+	keyUnknown key = -1 - iota
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyPageUp
+	keyPageDown
+	keyHome
+	keyEnd
+	keyBackTab
+)
+
+const (
+	keyCtrlC     key = 0x03
+	keyTab       key = '\t'
+	keyEnter     key = '\r'
+	keyEscape    key = 0x1b
+	keyBackspace key = 0x7f
+)
+
+// escapeKeys maps the escape sequences sent by common terminals to keys.
+var escapeKeys = map[string]key{
+	"[A": keyUp, "OA": keyUp,
+	"[B": keyDown, "OB": keyDown,
+	"[C": keyRight, "OC": keyRight,
+	"[D": keyLeft, "OD": keyLeft,
+	"[5~": keyPageUp,
+	"[6~": keyPageDown,
+	"[H":  keyHome, "OH": keyHome, "[1~": keyHome, "[7~": keyHome,
+	"[F": keyEnd, "OF": keyEnd, "[4~": keyEnd, "[8~": keyEnd,
+	"[Z": keyBackTab,
+}
+
+// screen is a full screen, raw mode terminal session on stdin/stdout.
+type screen struct {
+	state  *terminal.State
+	keys   chan key
+	resize chan struct{}
+	width  int
+	height int
+}
+
+// openScreen switches the terminal to raw mode and the alternate screen. The
+// caller must call close to restore the terminal.
+func openScreen() (*screen, error) {
+	inFd := int(os.Stdin.Fd())
+	outFd := int(os.Stdout.Fd())
+	if !terminal.IsTerminal(inFd) || !terminal.IsTerminal(outFd) {
+		return nil, errors.New("stdin and stdout need to be a terminal")
+	}
+	state, err := terminal.MakeRaw(inFd)
+	if err != nil {
+		return nil, err
+	}
+	s := &screen{
+		state:  state,
+		keys:   make(chan key, 16),
+		resize: make(chan struct{}, 1),
+	}
+	s.width, s.height = s.size()
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l")
+	go s.readKeys()
+	go s.watchSize()
+	return s, nil
+}
+
+func (s *screen) close() {
+	os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+	terminal.Restore(int(os.Stdin.Fd()), s.state)
+}
+
+func (s *screen) size() (int, int) {
+	width, height, err := terminal.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width < 1 || height < 1 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// draw clears the screen and writes the lines, one per row.
+func (s *screen) draw(lines []string) {
+	var b bytes.Buffer
+	b.WriteString("\x1b[H\x1b[2J")
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(line)
+	}
+	os.Stdout.Write(b.Bytes())
+}
+
+func (s *screen) readKeys() {
+	buf := make([]byte, 64)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			close(s.keys)
+			return
+		}
+		in := string(buf[:n])
+		for len(in) > 0 {
+			if in[0] != 0x1b || len(in) == 1 {
+				r := []rune(in)[0]
+				s.keys <- key(r)
+				in = in[len(string(r)):]
+				continue
+			}
+			seq := in[1:]
+			end := 1
+			if seq[0] == '[' || seq[0] == 'O' {
+				for end < len(seq) && (seq[end] < 0x40 || seq[end] > 0x7e) {
+					end++
+				}
+				end++
+			}
+			if end > len(seq) {
+				end = len(seq)
+			}
+			if k, ok := escapeKeys[seq[:end]]; ok {
+				s.keys <- k
+			} else {
+				s.keys <- keyUnknown
+			}
+			in = seq[end:]
+		}
+	}
+}
+
+// watchSize polls for terminal size changes, which works the same everywhere,
+// unlike SIGWINCH. The receiver of s.resize should update s.width and
+// s.height.
+func (s *screen) watchSize() {
+	lastWidth, lastHeight := s.width, s.height
+	for range time.Tick(250 * time.Millisecond) {
+		width, height := s.size()
+		if width != lastWidth || height != lastHeight {
+			lastWidth, lastHeight = width, height
+			select {
+			case s.resize <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
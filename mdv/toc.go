@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rn/utils/mdv/blackfridaytext"
+)
+
+// printTOC writes the headers as an indented, numbered outline.
+func printTOC(w io.Writer, headers []blackfridaytext.Header) {
+	for _, h := range headers {
+		depth := strings.Count(h.Number, ".")
+		fmt.Fprintf(w, "%s%s %s\n", strings.Repeat("    ", depth), h.Number, h.Text)
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rn/utils/mdv/blackfridaytext"
+)
+
+func TestPrintTOC(t *testing.T) {
+	var out bytes.Buffer
+	printTOC(&out, blackfridaytext.MarkdownHeaders([]byte("# A\n\n### B\n\n## C\n\n# D\n")))
+	want := "1 A\n    1.1 B\n    1.2 C\n2 D\n"
+	if out.String() != want {
+		t.Errorf("printTOC:\n got %q\nwant %q", out.String(), want)
+	}
+}
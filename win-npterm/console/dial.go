@@ -0,0 +1,156 @@
+// Package console connects to the serial consoles of VMs: Windows named
+// pipes, Unix domain sockets, TCP ports and PTYs. It is the connection layer
+// of npterm, and also has an Expect function for scripting consoles.
+package console
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// A console target is given as a URL, one of:
+// - npipe://./pipe/name (or npipe:////./pipe/name): a Windows named pipe
+// - unix:///path/to/socket: a Unix domain socket
+// - tcp://host:port: a TCP port
+// - pty:///dev/pts/N: a PTY or other character device
+// or as a bare named pipe (\\.\pipe\name), path or host:port, whose scheme is
+// worked out by ParseTarget.
+
+// ParseTarget returns the scheme and address of the console target.
+func ParseTarget(target string) (string, string, error) {
+	if i := strings.Index(target, "://"); i != -1 {
+		scheme, addr := target[:i], target[i+3:]
+		switch scheme {
+		case "npipe":
+			addr = strings.Replace(addr, "/", `\`, -1)
+			if !strings.HasPrefix(addr, `\\`) {
+				addr = `\\` + addr
+			}
+		case "unix", "pty", "tcp":
+		default:
+			return "", "", fmt.Errorf("unknown scheme %q", scheme)
+		}
+		if addr == "" {
+			return "", "", fmt.Errorf("no address in %q", target)
+		}
+		return scheme, addr, nil
+	}
+
+	if strings.HasPrefix(target, `\\`) {
+		return "npipe", target, nil
+	}
+	if fi, err := os.Stat(target); err == nil {
+		switch {
+		case fi.Mode()&os.ModeSocket != 0:
+			return "unix", target, nil
+		case fi.Mode()&os.ModeDevice != 0:
+			return "pty", target, nil
+		}
+		return "", "", fmt.Errorf("%s is not a socket or device", target)
+	}
+	if _, _, err := net.SplitHostPort(target); err == nil && !strings.ContainsAny(target, `/\`) {
+		return "tcp", target, nil
+	}
+	// The target may just not exist yet, such as the socket of a VM that is
+	// still starting.
+	return bareScheme, target, nil
+}
+
+// Dial connects to the console target, given by its scheme and address as
+// returned by ParseTarget.
+func Dial(scheme, addr string) (io.ReadWriteCloser, error) {
+	switch scheme {
+	case "npipe":
+		return dialPipe(addr)
+	case "pty":
+		return openPTY(addr)
+	}
+	return net.Dial(scheme, addr)
+}
+
+// The kinds of errors dialing a target, from dialErrorKind.
+const (
+	// dialNotFound means the target doesn't exist (yet), such as while a VM
+	// is being (re)started.
+	dialNotFound = iota
+	// dialBusy means the target exists but can't be connected to right now,
+	// such as a named pipe another client is connected to.
+	dialBusy
+	// dialFailed is any other error, which retrying won't fix.
+	dialFailed
+)
+
+// minBackoff is the shortest delay between retries; without one a Backoff of 0
+// would spin.
+const minBackoff = 10 * time.Millisecond
+
+// Retry is how to retry connecting to a target which isn't found or is busy.
+type Retry struct {
+	// Backoff is the delay before the first retry, doubling on each retry
+	// up to MaxBackoff. Neither is taken to be under 10ms.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout is how long to keep retrying, or 0 to retry forever.
+	Timeout time.Duration
+}
+
+// Connect dials the target, retrying as long as it isn't found or is busy.
+// Each change of the reason for retrying is passed to status, and retrying
+// stops when stop returns true; either may be nil.
+func Connect(scheme, addr string, r Retry, status func(string, ...interface{}), stop func() bool) (io.ReadWriteCloser, error) {
+	if status == nil {
+		status = func(string, ...interface{}) {}
+	}
+	if stop == nil {
+		stop = func() bool { return false }
+	}
+	start := time.Now()
+	backoff, maxBackoff := r.Backoff, r.MaxBackoff
+	if backoff < minBackoff {
+		backoff = minBackoff
+	}
+	if maxBackoff < backoff {
+		maxBackoff = backoff
+	}
+	last := -1
+	for {
+		c, err := Dial(scheme, addr)
+		if err == nil {
+			return c, nil
+		}
+		kind := dialErrorKind(err)
+		switch {
+		case kind == dialFailed:
+			return nil, err
+		case kind == last:
+		case kind == dialNotFound:
+			status("Waiting for %s to appear: %v", addr, err)
+		case kind == dialBusy:
+			status("Waiting for %s to be free: %v", addr, err)
+		}
+		last = kind
+		if r.Timeout > 0 && time.Since(start)+backoff > r.Timeout {
+			return nil, fmt.Errorf("gave up after %v: %v", r.Timeout, err)
+		}
+		time.Sleep(backoff)
+		if stop() {
+			return nil, fmt.Errorf("stopped")
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// Open connects to the console target, retrying as Connect does.
+func Open(target string, r Retry) (io.ReadWriteCloser, error) {
+	scheme, addr, err := ParseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	return Connect(scheme, addr, r, nil, nil)
+}
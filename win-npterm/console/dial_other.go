@@ -0,0 +1,61 @@
+//go:build !windows
+// +build !windows
+
+package console
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// bareScheme is the scheme of bare targets which don't exist yet.
+const bareScheme = "unix"
+
+func dialPipe(name string) (io.ReadWriteCloser, error) {
+	return nil, fmt.Errorf("named pipes are only supported on Windows")
+}
+
+func openPTY(path string) (io.ReadWriteCloser, error) {
+	// Don't let the device become our controlling terminal.
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	// In its default cooked mode the line discipline would echo input back,
+	// hold it until a newline, turn Ctrl-C into a signal and newlines into
+	// CRLF. The terminal is npterm's, so pass everything through instead.
+	// Control rather than Fd keeps the file non-blocking, so Close still
+	// interrupts a Read.
+	rc, err := f.SyscallConn()
+	if err == nil {
+		if cerr := rc.Control(func(fd uintptr) { _, err = MakeRaw(fd) }); cerr != nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return f, nil
+}
+
+// dialErrorKind classifies an error from dial. A socket with nothing
+// listening on it is as good as not found: the VM isn't up yet.
+func dialErrorKind(err error) int {
+	switch {
+	case errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED):
+		return dialNotFound
+	case errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EAGAIN):
+		return dialBusy
+	}
+	return dialFailed
+}
+
+// ListenPipe listens on the named pipe.
+func ListenPipe(name string) (net.Listener, error) {
+	return nil, fmt.Errorf("named pipes are only supported on Windows")
+}
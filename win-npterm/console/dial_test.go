@@ -0,0 +1,37 @@
+package console
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConnectMinBackoff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "npterm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	missing := filepath.Join(dir, "missing.sock")
+	for _, r := range []Retry{
+		{Timeout: 100 * time.Millisecond},
+		{Backoff: time.Millisecond, Timeout: 100 * time.Millisecond},
+		{Backoff: 20 * time.Millisecond, Timeout: 100 * time.Millisecond},
+	} {
+		tries := 0
+		start := time.Now()
+		_, err := Connect("unix", missing, r, nil, func() bool {
+			tries++
+			return false
+		})
+		if err == nil {
+			t.Fatalf("%+v: connected to %s", r, missing)
+		}
+		// Retrying every 10ms or more fits at most 10 retries in 100ms.
+		if tries > 10 {
+			t.Errorf("%+v: retried %d times in %v", r, tries, time.Since(start))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package console
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// bareScheme is the scheme of bare targets which don't exist yet.
+const bareScheme = "npipe"
+
+func dialPipe(name string) (io.ReadWriteCloser, error) {
+	return winio.DialPipe(name, nil)
+}
+
+func openPTY(path string) (io.ReadWriteCloser, error) {
+	return nil, fmt.Errorf("PTYs are not supported on Windows")
+}
+
+// errorPipeBusy is ERROR_PIPE_BUSY and wsaeConnRefused WSAECONNREFUSED,
+// which aren't in syscall.
+const (
+	errorPipeBusy   syscall.Errno = 231
+	wsaeConnRefused syscall.Errno = 10061
+)
+
+// dialErrorKind classifies an error from dial. Which error a missing or busy
+// pipe gives depends on the Windows version: some time out waiting for a busy
+// pipe with winio.ErrTimeout rather than failing with ERROR_PIPE_BUSY. A TCP
+// port with nothing listening on it is as good as not found: the VM isn't up
+// yet.
+func dialErrorKind(err error) int {
+	if errors.Is(err, wsaeConnRefused) || errors.Is(err, syscall.ECONNREFUSED) {
+		return dialNotFound
+	}
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	switch {
+	case err == winio.ErrTimeout || err == errorPipeBusy:
+		return dialBusy
+	case os.IsNotExist(err) || err == syscall.ERROR_PATH_NOT_FOUND:
+		return dialNotFound
+	}
+	return dialFailed
+}
+
+// ListenPipe listens on the named pipe.
+func ListenPipe(name string) (net.Listener, error) {
+	return winio.ListenPipe(name, nil)
+}
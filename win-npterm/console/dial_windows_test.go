@@ -0,0 +1,28 @@
+package console
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/Microsoft/go-winio"
+)
+
+func TestDialErrorKind(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connectex", wsaeConnRefused)}
+	for _, test := range []struct {
+		err  error
+		want int
+	}{
+		{refused, dialNotFound},
+		{&os.PathError{Op: "open", Path: `\\.\pipe\vm`, Err: syscall.ERROR_FILE_NOT_FOUND}, dialNotFound},
+		{&os.PathError{Op: "open", Path: `\\.\pipe\vm`, Err: errorPipeBusy}, dialBusy},
+		{winio.ErrTimeout, dialBusy},
+		{&os.PathError{Op: "open", Path: `\\.\pipe\vm`, Err: syscall.ERROR_ACCESS_DENIED}, dialFailed},
+	} {
+		if got := dialErrorKind(test.err); got != test.want {
+			t.Errorf("dialErrorKind(%v) = %d, want %d", test.err, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package console
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// deadliner is a connection with read timeouts, as all those from Dial are.
+type deadliner interface {
+	SetReadDeadline(time.Time) error
+}
+
+// TimeoutError is returned when the expected output doesn't turn up in time.
+type TimeoutError struct {
+	Pattern string
+	Timeout time.Duration
+	// Output is the end of the output read while waiting.
+	Output string
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %v waiting for %q; last output: %s", e.Timeout, e.Pattern, strconv.Quote(e.Output))
+}
+
+// tailLength is how much output a TimeoutError holds.
+const tailLength = 200
+
+// searchWindow is how much of the output already searched is searched again
+// after each read, so long output isn't searched over and over: matches must
+// be within the last searchWindow bytes read.
+const searchWindow = 4096
+
+// maxOutput is how much output an Expecter keeps while waiting for a match;
+// what's returned is at most the last maxOutput bytes up to the match.
+const maxOutput = 1024 * 1024
+
+// Expecter reads a console's output looking for patterns.
+type Expecter struct {
+	conn io.Reader
+	// Echo, if not nil, is sent all output read.
+	Echo io.Writer
+	// buf is output read but not yet matched.
+	buf []byte
+	// oneByte reads a byte at a time, so nothing after a match is read.
+	oneByte bool
+}
+
+// NewExpecter returns an Expecter reading from the connection. The connection
+// must support read deadlines if timeouts are used. Output read after a match
+// is kept for the next Expect, so once there is an Expecter all reads should
+// go through it. Matches can be up to 4KB long, and the output returned is at
+// most the last 1MB up to the end of the match.
+func NewExpecter(conn io.Reader) *Expecter {
+	return &Expecter{conn: conn}
+}
+
+// Expect reads from the connection until the output matches the regular
+// expression, returning the output up to the end of the match and the
+// submatches. A timeout of 0 waits forever.
+func (e *Expecter) Expect(pattern string, timeout time.Duration) (string, []string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", nil, err
+	}
+	return e.ExpectRegexp(re, timeout)
+}
+
+// ExpectRegexp is Expect with a compiled regular expression.
+func (e *Expecter) ExpectRegexp(re *regexp.Regexp, timeout time.Duration) (string, []string, error) {
+	out, groups, _, err := e.expect(re, timeout)
+	return out, groups, err
+}
+
+// ExpectAny reads from the connection until the output matches any of the
+// regular expressions, returning the output up to the end of the match, the
+// index of the expression matching and its match. If several match, the one
+// matching earliest in the output wins, or the first of those given.
+func (e *Expecter) ExpectAny(patterns []string, timeout time.Duration) (string, int, string, error) {
+	// Each pattern is a group of the combined pattern; starts are the
+	// indexes of their groups.
+	var combined []string
+	var starts []int
+	next := 1
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return "", -1, "", err
+		}
+		combined = append(combined, "("+pattern+")")
+		starts = append(starts, next)
+		next += 1 + re.NumSubexp()
+	}
+	re, err := regexp.Compile(strings.Join(combined, "|"))
+	if err != nil {
+		return "", -1, "", err
+	}
+	out, groups, matched, err := e.expect(re, timeout)
+	if err != nil {
+		return "", -1, "", err
+	}
+	for i, start := range starts {
+		if matched[start] {
+			return out, i, groups[start], nil
+		}
+	}
+	return out, -1, groups[0], nil
+}
+
+// expect is ExpectRegexp, also returning which groups took part in the
+// match, as a group can match empty output.
+func (e *Expecter) expect(re *regexp.Regexp, timeout time.Duration) (string, []string, []bool, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		d, ok := e.conn.(deadliner)
+		if !ok {
+			return "", nil, nil, fmt.Errorf("connection doesn't support timeouts")
+		}
+		deadline = time.Now().Add(timeout)
+		defer d.SetReadDeadline(time.Time{})
+		if err := d.SetReadDeadline(deadline); err != nil {
+			return "", nil, nil, err
+		}
+	}
+	size := 4096
+	if e.oneByte {
+		size = 1
+	}
+	buf := make([]byte, size)
+	// searched is how much of the buffer didn't match.
+	searched := 0
+	for {
+		from := searched + 1 - searchWindow
+		if from < 0 {
+			from = 0
+		}
+		if m := re.FindSubmatchIndex(e.buf[from:]); m != nil {
+			start := from + m[1] - maxOutput
+			if start < 0 {
+				start = 0
+			}
+			out := string(e.buf[start : from+m[1]])
+			var groups []string
+			var matched []bool
+			for i := 0; i < len(m); i += 2 {
+				if m[i] >= 0 {
+					groups = append(groups, string(e.buf[from+m[i]:from+m[i+1]]))
+				} else {
+					groups = append(groups, "")
+				}
+				matched = append(matched, m[i] >= 0)
+			}
+			e.buf = append([]byte(nil), e.buf[from+m[1]:]...)
+			return out, groups, matched, nil
+		}
+		searched = len(e.buf)
+		if len(e.buf) > 2*maxOutput {
+			over := len(e.buf) - maxOutput
+			e.buf = append(e.buf[:0], e.buf[over:]...)
+			searched -= over
+		}
+		n, err := e.conn.Read(buf)
+		if n > 0 {
+			e.buf = append(e.buf, buf[:n]...)
+			if e.Echo != nil {
+				e.Echo.Write(buf[:n])
+			}
+			continue
+		}
+		if err != nil {
+			if isTimeout(err) {
+				tail := e.buf
+				if len(tail) > tailLength {
+					tail = tail[len(tail)-tailLength:]
+				}
+				return "", nil, nil, &TimeoutError{Pattern: re.String(), Timeout: timeout, Output: string(tail)}
+			}
+			return "", nil, nil, err
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	if os.IsTimeout(err) {
+		return true
+	}
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// Expect reads from the connection until the output matches the regular
+// expression, or fails after the timeout (0 waits forever), returning the
+// output up to the end of the match. It reads a byte at a time so nothing
+// after the match is consumed, and only finds matches within the last 4KB
+// read; use an Expecter to wait for a series of patterns more efficiently.
+func Expect(conn io.Reader, pattern string, timeout time.Duration) (string, error) {
+	e := &Expecter{conn: conn, oneByte: true}
+	out, _, err := e.Expect(pattern, timeout)
+	return out, err
+}
@@ -0,0 +1,101 @@
+package console
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpectAny(t *testing.T) {
+	for _, test := range []struct {
+		output   string
+		patterns []string
+		index    int
+		match    string
+		out      string
+	}{
+		{"booting\nlogin: ", []string{"login: ", "(Kernel) panic", "BUG:"}, 0, "login: ", "booting\nlogin: "},
+		// Groups in earlier patterns don't shift the later ones.
+		{"BUG: oops\nlogin: ", []string{"log(in): ", "(Kernel) panic", "BUG:"}, 2, "BUG:", "BUG:"},
+		{"Kernel panic - not syncing", []string{"(log)(in): ", "(Kernel) (panic)", "BUG:"}, 1, "Kernel panic", "Kernel panic"},
+		// The earliest match in the output wins, then the first pattern.
+		{"abc", []string{"c", "b", "ab"}, 2, "ab", "ab"},
+		// A pattern matching empty output still counts.
+		{"abc", []string{"z", "q*"}, 1, "", ""},
+	} {
+		e := NewExpecter(strings.NewReader(test.output))
+		out, i, match, err := e.ExpectAny(test.patterns, 0)
+		if err != nil || i != test.index || match != test.match || out != test.out {
+			t.Errorf("%q %q: got %q, %d, %q, %v, want %q, %d, %q", test.output, test.patterns, out, i, match, err, test.out, test.index, test.match)
+		}
+	}
+
+	e := NewExpecter(strings.NewReader("no match"))
+	if _, _, _, err := e.ExpectAny([]string{"login: "}, 0); err != io.EOF {
+		t.Errorf("at EOF got %v", err)
+	}
+	if _, _, _, err := e.ExpectAny([]string{"("}, 0); err == nil {
+		t.Errorf("bad pattern accepted")
+	}
+}
+
+func TestExpectTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go server.Write([]byte("partial output"))
+	e := NewExpecter(client)
+	_, _, _, err := e.ExpectAny([]string{"login: "}, 50*time.Millisecond)
+	terr, ok := err.(*TimeoutError)
+	if !ok || terr.Output != "partial output" {
+		t.Errorf("got %v, want a timeout with the output", err)
+	}
+}
+
+// chunkReader reads a lot of output, in chunks as a console would send it,
+// and then the end.
+type chunkReader struct {
+	left, pos int
+	end       string
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.left == 0 {
+		if r.end == "" {
+			return 0, io.EOF
+		}
+		n := copy(p, r.end)
+		r.end = r.end[n:]
+		return n, nil
+	}
+	if len(p) > r.left {
+		p = p[:r.left]
+	}
+	for i := range p {
+		p[i] = "build output\n"[r.pos%13]
+		r.pos++
+	}
+	r.left -= len(p)
+	return len(p), nil
+}
+
+func TestExpectLongOutput(t *testing.T) {
+	// The output is searched once, not again after every read, and only its
+	// end is kept.
+	e := NewExpecter(&chunkReader{left: 13 * 400000, end: "login: "})
+	out, i, match, err := e.ExpectAny([]string{"login: ", "Kernel panic"}, 0)
+	if err != nil || i != 0 || match != "login: " {
+		t.Fatalf("got %d, %q, %v", i, match, err)
+	}
+	if len(out) > maxOutput || !strings.HasSuffix(out, "build output\nlogin: ") {
+		t.Errorf("got %d bytes of output ending %q", len(out), out[len(out)-20:])
+	}
+
+	// A match split across reads is found.
+	e = NewExpecter(&chunkReader{left: 4095 + 13, end: "login: "})
+	if out, _, err := e.Expect("output\nlogin: ", 0); err != nil || !strings.HasSuffix(out, "output\nlogin: ") {
+		t.Errorf("split match got %q, %v", out, err)
+	}
+}
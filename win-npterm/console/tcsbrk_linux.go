@@ -0,0 +1,8 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le && !ppc64 && !ppc64le
+// +build linux,!mips,!mipsle,!mips64,!mips64le,!ppc64,!ppc64le
+
+package console
+
+// ioctlSendBreak is TCSBRK as in asm-generic, which x86, ARM, RISC-V, s390x
+// and LoongArch use.
+const ioctlSendBreak = 0x5409
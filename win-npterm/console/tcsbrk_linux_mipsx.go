@@ -0,0 +1,8 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+// +build linux
+// +build mips mipsle mips64 mips64le
+
+package console
+
+// ioctlSendBreak is TCSBRK on MIPS.
+const ioctlSendBreak = 0x5405
@@ -0,0 +1,8 @@
+//go:build linux && (ppc64 || ppc64le)
+// +build linux
+// +build ppc64 ppc64le
+
+package console
+
+// ioctlSendBreak is TCSBRK on POWER.
+const ioctlSendBreak = 0x2000741d
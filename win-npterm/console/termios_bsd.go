@@ -0,0 +1,26 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package console
+
+import (
+	"syscall"
+	"time"
+)
+
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
+
+// SendBreak sends a BREAK of 0.4 seconds, as tcsendbreak(3) does.
+func SendBreak(fd uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCSBRK, 0); errno != 0 {
+		return errno
+	}
+	time.Sleep(400 * time.Millisecond)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCCBRK, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
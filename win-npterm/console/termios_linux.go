@@ -0,0 +1,19 @@
+package console
+
+import "syscall"
+
+// ioctlSendBreak, TCSBRK, isn't in syscall and varies by architecture, so
+// it's defined in the tcsbrk_linux files.
+const (
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+)
+
+// SendBreak sends a BREAK of the default length (0.25 to 0.5 seconds).
+func SendBreak(fd uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlSendBreak, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package console
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// openPair opens a new pseudo terminal, returning its master and the path of
+// its slave.
+func openPair(t *testing.T) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo terminals: %v", err)
+	}
+	var n, unlock uint32
+	err = control(master, func(fd uintptr) error {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+			return errno
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+			return errno
+		}
+		return nil
+	})
+	if err != nil {
+		master.Close()
+		t.Skipf("setting up the pseudo terminal: %v", err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+// control runs f on the file's descriptor without putting it in blocking
+// mode, as Fd would, so read deadlines still work.
+func control(f *os.File, fn func(fd uintptr) error) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ferr error
+	if err := rc.Control(func(fd uintptr) { ferr = fn(fd) }); err != nil {
+		return err
+	}
+	return ferr
+}
+
+func TestOpenPTYRaw(t *testing.T) {
+	master, path := openPair(t)
+	defer master.Close()
+	conn, err := openPTY(path)
+	if err != nil {
+		t.Skipf("opening %s: %v", path, err)
+	}
+	defer conn.Close()
+
+	var termios syscall.Termios
+	slave := conn.(*os.File)
+	if err := control(slave, func(fd uintptr) error { return ioctlTermios(fd, ioctlGetTermios, &termios) }); err != nil {
+		t.Fatal(err)
+	}
+	if termios.Lflag&(syscall.ICANON|syscall.ECHO|syscall.ISIG) != 0 || termios.Oflag&syscall.OPOST != 0 ||
+		termios.Iflag&(syscall.IXON|syscall.ICRNL) != 0 || termios.Cflag&syscall.CSIZE != syscall.CS8 {
+		t.Errorf("not raw: %+v", termios)
+	}
+
+	// Control characters and carriage returns arrive as they were sent, without
+	// waiting for a newline.
+	deadline := time.Now().Add(5 * time.Second)
+	slave.SetReadDeadline(deadline)
+	master.SetReadDeadline(deadline)
+	sent := "\x03\x11x\r"
+	if _, err := master.Write([]byte(sent)); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(sent))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != sent {
+		t.Errorf("read %q, want %q", got, sent)
+	}
+	if _, err := conn.Write([]byte("out\n")); err != nil {
+		t.Fatal(err)
+	}
+	echo := make([]byte, 16)
+	n, err := master.Read(echo)
+	if err != nil || string(echo[:n]) != "out\n" {
+		t.Errorf("master read %q, %v, want %q without echo or CRLF", echo[:n], err, "out\n")
+	}
+}
@@ -0,0 +1,44 @@
+//go:build !windows
+// +build !windows
+
+package console
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// MakeRaw puts the terminal in raw mode, as cfmakeraw(3): input is passed on
+// a byte at a time without echo or signals, and output as it is. It returns
+// the previous mode for RestoreTerminal.
+func MakeRaw(fd uintptr) (*syscall.Termios, error) {
+	var termios syscall.Termios
+	if err := ioctlTermios(fd, ioctlGetTermios, &termios); err != nil {
+		return nil, err
+	}
+	saved := termios
+	termios.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	termios.Oflag &^= syscall.OPOST
+	termios.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	termios.Cflag &^= syscall.CSIZE | syscall.PARENB
+	termios.Cflag |= syscall.CS8
+	termios.Cc[syscall.VMIN] = 1
+	termios.Cc[syscall.VTIME] = 0
+	if err := ioctlTermios(fd, ioctlSetTermios, &termios); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// RestoreTerminal puts the terminal back in a mode returned by MakeRaw.
+func RestoreTerminal(fd uintptr, termios *syscall.Termios) error {
+	return ioctlTermios(fd, ioctlSetTermios, termios)
+}
+
+func ioctlTermios(fd uintptr, request uintptr, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/rn/utils/win-npterm/console"
+)
+
+// openPTYPair opens a new pseudo terminal, returning its master and its slave
+// opened as a pty:// target is.
+func openPTYPair(t *testing.T) (*os.File, io.ReadWriteCloser) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo terminals: %v", err)
+	}
+	var n, unlock uint32
+	rc, err := master.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Control(func(fd uintptr) {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+			err = errno
+		} else if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+			err = errno
+		}
+	})
+	if err != nil {
+		master.Close()
+		t.Skipf("setting up the pseudo terminal: %v", err)
+	}
+	conn, err := console.Dial("pty", fmt.Sprintf("/dev/pts/%d", n))
+	if err != nil {
+		master.Close()
+		t.Skipf("opening the pseudo terminal: %v", err)
+	}
+	return master, conn
+}
+
+func TestCloseAfterBreak(t *testing.T) {
+	master, conn := openPTYPair(t)
+	defer master.Close()
+
+	if err := sendBreak(conn); err != nil {
+		t.Errorf("sendBreak: %v", err)
+	}
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, conn)
+		close(copied)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	// Quitting closes the console, which has to interrupt the Read.
+	conn.Close()
+	select {
+	case <-copied:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("reading the console hung after a BREAK and Close")
+	}
+}
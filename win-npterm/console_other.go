@@ -0,0 +1,98 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/rn/utils/win-npterm/console"
+)
+
+// There's no default console outside of Windows.
+const defaultTarget = ""
+
+// Terminals outside of Windows handle VT sequences already, but stdin has to
+// be put in raw mode so keys, Ctrl-C included, go to the guest as they are
+// typed rather than a line at a time and without local echo.
+
+var (
+	consoleLock  sync.Mutex
+	savedTermios *syscall.Termios
+)
+
+func configureConsole() error {
+	consoleLock.Lock()
+	defer consoleLock.Unlock()
+
+	saved, err := console.MakeRaw(os.Stdin.Fd())
+	if err == syscall.ENOTTY || err == syscall.EINVAL {
+		// Not a terminal, such as when the input is piped in.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	savedTermios = saved
+
+	// Deferred calls don't run when killed, so restore the terminal here.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		sig := <-sigs
+		restoreConsole()
+		fmt.Printf("Exiting on %v\n", sig)
+		os.Exit(1)
+	}()
+	return nil
+}
+
+// restoreConsole puts stdin back in the mode it was in before
+// configureConsole, if it changed it. It is safe to call more than once.
+func restoreConsole() {
+	consoleLock.Lock()
+	defer consoleLock.Unlock()
+
+	if savedTermios == nil {
+		return
+	}
+	console.RestoreTerminal(os.Stdin.Fd(), savedTermios)
+	savedTermios = nil
+}
+
+// sendBreak sends a serial BREAK, which only PTYs and other terminal devices
+// can. Control rather than Fd keeps the file non-blocking, so closing it
+// still interrupts the Read waiting for output.
+func sendBreak(conn io.ReadWriteCloser) error {
+	f, ok := conn.(*os.File)
+	if !ok {
+		return fmt.Errorf("BREAK is only supported on PTYs")
+	}
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if cerr := rc.Control(func(fd uintptr) { err = console.SendBreak(fd) }); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// terminalSize returns the width and height of the terminal, or 80x24 if
+// stdout isn't a terminal.
+func terminalSize() (int, int) {
+	var ws struct {
+		row, col, xpixel, ypixel uint16
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdout.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 || ws.col == 0 || ws.row == 0 {
+		return 80, 24
+	}
+	return int(ws.col), int(ws.row)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/Azure/go-ansiterm/winterm"
+)
+
+const defaultTarget = `\\.\pipe\dockerMobyLinuxVM-com1`
+
+// Some of the code below is copied and modified from:
+// https://github.com/moby/moby/blob/master/pkg/term/term_windows.go
+const (
+	// https://msdn.microsoft.com/en-us/library/windows/desktop/ms683167(v=vs.85).aspx
+	enableProcessedInput            = 0x0001
+	enableLineInput                 = 0x0002
+	enableEchoInput                 = 0x0004
+	enableVirtualTerminalInput      = 0x0200
+	enableVirtualTerminalProcessing = 0x0004
+	disableNewlineAutoReturn        = 0x0008
+)
+
+// The modes of stdin, stdout and stderr before configureConsole, for those
+// which are consoles. consoleLock guards them, as goroutines panicking at
+// once can restore them at once.
+var (
+	consoleLock       sync.Mutex
+	savedConsoleModes = map[*os.File]uint32{}
+)
+
+func configureConsole() error {
+	consoleLock.Lock()
+	defer consoleLock.Unlock()
+
+	// Turn on VT handling on all std handles, if possible. This might
+	// fail on older windows version, but we'll ignore that for now
+	// Also disable local echo, line editing and Ctrl-C handling so keys
+	// go to the VM as they are typed.
+
+	configure := func(f *os.File, name string, flags, clear uint32) {
+		mode, err := winterm.GetConsoleMode(f.Fd())
+		if err != nil {
+			return
+		}
+		savedConsoleModes[f] = mode
+		if err = winterm.SetConsoleMode(f.Fd(), mode&^clear|flags); err != nil {
+			fmt.Printf("VT Processing is not supported on %s\n", name)
+		}
+	}
+	configure(os.Stdin, "stdin", enableVirtualTerminalInput, enableProcessedInput|enableLineInput|enableEchoInput)
+	configure(os.Stdout, "stdout", enableVirtualTerminalProcessing|disableNewlineAutoReturn, 0)
+	configure(os.Stderr, "stderr", enableVirtualTerminalProcessing|disableNewlineAutoReturn, 0)
+	return nil
+}
+
+// restoreConsole puts the consoles back in the modes they were in before
+// configureConsole. It is safe to call more than once.
+func restoreConsole() {
+	consoleLock.Lock()
+	defer consoleLock.Unlock()
+
+	for f, mode := range savedConsoleModes {
+		winterm.SetConsoleMode(f.Fd(), mode)
+		delete(savedConsoleModes, f)
+	}
+}
+
+func sendBreak(conn io.ReadWriteCloser) error {
+	return fmt.Errorf("BREAK is not supported on named pipes")
+}
+
+// terminalSize returns the width and height of the console window, or 80x24
+// if stdout isn't a console.
+func terminalSize() (int, int) {
+	info, err := winterm.GetConsoleScreenBufferInfo(os.Stdout.Fd())
+	if err != nil {
+		return 80, 24
+	}
+	return int(info.Window.Right-info.Window.Left) + 1, int(info.Window.Bottom-info.Window.Top) + 1
+}
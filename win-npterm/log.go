@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logOptions are how the session is logged.
+type logOptions struct {
+	// timestamps is "wall" or "relative" (to connecting) to prefix each line
+	// with the time, or "none".
+	timestamps string
+	// strip removes ANSI escapes and carriage returns.
+	strip  bool
+	append bool
+	// input logs keyboard input too, with "<" marking input lines and ">"
+	// output lines.
+	input bool
+	// A log bigger than rotateSize (if not 0), or older than rotateEvery (if
+	// not 0), is renamed with the time appended, gzipped if compress is set,
+	// and a new log started.
+	rotateSize  int64
+	rotateEvery time.Duration
+	compress    bool
+}
+
+// The direction of logged data.
+const (
+	logOutput = '>'
+	logInput  = '<'
+	logNotice = '-'
+)
+
+// logger writes the session log.
+type logger struct {
+	name   string
+	opts   logOptions
+	start  time.Time
+	f      *os.File
+	size   int64
+	opened time.Time
+	// lineStart is whether the next byte starts a line and direction the
+	// direction of the current line.
+	lineStart bool
+	direction byte
+	// strippers strip each direction's escapes, as input and output
+	// interleave mid escape.
+	strippers map[byte]*escapeStripper
+	// compressing is the rotated logs being gzipped.
+	compressing sync.WaitGroup
+}
+
+// newLogger opens the log, truncating it unless appending or told not to.
+// Relative timestamps are relative to start.
+func newLogger(name string, opts logOptions, truncate bool, start time.Time) (*logger, error) {
+	l := &logger{name: name, opts: opts, start: start, lineStart: true, strippers: map[byte]*escapeStripper{}}
+	if err := l.open(truncate && !opts.append); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *logger) open(truncate bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if truncate {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(l.name, flags, 0666)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size, l.opened = f, fi.Size(), time.Now()
+	return nil
+}
+
+// close closes the log, once any rotated logs are compressed.
+func (l *logger) close() error {
+	if !l.lineStart {
+		l.f.WriteString("\n")
+	}
+	err := l.f.Close()
+	l.compressing.Wait()
+	return err
+}
+
+// write logs data going in the direction.
+func (l *logger) write(direction byte, p []byte) error {
+	if direction == logInput {
+		// Enter sends a carriage return.
+		p = bytes.Replace(p, []byte("\r"), []byte("\n"), -1)
+	}
+	if l.opts.strip {
+		s := l.strippers[direction]
+		if s == nil {
+			s = &escapeStripper{}
+			l.strippers[direction] = s
+		}
+		p = s.strip(p)
+	}
+	if len(p) == 0 {
+		return nil
+	}
+	var out bytes.Buffer
+	if direction != l.direction && !l.lineStart {
+		out.WriteByte('\n')
+		l.lineStart = true
+	}
+	l.direction = direction
+	for _, b := range p {
+		if l.lineStart {
+			l.writePrefix(&out, direction)
+			l.lineStart = false
+		}
+		out.WriteByte(b)
+		if b == '\n' {
+			l.lineStart = true
+		}
+	}
+	if err := l.rotateIfNeeded(); err != nil {
+		return err
+	}
+	n, err := l.f.Write(out.Bytes())
+	l.size += int64(n)
+	return err
+}
+
+// mark logs a message from npterm on a line of its own.
+func (l *logger) mark(msg string) error {
+	return l.write(logNotice, []byte("[npterm: "+msg+"]\n"))
+}
+
+func (l *logger) writePrefix(out *bytes.Buffer, direction byte) {
+	switch l.opts.timestamps {
+	case "wall":
+		out.WriteString(time.Now().Format("2006-01-02 15:04:05.000 "))
+	case "relative":
+		fmt.Fprintf(out, "[%10.3f] ", time.Since(l.start).Seconds())
+	}
+	if l.opts.input && direction != logNotice {
+		out.WriteByte(direction)
+		out.WriteByte(' ')
+	}
+}
+
+// escapeStripper removes ANSI escapes from a stream, carrying escapes split
+// across writes over.
+type escapeStripper struct {
+	escape []byte
+}
+
+// strip returns the data without ANSI escapes, carriage returns and other
+// control characters than newlines, tabs and backspaces.
+func (s *escapeStripper) strip(p []byte) []byte {
+	var out []byte
+	for _, b := range p {
+		if s.escape != nil {
+			s.escape = append(s.escape, b)
+			if escapeDone(s.escape) {
+				s.escape = nil
+			}
+			continue
+		}
+		switch {
+		case b == 0x1b:
+			s.escape = []byte{b}
+		case b < ' ' && b != '\n' && b != '\t' && b != '\b', b == 0x7f:
+		default:
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// escapeDone reports whether the escape sequence is complete: a CSI sequence
+// (ESC [) ends with a byte from @ to ~, an OSC, DCS or other string (ESC ],
+// P, X, ^ or _) with BEL or ESC \, and any other escape with the first byte
+// after any intermediate bytes (space to /), such as the B of ESC ( B.
+func escapeDone(esc []byte) bool {
+	if len(esc) < 2 {
+		return false
+	}
+	switch esc[1] {
+	case '[':
+		last := esc[len(esc)-1]
+		return len(esc) > 2 && last >= '@' && last <= '~'
+	case ']', 'P', 'X', '^', '_':
+		last := esc[len(esc)-1]
+		return last == 0x07 || (last == '\\' && esc[len(esc)-2] == 0x1b) || len(esc) > 4096
+	}
+	last := esc[len(esc)-1]
+	return last < ' ' || last > '/'
+}
+
+func (l *logger) rotateIfNeeded() error {
+	if (l.opts.rotateSize <= 0 || l.size < l.opts.rotateSize) &&
+		(l.opts.rotateEvery <= 0 || time.Since(l.opened) < l.opts.rotateEvery) {
+		return nil
+	}
+	if l.size == 0 {
+		return nil
+	}
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	rotated := l.name + "." + time.Now().Format("20060102-150405")
+	for i := 1; fileExists(rotated) || fileExists(rotated+".gz"); i++ {
+		rotated = fmt.Sprintf("%s.%s-%d", l.name, time.Now().Format("20060102-150405"), i)
+	}
+	if err := os.Rename(l.name, rotated); err != nil {
+		return err
+	}
+	if l.opts.compress {
+		// Compressing a big log takes a while, which the console shouldn't
+		// wait for. If that fails the rotated log is just left uncompressed.
+		l.compressing.Add(1)
+		go func() {
+			defer restoreOnPanic()
+			defer l.compressing.Done()
+			compressLog(rotated)
+		}()
+	}
+	return l.open(true)
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// compressLog gzips the rotated log, replacing it with name.gz.
+func compressLog(name string) {
+	in, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, err := os.Create(name + ".gz")
+	if err != nil {
+		return
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return
+	}
+	os.Remove(name)
+}
+
+// parseSize returns the size given as a number of bytes, optionally followed
+// by K, M or G.
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("must be a number of bytes, optionally followed by K, M or G")
+	}
+	return n * mult, nil
+}
+
+// formatSize returns the size in bytes, K, M or G, as parseSize takes.
+func formatSize(n int64) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%d", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1fK", float64(n)/1024)
+	case n < 1024*1024*1024:
+		return fmt.Sprintf("%.1fM", float64(n)/(1024*1024))
+	}
+	return fmt.Sprintf("%.1fG", float64(n)/(1024*1024*1024))
+}
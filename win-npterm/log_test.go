@@ -0,0 +1,110 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoggerStripsEachDirection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "npterm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "console.log")
+	l, err := newLogger(name, logOptions{timestamps: "none", strip: true, input: true}, true, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An escape split across writes in one direction doesn't swallow the
+	// other direction's data.
+	l.write(logOutput, []byte("red \x1b[3"))
+	l.write(logInput, []byte("ls\r"))
+	l.write(logOutput, []byte("1mtext\x1b[0m\r\n"))
+	l.write(logInput, []byte("\x1b[A\r"))
+	// Escapes other than CSI and strings can have intermediate bytes, as
+	// the charset selection tput sgr0 sends does.
+	l.write(logOutput, []byte("\x1b(B\x1b[m\x1b("))
+	l.write(logOutput, []byte("0sgr0 \x1b7saved\x1b8 \x1b#8ok\r\n"))
+	if err := l.close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "> red \n< ls\n> text\n< \n> sgr0 saved ok\n"
+	if string(data) != want {
+		t.Errorf("log %q, want %q", data, want)
+	}
+}
+
+func TestLoggerRotateCompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "npterm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "console.log")
+	l, err := newLogger(name, logOptions{timestamps: "none", rotateSize: 10, compress: true}, true, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.write(logOutput, []byte("first line\n"))
+	l.write(logOutput, []byte("second\n"))
+	// close waits for the rotated log to be compressed.
+	if err := l.close(); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := filepath.Glob(name + ".*")
+	if err != nil || len(rotated) != 1 || !strings.HasSuffix(rotated[0], ".gz") {
+		t.Fatalf("rotated logs %v, %v", rotated, err)
+	}
+	f, err := os.Open(rotated[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadAll(zr); err != nil || string(data) != "first line\n" {
+		t.Errorf("rotated log %q, %v", data, err)
+	}
+	if data, err := ioutil.ReadFile(name); err != nil || string(data) != "second\n" {
+		t.Errorf("log %q, %v", data, err)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for _, test := range []struct {
+		s    string
+		want int64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"512", 512, true},
+		{"64K", 64 << 10, true},
+		{"10M", 10 << 20, true},
+		{"2G", 2 << 30, true},
+		{"-1", 0, false},
+		{"1T", 0, false},
+		{"K", 0, false},
+	} {
+		got, err := parseSize(test.s)
+		if got != test.want || (err == nil) != test.ok {
+			t.Errorf("parseSize(%q) = %d, %v", test.s, got, err)
+		}
+	}
+	for n, want := range map[int64]string{100: "100", 1536: "1.5K", 10 << 20: "10.0M", 3 << 30: "3.0G"} {
+		if got := formatSize(n); got != want {
+			t.Errorf("formatSize(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
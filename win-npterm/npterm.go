@@ -1,10 +1,20 @@
-// A simple terminal program which connects to a named pipe (on windows).
-// Handy for serial console on Hyper-V VMs.
+// A simple terminal program which connects to a named pipe (on windows),
+// a Unix domain socket, a TCP port or a PTY.
+// Handy for serial console on Hyper-V, crosvm, QEMU and Firecracker VMs.
 // Build with:
 // GOOS=windows GOARCH=amd64 go build
+// GOOS=linux GOARCH=amd64 go build
 //
 // Use:
-// - npterm <pipe name>
+// - npterm [options] <target>
+// - npterm replay [options] <file.cast>
+// - npterm serve [options] <target>
+// - npterm script [options] <script> <target>
+// - npterm wait [options] <target>
+// - npterm send [options] <target> <file>...
+// - npterm receive [options] <target> [<file>]
+// where target is npipe://./pipe/name, unix:///path, tcp://host:port,
+// pty:///dev/pts/N or a bare pipe name, path or host:port.
 //
 package main
 
@@ -12,120 +22,202 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"net"
 	"os"
 	"time"
 
-	"github.com/Azure/go-ansiterm/winterm"
-	"github.com/Microsoft/go-winio"
-)
-
-var pipeName = "\\\\.\\pipe\\dockerMobyLinuxVM-com1"
-
-// Some of the code below is copied and modified from:
-// https://github.com/moby/moby/blob/master/pkg/term/term_windows.go
-const (
-	// https://msdn.microsoft.com/en-us/library/windows/desktop/ms683167(v=vs.85).aspx
-	enableVirtualTerminalInput      = 0x0200
-	enableVirtualTerminalProcessing = 0x0004
-	disableNewlineAutoReturn        = 0x0008
+	"github.com/rn/utils/win-npterm/console"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			replayMain(os.Args[2:])
+			return
+		case "serve":
+			serveMain(os.Args[2:])
+			return
+		case "script":
+			scriptMain(os.Args[2:])
+			return
+		case "wait":
+			waitMain(os.Args[2:])
+			return
+		case "send", "receive":
+			transferMain(os.Args[1], os.Args[2:])
+			return
+		}
+	}
+
 	filePtr := flag.String("file", "", "Optionally log the output to a file")
+	logTimePtr := flag.String("log-time", "none", "Prefix each logged line with the time: none, wall or relative (to connecting)")
+	logStripPtr := flag.Bool("log-strip", false, "Strip ANSI escapes and carriage returns from the log")
+	logInputPtr := flag.Bool("log-input", false, "Log keyboard input too, marking input lines with < and output lines with >")
+	appendPtr := flag.Bool("append", false, "Append to the log instead of truncating it")
+	rotateSizePtr := flag.String("rotate-size", "0", "Rotate the log when it gets bigger than this, e.g. 10M, 0 for never")
+	rotateEveryPtr := flag.Duration("rotate-every", 0, "Rotate the log when it gets older than this, e.g. 24h, 0 for never")
+	compressPtr := flag.Bool("compress", false, "Gzip rotated logs")
+	recordPtr := flag.String("record", "", "Record the session, with timing, to an asciicast file for npterm replay or asciinema")
+	recordInputPtr := flag.Bool("record-input", false, "Record keyboard input too")
+	escapePtr := flag.String("escape", "^]", "Escape character for commands, ^X, a single character or none")
+	readOnlyPtr := flag.Bool("read-only", false, "Only watch the console, sending no input other than escape commands")
+	reconnectPtr := flag.Bool("reconnect", false, "Reconnect when the connection is closed, such as when the VM reboots")
+	reconnectTimeoutPtr := flag.Duration("reconnect-timeout", 0, "How long to wait for the target to reappear when reconnecting, 0 for ever")
+	downloadDirPtr := flag.String("download-dir", ".", "Directory to put files received with XMODEM, YMODEM or ZMODEM in")
+	zmodemAutoPtr := flag.Bool("zmodem-auto", true, "Receive files when sz or another ZMODEM sender starts on the console")
+	retryFlag := retryFlags(flag.CommandLine)
 	flag.Parse()
 
+	escape, err := parseEscape(*escapePtr)
+	if err != nil {
+		fmt.Printf("Escape character %s: %v\n", *escapePtr, err)
+		os.Exit(1)
+	}
+
+	switch *logTimePtr {
+	case "none", "wall", "relative":
+	default:
+		fmt.Printf("Unknown log time %s\n", *logTimePtr)
+		os.Exit(1)
+	}
+	rotateSize, err := parseSize(*rotateSizePtr)
+	if err != nil {
+		fmt.Printf("Rotate size %s: %v\n", *rotateSizePtr, err)
+		os.Exit(1)
+	}
+	logOpts := logOptions{
+		timestamps:  *logTimePtr,
+		strip:       *logStripPtr,
+		append:      *appendPtr,
+		input:       *logInputPtr,
+		rotateSize:  rotateSize,
+		rotateEvery: *rotateEveryPtr,
+		compress:    *compressPtr,
+	}
+
+	target := defaultTarget
 	remArgs := flag.Args()
 	if len(remArgs) != 0 {
-		pipeName = remArgs[0]
+		target = remArgs[0]
+	}
+	if target == "" {
+		fmt.Println("Please specify a target to connect to")
+		os.Exit(1)
+	}
+	scheme, addr, err := console.ParseTarget(target)
+	if err != nil {
+		fmt.Printf("Target %s: %v\n", target, err)
+		os.Exit(1)
 	}
-	fmt.Println("Connecting to:", string(pipeName))
+	fmt.Println("Connecting to:", target)
 
-	out := io.MultiWriter(os.Stdout)
+	r := retryFlag()
+	c, err := console.Connect(scheme, addr, r, func(format string, args ...interface{}) {
+		fmt.Printf(format+"\n", args...)
+	}, func() bool { return false })
+	if err != nil {
+		fmt.Printf("Connect to console: %v\n", err)
+		return
+	}
+
+	logName := *filePtr
+	if logName == "" {
+		logName = "npterm.log"
+	}
+	s := newSession(c, escape, *escapePtr, logName, logOpts)
+	s.readOnly = *readOnlyPtr
+	s.downloadDir = *downloadDirPtr
+	s.zmodemAuto = *zmodemAutoPtr
 	if *filePtr != "" {
 		fmt.Println("Logging to:", *filePtr)
-		if f, err := os.Create(*filePtr); err == nil {
-			out = io.MultiWriter(os.Stdout, f)
-		} else {
+		if err := s.startLog(true); err != nil {
 			panic(err)
 		}
 	}
 
-	if err := hypervConfigureConsole(); err != nil {
-		fmt.Printf("Configure Console: %v\n", err)
-
-	}
-	defer hypervRestoreConsole()
-
-	var c net.Conn
-	var err error
-	for count := 1; count < 500; count++ {
-		c, err = winio.DialPipe(pipeName, nil)
-		if err != nil {
-			// Argh, different Windows versions seem to
-			// return different errors and we can't easily
-			// catch the error. On some versions it is
-			// winio.ErrTimeout...
-			// Instead poll 100 times and then error out
-			fmt.Printf("Connect to console: %v\n", err)
-			time.Sleep(10 * 1000 * 1000 * time.Nanosecond)
-			continue
+	if *recordPtr != "" {
+		fmt.Println("Recording to:", *recordPtr)
+		if s.record, err = newRecorder(*recordPtr, target, *recordInputPtr); err != nil {
+			panic(err)
 		}
-		break
-	}
-	if err != nil {
-		fmt.Printf("Connect to console: %v\n", err)
-		return
 	}
-	defer c.Close()
 
 	fmt.Println("Connected")
-	go io.Copy(c, os.Stdin)
-
-	_, err = io.Copy(out, c)
-	if err != nil {
-		fmt.Printf("Copy to console: %v\n", err)
+	if escape != 0 {
+		fmt.Printf("Escape character is %s, %s ? for help\n", *escapePtr, *escapePtr)
 	}
 
-}
+	if err := configureConsole(); err != nil {
+		fmt.Printf("Configure Console: %v\n", err)
 
-var (
-	hypervStdinMode  uint32
-	hypervStdoutMode uint32
-	hypervStderrMode uint32
-)
+	}
+	defer restoreConsole()
 
-func hypervConfigureConsole() error {
-	// Turn on VT handling on all std handles, if possible. This might
-	// fail on older windows version, but we'll ignore that for now
-	// Also disable local echo
+	go s.forwardInput(os.Stdin)
 
-	fd := os.Stdin.Fd()
-	if hypervStdinMode, err := winterm.GetConsoleMode(fd); err == nil {
-		if err = winterm.SetConsoleMode(fd, hypervStdinMode|enableVirtualTerminalInput); err != nil {
-			fmt.Println("VT Processing is not supported on stdin")
+	// A reboot can take much longer than the target takes to appear at
+	// first, so reconnecting has its own timeout.
+	reconnect := r
+	reconnect.Timeout = *reconnectTimeoutPtr
 
+	for {
+		_, err = io.Copy(s, c)
+		c.Close()
+		if s.closed() || !*reconnectPtr {
+			break
+		}
+		if err == nil {
+			err = io.EOF
+		}
+		s.mark("Disconnected at %s (%v), reconnecting", time.Now().Format("15:04:05"), err)
+		lost := time.Now()
+		c, err = console.Connect(scheme, addr, reconnect, s.notice, s.closed)
+		if err != nil {
+			if !s.closed() {
+				s.mark("Reconnect: %v", err)
+			}
+			err = nil
+			break
 		}
+		if !s.setConn(c) {
+			break
+		}
+		s.mark("Reconnected at %s, after %v", time.Now().Format("15:04:05"), time.Since(lost).Round(time.Millisecond))
+	}
+	restoreConsole()
+	s.closeLog()
+	if err != nil && !s.closed() {
+		fmt.Printf("Copy to console: %v\n", err)
 	}
 
-	fd = os.Stdout.Fd()
-	if hypervStdoutMode, err := winterm.GetConsoleMode(fd); err == nil {
-		if err = winterm.SetConsoleMode(fd, hypervStdoutMode|enableVirtualTerminalProcessing|disableNewlineAutoReturn); err != nil {
-			fmt.Println("VT Processing is not supported on stdout")
-		}
+}
+
+// restoreOnPanic restores the terminal and panics again if the goroutine
+// deferring it panics. A panic outside the main goroutine exits without
+// running main's deferred calls, so every goroutine defers it first.
+func restoreOnPanic() {
+	if r := recover(); r != nil {
+		restoreConsole()
+		panic(r)
 	}
+}
 
-	fd = os.Stderr.Fd()
-	if hypervStderrMode, err := winterm.GetConsoleMode(fd); err == nil {
-		if err = winterm.SetConsoleMode(fd, hypervStderrMode|enableVirtualTerminalProcessing|disableNewlineAutoReturn); err != nil {
-			fmt.Println("VT Processing is not supported on stderr")
-		}
+// retryFlags adds the flags configuring retry to the flag set, returning a
+// func giving the retry they configure once parsed.
+func retryFlags(flags *flag.FlagSet) func() console.Retry {
+	backoff := backoffFlags(flags)
+	timeout := flags.Duration("timeout", 5*time.Second, "How long to wait for the target to appear or be free, 0 for ever")
+	return func() console.Retry {
+		return backoff(*timeout)
 	}
-	return nil
 }
 
-func hypervRestoreConsole() {
-	winterm.SetConsoleMode(os.Stdin.Fd(), hypervStdinMode)
-	winterm.SetConsoleMode(os.Stdout.Fd(), hypervStdoutMode)
-	winterm.SetConsoleMode(os.Stderr.Fd(), hypervStderrMode)
+// backoffFlags is retryFlags without -timeout, for subcommands with a
+// timeout of their own.
+func backoffFlags(flags *flag.FlagSet) func(timeout time.Duration) console.Retry {
+	backoff := flags.Duration("backoff", 10*time.Millisecond, "Delay before retrying to connect, doubled on each retry")
+	maxBackoff := flags.Duration("max-backoff", time.Second, "Longest delay between retries")
+	return func(timeout time.Duration) console.Retry {
+		return console.Retry{Backoff: *backoff, MaxBackoff: *maxBackoff, Timeout: timeout}
+	}
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"math"
+	"os"
+	"time"
+	"unicode/utf8"
+)
+
+// Sessions are recorded in the asciicast v2 format used by asciinema: a JSON
+// header line followed by a JSON array per event, [time, code, data], time
+// being seconds since the start and code "o" for output, "i" for input and
+// "m" for a marker.
+// https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md
+
+// castHeader is the first line of an asciicast.
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// recorder writes an asciicast of the session.
+type recorder struct {
+	f     *os.File
+	w     *bufio.Writer
+	start time.Time
+	input bool
+	// pending holds the start of a UTF-8 sequence split across writes, per
+	// event code, as the data of each event has to be a valid string.
+	pending map[string][]byte
+}
+
+// newRecorder creates the asciicast, recording input too if asked to.
+func newRecorder(name, title string, input bool) (*recorder, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	width, height := terminalSize()
+	r := &recorder{f: f, w: bufio.NewWriter(f), start: time.Now(), input: input, pending: map[string][]byte{}}
+	header := castHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": os.Getenv("TERM")},
+	}
+	if err := r.writeJSON(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// event records data, flushing so the cast is usable even if npterm is
+// killed.
+func (r *recorder) event(code string, p []byte) error {
+	if code == "i" && !r.input {
+		return nil
+	}
+	data := append(r.pending[code], p...)
+	// Hold back an incomplete UTF-8 sequence at the end.
+	n := len(data)
+	for i := n - 1; i >= 0 && i >= n-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				n = i
+			}
+			break
+		}
+	}
+	r.pending[code] = append([]byte(nil), data[n:]...)
+	if n == 0 {
+		return nil
+	}
+	t := math.Round(time.Since(r.start).Seconds()*1e6) / 1e6
+	if err := r.writeJSON([]interface{}{t, code, string(data[:n])}); err != nil {
+		return err
+	}
+	return r.w.Flush()
+}
+
+func (r *recorder) writeJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	r.w.Write(b)
+	return r.w.WriteByte('\n')
+}
+
+func (r *recorder) close() error {
+	if err := r.w.Flush(); err != nil {
+		r.f.Close()
+		return err
+	}
+	return r.f.Close()
+}
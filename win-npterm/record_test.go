@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecorderEvent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "npterm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "session.cast")
+	for _, input := range []bool{false, true} {
+		r, err := newRecorder(name, "vm", input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A UTF-8 sequence split across writes is held back until it's
+		// complete, separately for output and input.
+		euro := "€"
+		r.event("o", []byte("price "+euro[:2]))
+		r.event("i", []byte("é"[:1]))
+		r.event("o", []byte(euro[2:]+"5"))
+		r.event("i", []byte("é"[1:]+"\r"))
+		r.event("m", []byte("mark"))
+		if err := r.close(); err != nil {
+			t.Fatal(err)
+		}
+
+		header, events, err := readCast(name, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Version != 2 || header.Title != "vm" {
+			t.Errorf("header %+v", header)
+		}
+		if len(events) != 2 || events[0].data != "price " || events[1].data != "€5" {
+			t.Errorf("output events %+v", events)
+		}
+
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var codes, inputs []string
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n")[1:] {
+			var event []interface{}
+			if err := json.Unmarshal([]byte(line), &event); err != nil {
+				t.Fatalf("%q: %v", line, err)
+			}
+			codes = append(codes, event[1].(string))
+			if event[1] == "i" {
+				inputs = append(inputs, event[2].(string))
+			}
+		}
+		want := "o o m"
+		if input {
+			want = "o o i m"
+		}
+		if got := strings.Join(codes, " "); got != want {
+			t.Errorf("input %v: events %s, want %s", input, got, want)
+		}
+		if input && (len(inputs) != 1 || inputs[0] != "é\r") {
+			t.Errorf("input events %q", inputs)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// castEvent is an output event of an asciicast.
+type castEvent struct {
+	time float64
+	data string
+}
+
+// readCast returns the header and output events of the asciicast. Pauses
+// between events are shortened to idleLimit seconds, if not 0.
+func readCast(name string, idleLimit float64) (castHeader, []castEvent, error) {
+	var header castHeader
+	f, err := os.Open(name)
+	if err != nil {
+		return header, nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return header, nil, err
+		}
+		return header, nil, fmt.Errorf("empty file")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return header, nil, fmt.Errorf("header: %v", err)
+	}
+	if header.Version != 2 {
+		return header, nil, fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+
+	var events []castEvent
+	var last, shift float64
+	for line := 2; scanner.Scan(); line++ {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return header, nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if len(event) != 3 {
+			return header, nil, fmt.Errorf("line %d: not a [time, code, data] event", line)
+		}
+		t, ok1 := event[0].(float64)
+		code, ok2 := event[1].(string)
+		data, ok3 := event[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return header, nil, fmt.Errorf("line %d: not a [time, code, data] event", line)
+		}
+		if idleLimit > 0 && t-last > idleLimit {
+			shift += t - last - idleLimit
+		}
+		last = t
+		if code == "o" {
+			events = append(events, castEvent{t - shift, data})
+		}
+	}
+	return header, events, scanner.Err()
+}
+
+// replayHelp lists the keys of the replay subcommand.
+const replayHelp = `Keys: space pause/resume, . step while paused, left/right seek 5s,
+up/down or +/- double/halve the speed, q quit.`
+
+// replayMain is the replay subcommand, which plays an asciicast recorded
+// with -record back in the terminal.
+func replayMain(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	speedPtr := flags.Float64("speed", 1, "Playback speed, 2 for twice as fast")
+	idlePtr := flags.Duration("idle-limit", 0, "Shorten pauses to at most this, 0 for no limit")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: npterm replay [options] FILE.cast\n")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, replayHelp)
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || *speedPtr <= 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	header, events, err := readCast(flags.Arg(0), idlePtr.Seconds())
+	if err != nil {
+		fmt.Printf("Read %s: %v\n", flags.Arg(0), err)
+		os.Exit(1)
+	}
+	if width, height := terminalSize(); width < header.Width || height < header.Height {
+		fmt.Printf("Recorded at %dx%d, the terminal is only %dx%d\n", header.Width, header.Height, width, height)
+	}
+
+	if err := configureConsole(); err != nil {
+		fmt.Printf("Configure Console: %v\n", err)
+	}
+	defer restoreConsole()
+	keys := make(chan string)
+	go func() {
+		defer restoreOnPanic()
+		buf := make([]byte, 16)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- string(buf[:n])
+		}
+	}()
+
+	p := player{events: events, speed: *speedPtr}
+	p.play(keys)
+}
+
+// player plays asciicast events.
+type player struct {
+	events []castEvent
+	speed  float64
+	// next is the index of the next event and now the cast time played up
+	// to.
+	next   int
+	now    float64
+	paused bool
+}
+
+// play plays the events, acting on the keys pressed meanwhile. Once there
+// are no more keys, such as at the end of piped input, it plays on to the
+// end.
+func (p *player) play(keys <-chan string) {
+	for p.next < len(p.events) {
+		if p.paused {
+			k, ok := <-keys
+			if !ok {
+				keys, p.paused = nil, false
+				continue
+			}
+			if !p.key(k) {
+				return
+			}
+			continue
+		}
+		event := p.events[p.next]
+		wait := time.Duration((event.time - p.now) / p.speed * float64(time.Second))
+		started := time.Now()
+		select {
+		case <-time.After(wait):
+			p.output()
+		case k, ok := <-keys:
+			p.now = math.Min(event.time, p.now+time.Since(started).Seconds()*p.speed)
+			if !ok {
+				keys = nil
+				continue
+			}
+			if !p.key(k) {
+				return
+			}
+		}
+	}
+}
+
+// output writes the next event.
+func (p *player) output() {
+	event := p.events[p.next]
+	os.Stdout.WriteString(event.data)
+	p.now = math.Max(p.now, event.time)
+	p.next++
+}
+
+// key acts on a key press, returning false to quit.
+func (p *player) key(k string) bool {
+	switch k {
+	case "q", "\x03":
+		return false
+	case " ":
+		p.paused = !p.paused
+	case ".":
+		if p.paused && p.next < len(p.events) {
+			p.output()
+		}
+	case "\x1b[C", "\x1bOC":
+		p.seek(p.now + 5)
+	case "\x1b[D", "\x1bOD":
+		p.seek(p.now - 5)
+	case "\x1b[A", "\x1bOA", "+":
+		p.speed *= 2
+	case "\x1b[B", "\x1bOB", "-":
+		p.speed /= 2
+	}
+	return true
+}
+
+// seek plays the cast up to the time at once. Seeking back resets the
+// terminal and plays from the start.
+func (p *player) seek(t float64) {
+	if t < p.now {
+		os.Stdout.WriteString("\x1bc")
+		p.next, p.now = 0, 0
+	}
+	for p.next < len(p.events) && p.events[p.next].time <= t {
+		p.output()
+	}
+	p.now = math.Max(t, 0)
+}
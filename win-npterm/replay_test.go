@@ -0,0 +1,142 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCast writes the asciicast lines to a file in dir.
+func writeCast(t *testing.T, dir string, lines ...string) string {
+	name := filepath.Join(dir, "session.cast")
+	if err := ioutil.WriteFile(name, []byte(strings.Join(lines, "\n")+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestReadCast(t *testing.T) {
+	dir, err := ioutil.TempDir("", "npterm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := writeCast(t, dir,
+		`{"version": 2, "width": 100, "height": 30, "timestamp": 1500000000, "title": "vm"}`,
+		`[0.5, "o", "login: "]`,
+		`[1.0, "i", "root\r"]`,
+		`[1.25, "o", "root\r\n"]`,
+		`[11.25, "m", "File transfer sent x"]`,
+		`[12.0, "o", "# "]`,
+		`[12.5, "o", "\u001b[0m"]`,
+	)
+	header, events, err := readCast(name, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Width != 100 || header.Height != 30 || header.Timestamp != 1500000000 || header.Title != "vm" {
+		t.Errorf("header %+v", header)
+	}
+	want := []castEvent{{0.5, "login: "}, {1.25, "root\r\n"}, {12, "# "}, {12.5, "\x1b[0m"}}
+	if len(events) != len(want) {
+		t.Fatalf("events %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d is %v, want %v", i, events[i], want[i])
+		}
+	}
+
+	// Pauses over the limit are shortened, including those between events
+	// that aren't output.
+	_, events, err = readCast(name, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []float64{0.5, 1.25, 4, 4.5} {
+		if i < len(events) && events[i].time != want {
+			t.Errorf("idle limited event %d at %v, want %v", i, events[i].time, want)
+		}
+	}
+
+	for _, test := range []struct {
+		lines []string
+		err   string
+	}{
+		{[]string{""}, "header"},
+		{[]string{`{"version": 1}`}, "unsupported asciicast version 1"},
+		{[]string{`{"version": 2}`, `[1, "o"`}, "line 2"},
+		{[]string{`{"version": 2}`, `[1, "o", "a"]`, `[2, "o"]`}, "line 3: not a [time, code, data] event"},
+		{[]string{`{"version": 2}`, `["1", "o", "a"]`}, "line 2: not a [time, code, data] event"},
+		{[]string{`{"version": 2}`, `[1, "o", 2]`}, "line 2: not a [time, code, data] event"},
+	} {
+		_, _, err := readCast(writeCast(t, dir, test.lines...), 0)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: got %v, want %q", test.lines, err, test.err)
+		}
+	}
+	empty := filepath.Join(dir, "empty.cast")
+	ioutil.WriteFile(empty, nil, 0666)
+	if _, _, err := readCast(empty, 0); err == nil {
+		t.Errorf("empty file read")
+	}
+}
+
+func TestPlayerKeys(t *testing.T) {
+	p := &player{events: []castEvent{{1, "a"}, {2, "b"}, {10, "c"}, {12, "d"}}, speed: 1}
+	for _, test := range []struct {
+		key    string
+		shown  string
+		next   int
+		now    float64
+		speed  float64
+		paused bool
+	}{
+		{"\x1b[C", "ab", 2, 5, 1, false},
+		{"+", "", 2, 5, 2, false},
+		{"\x1b[A", "", 2, 5, 4, false},
+		{"-", "", 2, 5, 2, false},
+		{" ", "", 2, 5, 2, true},
+		{".", "c", 3, 10, 2, true},
+		// Seeking back starts again, after resetting the terminal.
+		{"\x1b[D", "\x1bcab", 2, 5, 2, true},
+		{"\x1bOC", "c", 3, 10, 2, true},
+		{" ", "", 3, 10, 2, false},
+		{"x", "", 3, 10, 2, false},
+	} {
+		var more bool
+		shown := captureStdout(t, func() { more = p.key(test.key) })
+		if !more || shown != test.shown || p.next != test.next || p.now != test.now || p.speed != test.speed || p.paused != test.paused {
+			t.Errorf("key %q: showed %q, %+v, want %q, next %d, now %v, speed %v, paused %v", test.key, shown, *p, test.shown, test.next, test.now, test.speed, test.paused)
+		}
+	}
+	for _, k := range []string{"q", "\x03"} {
+		if p.key(k) {
+			t.Errorf("key %q doesn't quit", k)
+		}
+	}
+}
+
+func TestPlayKeysClosed(t *testing.T) {
+	// With no more keys, such as at the end of piped input, a paused cast
+	// plays on rather than waiting for ever.
+	p := &player{events: []castEvent{{0, "a"}, {0.01, "b"}, {0.02, "c"}}, speed: 1, paused: true}
+	keys := make(chan string)
+	close(keys)
+	done := make(chan string)
+	go func() {
+		done <- captureStdout(t, func() { p.play(keys) })
+	}()
+	select {
+	case shown := <-done:
+		if shown != "abc" {
+			t.Errorf("played %q", shown)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("play hung once the keys ended")
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rn/utils/win-npterm/console"
+)
+
+// A script is a file of commands, one per line, each followed by its
+// arguments: bare words, taken as they are, or Go quoted strings ("..." with
+// escapes such as \r, \x03 and \", or `...`). Lines starting with # are
+// comments.
+//
+//	timeout DURATION       timeout of the following expects (30s to start)
+//	expect REGEXP          wait for output matching the regular expression
+//	send STRING            send the string
+//	sendline STRING        send the string and Enter (\r)
+//	ctrl KEY               send Ctrl-KEY, e.g. ctrl c
+//	capture START END [FILE]
+//	                       wait for START then END and write the output
+//	                       between them to FILE (or stdout)
+//	fail-on REGEXP         fail any following expect if this turns up first
+//	sleep DURATION         wait
+//	echo WORD...           print a message
+
+// scriptStep is a line of a script.
+type scriptStep struct {
+	line int
+	cmd  string
+	args []string
+}
+
+// scriptArgs is how many arguments each command takes, at least and at most
+// (-1 for any number).
+var scriptArgs = map[string][2]int{
+	"timeout":  {1, 1},
+	"expect":   {1, 1},
+	"send":     {1, 1},
+	"sendline": {1, 1},
+	"ctrl":     {1, 1},
+	"capture":  {2, 3},
+	"fail-on":  {1, 1},
+	"sleep":    {1, 1},
+	"echo":     {0, -1},
+}
+
+// parseScript reads and checks the script.
+func parseScript(name string) ([]scriptStep, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var steps []scriptStep
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		words, err := splitScriptLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, line, err)
+		}
+		step := scriptStep{line: line, cmd: words[0], args: words[1:]}
+		n, ok := scriptArgs[step.cmd]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%s:%d: unknown command %q", name, line, step.cmd)
+		case len(step.args) < n[0] || (n[1] >= 0 && len(step.args) > n[1]):
+			return nil, fmt.Errorf("%s:%d: wrong number of arguments to %s", name, line, step.cmd)
+		}
+		if err := checkStep(step); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, line, err)
+		}
+		steps = append(steps, step)
+	}
+	return steps, scanner.Err()
+}
+
+// checkStep checks the arguments of the step, so mistakes are found before
+// connecting.
+func checkStep(step scriptStep) error {
+	switch step.cmd {
+	case "timeout", "sleep":
+		_, err := time.ParseDuration(step.args[0])
+		return err
+	case "expect", "fail-on", "capture":
+		patterns := step.args
+		if step.cmd == "capture" {
+			patterns = step.args[:2]
+		}
+		for _, arg := range patterns {
+			if _, err := regexp.Compile(arg); err != nil {
+				return err
+			}
+		}
+	case "ctrl":
+		_, err := ctrlKey(step.args[0])
+		return err
+	}
+	return nil
+}
+
+// splitScriptLine returns the words of the line, unquoting quoted ones.
+func splitScriptLine(line string) ([]string, error) {
+	var words []string
+	for line = strings.TrimLeft(line, " \t"); line != ""; line = strings.TrimLeft(line, " \t") {
+		if line[0] != '"' && line[0] != '`' {
+			n := strings.IndexAny(line, " \t")
+			if n == -1 {
+				n = len(line)
+			}
+			words = append(words, line[:n])
+			line = line[n:]
+			continue
+		}
+		quoted, err := strconv.QuotedPrefix(line)
+		if err != nil {
+			return nil, fmt.Errorf("bad quoted string %s", line)
+		}
+		word, _ := strconv.Unquote(quoted)
+		words = append(words, word)
+		line = line[len(quoted):]
+	}
+	return words, nil
+}
+
+// ctrlKey returns the control character for the key, such as 3 for c.
+func ctrlKey(key string) (byte, error) {
+	if len(key) == 1 {
+		if c := strings.ToUpper(key)[0]; c >= '@' && c <= '_' {
+			return c & 0x1f, nil
+		}
+	}
+	return 0, fmt.Errorf("no such control key %q", key)
+}
+
+// runScript runs the script against the console, returning an error, with
+// the script line, on the first failure.
+func runScript(name string, steps []scriptStep, conn io.ReadWriter, echo io.Writer) error {
+	e := console.NewExpecter(conn)
+	e.Echo = echo
+	timeout := 30 * time.Second
+	var failOn []string
+
+	// expect waits for the pattern or any of the failOn patterns, whichever
+	// comes first, returning the output up to the end of the match and the
+	// match.
+	expect := func(pattern string) (string, string, error) {
+		out, i, match, err := e.ExpectAny(append([]string{pattern}, failOn...), timeout)
+		if err != nil {
+			return "", "", err
+		}
+		if i > 0 {
+			return "", "", fmt.Errorf("got %q, matching fail-on %q", match, failOn[i-1])
+		}
+		return out, match, nil
+	}
+
+	for _, step := range steps {
+		var err error
+		switch step.cmd {
+		case "timeout":
+			timeout, _ = time.ParseDuration(step.args[0])
+		case "expect":
+			_, _, err = expect(step.args[0])
+		case "send":
+			_, err = io.WriteString(conn, step.args[0])
+		case "sendline":
+			_, err = io.WriteString(conn, step.args[0]+"\r")
+		case "ctrl":
+			c, _ := ctrlKey(step.args[0])
+			_, err = conn.Write([]byte{c})
+		case "capture":
+			if _, _, err = expect(step.args[0]); err != nil {
+				break
+			}
+			var out, match string
+			if out, match, err = expect(step.args[1]); err != nil {
+				break
+			}
+			captured := []byte(out[:len(out)-len(match)])
+			if len(step.args) == 3 {
+				err = ioutil.WriteFile(step.args[2], captured, 0666)
+			} else {
+				fmt.Printf("\n--- capture ---\n%s\n--- end capture ---\n", captured)
+			}
+		case "fail-on":
+			failOn = append(failOn, step.args[0])
+		case "sleep":
+			d, _ := time.ParseDuration(step.args[0])
+			time.Sleep(d)
+		case "echo":
+			fmt.Fprintf(os.Stderr, "%s:%d: %s\n", name, step.line, strings.Join(step.args, " "))
+		}
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s: %v", name, step.line, step.cmd, err)
+		}
+	}
+	return nil
+}
+
+// scriptMain is the script subcommand, which runs a script against a console
+// instead of connecting it to the terminal.
+func scriptMain(args []string) {
+	flags := flag.NewFlagSet("script", flag.ExitOnError)
+	quietPtr := flags.Bool("quiet", false, "Don't show the console output")
+	retryFlag := retryFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: npterm script [options] SCRIPT TARGET\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	name, target := flags.Arg(0), flags.Arg(1)
+
+	steps, err := parseScript(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Script %v\n", err)
+		os.Exit(1)
+	}
+	scheme, addr, err := console.ParseTarget(target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Target %s: %v\n", target, err)
+		os.Exit(1)
+	}
+	status := func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+	c, err := console.Connect(scheme, addr, retryFlag(), status, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Connect to console: %v\n", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+
+	var echo io.Writer = os.Stdout
+	if *quietPtr {
+		echo = nil
+	}
+	if err := runScript(name, steps, c, echo); err != nil {
+		fmt.Fprintf(os.Stderr, "\n%v\n", err)
+		c.Close()
+		os.Exit(1)
+	}
+}
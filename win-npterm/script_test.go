@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestRunScriptFailOn(t *testing.T) {
+	for _, test := range []struct {
+		output string
+		err    string
+	}{
+		{"Booting\nlogin: ", ""},
+		{"Booting\nBUG: oops\nlogin: ", `got "BUG:", matching fail-on "BUG:"`},
+		{"Kernel panic - not syncing\n", `got "Kernel panic", matching fail-on "(Kernel) panic"`},
+	} {
+		steps := []scriptStep{
+			{line: 1, cmd: "fail-on", args: []string{"(Kernel) panic"}},
+			{line: 2, cmd: "fail-on", args: []string{"BUG:"}},
+			{line: 3, cmd: "expect", args: []string{"(log)(in): "}},
+		}
+		client, server := net.Pipe()
+		go func() {
+			server.Write([]byte(test.output))
+			server.Close()
+		}()
+		err := runScript("test", steps, client, nil)
+		client.Close()
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%q: %v", test.output, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%q: got %v, want %s", test.output, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rn/utils/win-npterm/console"
+)
+
+// listFlag is a flag which may be given more than once.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// listen listens on the address, given as npipe://, unix:// or tcp:// URL or
+// as a bare pipe name, path or host:port. A stale Unix domain socket left
+// by an earlier server is removed.
+func listen(address string) (net.Listener, error) {
+	scheme, addr := "", address
+	if i := strings.Index(address, "://"); i != -1 {
+		var err error
+		if scheme, addr, err = console.ParseTarget(address); err != nil {
+			return nil, err
+		}
+	} else if strings.HasPrefix(address, `\\`) {
+		scheme = "npipe"
+	} else if _, _, err := net.SplitHostPort(address); err == nil && !strings.ContainsAny(address, `/\`) {
+		scheme = "tcp"
+	} else {
+		scheme = "unix"
+	}
+	switch scheme {
+	case "npipe":
+		return console.ListenPipe(addr)
+	case "unix":
+		if fi, err := os.Stat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if c, err := net.Dial("unix", addr); err == nil {
+				c.Close()
+				return nil, fmt.Errorf("%s is in use", addr)
+			}
+			os.Remove(addr)
+		}
+	case "tcp":
+	default:
+		return nil, fmt.Errorf("can't listen on %s", scheme)
+	}
+	return net.Listen(scheme, addr)
+}
+
+// server shares one connection to a console between any number of clients.
+// Output goes to all of them, and input from one at a time: the first client
+// to type holds the input lock until it's idle for lockIdle.
+type server struct {
+	target   string
+	lockIdle time.Duration
+
+	lock       sync.Mutex
+	upstream   io.ReadWriteCloser
+	clients    map[*client]bool
+	nextID     int
+	scrollback []byte
+	// maxScrollback is how much output is kept for clients attaching later.
+	maxScrollback int
+	writer        *client
+	lastInput     time.Time
+	// writers is the goroutines writing to clients.
+	writers sync.WaitGroup
+}
+
+// client is a client attached to the server.
+type client struct {
+	id       int
+	conn     net.Conn
+	readOnly bool
+	// out queues output for the client; a client too slow to keep up with
+	// it is detached rather than holding up everyone else.
+	out chan []byte
+	// toldLocked is whether the client was told its input was dropped since
+	// the input lock was last taken.
+	toldLocked bool
+}
+
+// clientQueue is how many chunks of output are queued for a client.
+const clientQueue = 1024
+
+// Write sends output from the console to all clients.
+func (srv *server) Write(p []byte) (int, error) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	srv.scrollback = append(srv.scrollback, p...)
+	if over := len(srv.scrollback) - srv.maxScrollback; over > 0 {
+		srv.scrollback = append(srv.scrollback[:0], srv.scrollback[over:]...)
+	}
+	for c := range srv.clients {
+		srv.sendLocked(c, append([]byte(nil), p...))
+	}
+	return len(p), nil
+}
+
+func (srv *server) sendLocked(c *client, p []byte) {
+	if !srv.clients[c] {
+		return
+	}
+	select {
+	case c.out <- p:
+	default:
+		fmt.Printf("Client %d is too slow, detaching it\n", c.id)
+		srv.detachLocked(c)
+		c.conn.Close()
+	}
+}
+
+// notice sends a message from the server to the client.
+func (srv *server) noticeLocked(c *client, format string, args ...interface{}) {
+	srv.sendLocked(c, []byte(fmt.Sprintf("\r\n[npterm serve: "+format+"]\r\n", args...)))
+}
+
+// noticeAll sends a message from the server to all clients.
+func (srv *server) noticeAll(format string, args ...interface{}) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	for c := range srv.clients {
+		srv.noticeLocked(c, format, args...)
+	}
+}
+
+func (srv *server) setUpstream(upstream io.ReadWriteCloser) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	srv.upstream = upstream
+}
+
+// accept attaches clients connecting to the listener until it's closed.
+func (srv *server) accept(l net.Listener, readOnly bool) {
+	defer restoreOnPanic()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go srv.attach(conn, readOnly)
+	}
+}
+
+// attach serves the client until it disconnects or is detached.
+func (srv *server) attach(conn net.Conn, readOnly bool) {
+	defer restoreOnPanic()
+	srv.lock.Lock()
+	srv.nextID++
+	c := &client{id: srv.nextID, conn: conn, readOnly: readOnly, out: make(chan []byte, clientQueue)}
+	mode := ""
+	if readOnly {
+		mode = " (read-only)"
+	}
+	from := ""
+	if addr := conn.RemoteAddr(); addr != nil && addr.String() != "" && addr.String() != "@" {
+		from = " from " + addr.String()
+	}
+	fmt.Printf("Client %d attached%s%s\n", c.id, from, mode)
+	srv.clients[c] = true
+	srv.sendLocked(c, append([]byte(nil), srv.scrollback...))
+	srv.noticeLocked(c, "attached to %s as client %d%s, %d attached", srv.target, c.id, mode, len(srv.clients))
+	srv.writers.Add(1)
+	srv.lock.Unlock()
+
+	go func() {
+		defer restoreOnPanic()
+		defer srv.writers.Done()
+		for p := range c.out {
+			if _, err := conn.Write(p); err != nil {
+				conn.Close()
+				return
+			}
+		}
+		conn.Close()
+	}()
+
+	buf := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 && !readOnly {
+			srv.input(c, buf[:n])
+		}
+		if err != nil {
+			break
+		}
+	}
+	srv.lock.Lock()
+	srv.detachLocked(c)
+	srv.lock.Unlock()
+}
+
+// detachLocked removes the client, which closes its connection once its
+// queued output is written.
+func (srv *server) detachLocked(c *client) {
+	if !srv.clients[c] {
+		return
+	}
+	fmt.Printf("Client %d detached\n", c.id)
+	delete(srv.clients, c)
+	close(c.out)
+	if srv.writer == c {
+		srv.writer = nil
+	}
+}
+
+// input passes input from the client to the console if the client holds, or
+// can take, the input lock.
+func (srv *server) input(c *client, p []byte) {
+	srv.lock.Lock()
+	if srv.writer != nil && srv.writer != c && time.Since(srv.lastInput) < srv.lockIdle {
+		if !c.toldLocked {
+			srv.noticeLocked(c, "client %d is typing, input dropped", srv.writer.id)
+			c.toldLocked = true
+		}
+		srv.lock.Unlock()
+		return
+	}
+	if srv.writer != c {
+		srv.writer = c
+		for other := range srv.clients {
+			other.toldLocked = false
+		}
+	}
+	srv.lastInput = time.Now()
+	upstream := srv.upstream
+	srv.lock.Unlock()
+
+	// A console slow to take input mustn't hold up the output to the
+	// clients, which needs the lock.
+	if upstream != nil {
+		upstream.Write(p)
+	}
+}
+
+// closeAll detaches all clients, telling them why, and gives their queued
+// output a second to be written.
+func (srv *server) closeAll(format string, args ...interface{}) {
+	srv.lock.Lock()
+	for c := range srv.clients {
+		srv.noticeLocked(c, format, args...)
+		srv.detachLocked(c)
+	}
+	srv.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer restoreOnPanic()
+		srv.writers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+}
+
+// serveMain is the serve subcommand, which holds the connection to a console
+// and shares it with the clients connecting to the listen addresses.
+func serveMain(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	var listenAddrs, readOnlyAddrs listFlag
+	flags.Var(&listenAddrs, "listen", "Address to listen on for clients, unix:///path, tcp://host:port or npipe://./pipe/name; may be repeated")
+	flags.Var(&readOnlyAddrs, "listen-ro", "Address to listen on for read-only clients; may be repeated")
+	scrollbackPtr := flags.String("scrollback", "64K", "How much output to send clients when they attach")
+	lockIdlePtr := flags.Duration("lock-idle", 5*time.Second, "How long the client typing keeps the input lock after its last input")
+	reconnectPtr := flags.Bool("reconnect", false, "Reconnect when the connection is closed, such as when the VM reboots")
+	reconnectTimeoutPtr := flags.Duration("reconnect-timeout", 0, "How long to wait for the target to reappear when reconnecting, 0 for ever")
+	retryFlag := retryFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: npterm serve [options] TARGET\n")
+		flags.PrintDefaults()
+	}
+	// Allow the options after the target too.
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		args = append(args[1:], args[0])
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || len(listenAddrs)+len(readOnlyAddrs) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	scrollback, err := parseSize(*scrollbackPtr)
+	if err != nil {
+		fmt.Printf("Scrollback %s: %v\n", *scrollbackPtr, err)
+		os.Exit(1)
+	}
+	target := flags.Arg(0)
+	scheme, addr, err := console.ParseTarget(target)
+	if err != nil {
+		fmt.Printf("Target %s: %v\n", target, err)
+		os.Exit(1)
+	}
+
+	srv := &server{
+		target:        target,
+		lockIdle:      *lockIdlePtr,
+		clients:       map[*client]bool{},
+		maxScrollback: int(scrollback),
+	}
+	var listeners []net.Listener
+	closeListeners := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+	for i, address := range append(listenAddrs, readOnlyAddrs...) {
+		l, err := listen(address)
+		if err != nil {
+			fmt.Printf("Listen on %s: %v\n", address, err)
+			closeListeners()
+			os.Exit(1)
+		}
+		fmt.Println("Listening on:", address)
+		listeners = append(listeners, l)
+		go srv.accept(l, i >= len(listenAddrs))
+	}
+	// Closing the listeners removes their Unix domain sockets.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer restoreOnPanic()
+		sig := <-sigs
+		srv.closeAll("server exiting on %v", sig)
+		closeListeners()
+		fmt.Printf("Exiting on %v\n", sig)
+		os.Exit(1)
+	}()
+	defer closeListeners()
+
+	fmt.Println("Connecting to:", target)
+	status := func(format string, args ...interface{}) {
+		fmt.Printf(format+"\n", args...)
+		srv.noticeAll(format, args...)
+	}
+	r := retryFlag()
+	for {
+		c, err := console.Connect(scheme, addr, r, status, func() bool { return false })
+		if err != nil {
+			fmt.Printf("Connect to console: %v\n", err)
+			srv.closeAll("connect to console: %v", err)
+			return
+		}
+		fmt.Println("Connected")
+		srv.setUpstream(c)
+		_, err = io.Copy(srv, c)
+		srv.setUpstream(nil)
+		c.Close()
+		if err == nil {
+			err = io.EOF
+		}
+		if !*reconnectPtr {
+			fmt.Printf("Console closed: %v\n", err)
+			srv.closeAll("console closed: %v", err)
+			return
+		}
+		status("console disconnected at %s (%v), reconnecting", time.Now().Format("15:04:05"), err)
+		r.Timeout = *reconnectTimeoutPtr
+	}
+}
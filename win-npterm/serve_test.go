@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// blockingConsole is a console whose writes block until released.
+type blockingConsole struct {
+	io.ReadCloser
+	writing, release chan struct{}
+}
+
+func (b *blockingConsole) Write(p []byte) (int, error) {
+	b.writing <- struct{}{}
+	<-b.release
+	return len(p), nil
+}
+
+func TestServerInputDoesNotBlockOutput(t *testing.T) {
+	upstream := &blockingConsole{writing: make(chan struct{}), release: make(chan struct{})}
+	srv := &server{lockIdle: time.Second, clients: map[*client]bool{}, maxScrollback: 1024}
+	srv.setUpstream(upstream)
+	c := &client{id: 1, out: make(chan []byte, clientQueue)}
+	srv.clients[c] = true
+
+	done := make(chan struct{})
+	go func() {
+		srv.input(c, []byte("x"))
+		close(done)
+	}()
+	<-upstream.writing
+
+	// Output carries on while the console is taking the input.
+	written := make(chan struct{})
+	go func() {
+		srv.Write([]byte("output"))
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Errorf("output blocked by input being written to the console")
+	}
+	close(upstream.release)
+	<-done
+	<-written
+	if got := string(<-c.out); got != "output" {
+		t.Errorf("client got %q", got)
+	}
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// session is a connection to a console. Output from the console is written
+// to it, which shows it on stdout and logs it, and input is passed to the
+// console by forwardInput, which also acts on escape commands.
+type session struct {
+	// escape is the escape character, or 0 for none, and escapeName how it
+	// is shown to the user.
+	escape     byte
+	escapeName string
+	logName    string
+	logOpts    logOptions
+	start      time.Time
+	// readOnly drops all input other than escape commands.
+	readOnly bool
+
+	lock sync.Mutex
+	// conn is the current connection, which changes when reconnecting.
+	conn io.ReadWriteCloser
+	// log is nil when not logging and record when not recording.
+	log    *logger
+	record *recorder
+	// timestamps prefixes each line shown with the time, and lineStart
+	// records whether the next byte shown starts a line.
+	timestamps   bool
+	lineStart    bool
+	hexView      bool
+	disconnected bool
+
+	// xfer is the file transfer running, which the console output goes to
+	// instead of the screen, xferEnded is closed once the output it didn't
+	// read is shown, and downloadDir is where it puts files.
+	xfer        *transfer
+	xferEnded   chan struct{}
+	downloadDir string
+	// zmodemAuto starts a ZMODEM receive when a sender such as sz starts,
+	// with zmodemTail the end of the output so far, in case the start is
+	// split across writes.
+	zmodemAuto bool
+	zmodemTail []byte
+}
+
+func newSession(conn io.ReadWriteCloser, escape byte, escapeName, logName string, logOpts logOptions) *session {
+	return &session{
+		conn:       conn,
+		escape:     escape,
+		escapeName: escapeName,
+		logName:    logName,
+		logOpts:    logOpts,
+		start:      time.Now(),
+		lineStart:  true,
+	}
+}
+
+// parseEscape returns the escape character given as "^X", a single
+// character, or "none".
+func parseEscape(s string) (byte, error) {
+	switch {
+	case s == "none":
+		return 0, nil
+	case len(s) == 2 && s[0] == '^':
+		if s[1] == '?' {
+			return 0x7f, nil
+		}
+		if c := strings.ToUpper(s)[1]; c >= '@' && c <= '_' {
+			return c & 0x1f, nil
+		}
+	case len(s) == 1:
+		return s[0], nil
+	}
+	return 0, fmt.Errorf("must be ^X, a single character or none")
+}
+
+// Write shows and logs output from the console, or passes it to the file
+// transfer running.
+func (s *session) Write(p []byte) (int, error) {
+	n := len(p)
+	s.lock.Lock()
+	for s.xfer != nil {
+		x, ended := s.xfer, s.xferEnded
+		s.lock.Unlock()
+		if x.feed(p) {
+			return n, nil
+		}
+		// The transfer is over; what it didn't read, such as the end of the
+		// sender's output, is shown first.
+		<-ended
+		s.lock.Lock()
+	}
+	defer s.lock.Unlock()
+
+	var zmodem []byte
+	if s.zmodemAuto && !s.readOnly {
+		p, zmodem = s.findZmodemLocked(p)
+	}
+	err := s.showLocked(p)
+	if zmodem != nil {
+		x := s.startTransferLocked("Receiving with ZMODEM", func(x *transfer) error {
+			return x.receive("ZMODEM", "", false)
+		})
+		x.feed(zmodem)
+	}
+	return n, err
+}
+
+// showLocked shows, logs and records the output.
+func (s *session) showLocked(p []byte) error {
+	s.logLocked(logOutput, p)
+	s.recordLocked("o", p)
+	var err error
+	if s.hexView {
+		_, err = os.Stdout.Write(bytes.Replace([]byte(hex.Dump(p)), []byte("\n"), []byte("\r\n"), -1))
+	} else {
+		_, err = os.Stdout.Write(s.stamp(p))
+	}
+	return err
+}
+
+// findZmodemLocked looks for a ZMODEM sender starting in the output,
+// returning the output before it and, if found, the rest.
+func (s *session) findZmodemLocked(p []byte) ([]byte, []byte) {
+	data := append(s.zmodemTail, p...)
+	if i := bytes.Index(data, []byte(zmodemStart)); i != -1 {
+		s.zmodemTail = nil
+		shown := i - (len(data) - len(p))
+		if shown < 0 {
+			shown = 0
+		}
+		return p[:shown], data[i:]
+	}
+	if len(data) >= len(zmodemStart) {
+		data = data[len(data)-len(zmodemStart)+1:]
+	}
+	s.zmodemTail = append([]byte(nil), data...)
+	return p, nil
+}
+
+// startTransferLocked starts the file transfer, which has the console
+// output until it ends.
+func (s *session) startTransferLocked(what string, run func(x *transfer) error) *transfer {
+	x := newTransfer(s.conn, s.downloadDir, os.Stdout)
+	ended := make(chan struct{})
+	s.xfer, s.xferEnded = x, ended
+	s.noticeLocked("%s, %s or ^C cancels", what, s.escapeName)
+	go func() {
+		defer restoreOnPanic()
+		summary, err := x.run(func() error { return run(x) })
+		if err != nil {
+			summary += fmt.Sprintf("; failed: %v", err)
+		}
+		s.lock.Lock()
+		s.xfer = nil
+		s.markLocked("File transfer %s", summary)
+		if rest := x.rest(); len(rest) > 0 {
+			s.showLocked(rest)
+		}
+		s.lock.Unlock()
+		close(ended)
+	}()
+	return x
+}
+
+func (s *session) transfer() *transfer {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.xfer
+}
+
+// transferCommand starts the file transfer the user asked for at the send
+// ('u') or receive ('d') prompt.
+func (s *session) transferCommand(cmd byte, line string) {
+	words, err := splitScriptLine(line)
+	if err != nil || len(words) == 0 {
+		s.notice("No file transfer")
+		return
+	}
+	protocol, err := parseProtocol(words[0])
+	if err != nil {
+		s.notice("File transfer: %v", err)
+		return
+	}
+	args := words[1:]
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if cmd == 'u' {
+		if len(args) == 0 {
+			s.noticeLocked("No files to send")
+			return
+		}
+		s.startTransferLocked("Sending with "+protocol, func(x *transfer) error {
+			return x.send(protocol, args)
+		})
+		return
+	}
+	name := ""
+	switch {
+	case protocol == "XMODEM" && len(args) != 1:
+		s.noticeLocked("XMODEM needs the name to save the file as")
+		return
+	case protocol == "XMODEM":
+		name = args[0]
+	case len(args) != 0:
+		s.noticeLocked("%s sends the file names", protocol)
+		return
+	}
+	s.startTransferLocked("Receiving with "+protocol, func(x *transfer) error {
+		return x.receive(protocol, name, true)
+	})
+}
+
+// stamp returns the output with each line prefixed with the time if
+// timestamps are on.
+func (s *session) stamp(p []byte) []byte {
+	if !s.timestamps {
+		if len(p) > 0 {
+			s.lineStart = p[len(p)-1] == '\n'
+		}
+		return p
+	}
+	var out bytes.Buffer
+	for _, b := range p {
+		if s.lineStart {
+			out.WriteString(time.Now().Format("[15:04:05.000] "))
+			s.lineStart = false
+		}
+		out.WriteByte(b)
+		if b == '\n' {
+			s.lineStart = true
+		}
+	}
+	return out.Bytes()
+}
+
+// logLocked logs the data, if logging, stopping logging if that fails.
+func (s *session) logLocked(direction byte, p []byte) {
+	if s.log == nil || (direction == logInput && !s.logOpts.input) {
+		return
+	}
+	if err := s.log.write(direction, p); err != nil {
+		s.noticeLocked("Log to %s: %v", s.logName, err)
+		s.stopLog()
+	}
+}
+
+// notice shows a message from npterm itself, on a line of its own.
+func (s *session) notice(format string, args ...interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.noticeLocked(format, args...)
+}
+
+func (s *session) noticeLocked(format string, args ...interface{}) {
+	msg := strings.Replace(fmt.Sprintf(format, args...), "\n", "\r\n", -1)
+	fmt.Printf("\r\n[npterm: %s]\r\n", msg)
+}
+
+// recordLocked records the event, if recording, stopping recording if that
+// fails.
+func (s *session) recordLocked(code string, p []byte) {
+	if s.record == nil {
+		return
+	}
+	if err := s.record.event(code, p); err != nil {
+		s.noticeLocked("Record: %v", err)
+		s.record.close()
+		s.record = nil
+	}
+}
+
+// startLog starts logging to logName, truncating it first if asked to and
+// not appending.
+func (s *session) startLog(truncate bool) error {
+	l, err := newLogger(s.logName, s.logOpts, truncate, s.start)
+	if err != nil {
+		return err
+	}
+	s.log = l
+	return nil
+}
+
+func (s *session) stopLog() {
+	s.log.close()
+	s.log = nil
+}
+
+// escapeHelp lists the escape commands, %[1]s being the escape character.
+const escapeHelp = `Escape commands:
+%[1]s q   quit
+%[1]s b   send a BREAK
+%[1]s s   send a magic SysRq, followed by the SysRq key
+%[1]s l   start/stop logging to %[2]s
+%[1]s t   toggle timestamps (on screen)
+%[1]s x   toggle hex view
+%[1]s u   send files: z|y|x FILE... (ZMODEM, YMODEM or XMODEM)
+%[1]s d   receive files: z, y or x FILE
+%[1]s %[1]s  send %[1]s
+%[1]s ?   this help`
+
+// forwardInput copies the input to the console until the input ends or the
+// quit escape command, acting on the other escape commands on the way.
+func (s *session) forwardInput(in io.Reader) {
+	defer restoreOnPanic()
+	const (
+		stateNormal = iota
+		stateEscape
+		stateSysRq
+		statePrompt
+	)
+	state := stateNormal
+	// prompt is the escape command prompting for a line, and line the line
+	// so far.
+	var prompt byte
+	var line []byte
+	// lineStart is whether the last byte sent ended a line: a printable
+	// escape character, such as ~, only counts there, as in ssh.
+	lineStart := true
+	printable := s.escape >= ' ' && s.escape < 0x7f
+	buf := make([]byte, 1024)
+	for {
+		n, err := in.Read(buf)
+		var out []byte
+		for _, b := range buf[:n] {
+			// Input during a file transfer would corrupt it.
+			if x := s.transfer(); x != nil {
+				if b == 0x03 || b == s.escape {
+					x.stop()
+				}
+				state = stateNormal
+				continue
+			}
+			switch {
+			case state == statePrompt:
+				switch {
+				case b == '\r' || b == '\n':
+					state = stateNormal
+					fmt.Print("\r\n")
+					s.send(&out)
+					s.transferCommand(prompt, string(line))
+				case b == 0x03 || b == s.escape:
+					state = stateNormal
+					s.notice("Cancelled")
+				case b == 0x7f || b == '\b':
+					if len(line) > 0 {
+						line = line[:len(line)-1]
+						fmt.Print("\b \b")
+					}
+				case b >= ' ':
+					line = append(line, b)
+					os.Stdout.Write([]byte{b})
+				}
+			case state == stateNormal && s.escape != 0 && b == s.escape && (lineStart || !printable):
+				state = stateEscape
+			case state == stateNormal:
+				out = append(out, b)
+				lineStart = b == '\r' || b == '\n'
+			case state == stateSysRq:
+				state = stateNormal
+				s.send(&out)
+				if err := sendBreak(s.connection()); err != nil {
+					s.notice("Send SysRq: %v", err)
+					continue
+				}
+				out = append(out, b)
+				lineStart = false
+			default:
+				state = stateNormal
+				switch b {
+				case s.escape:
+					out = append(out, b)
+					lineStart = false
+				case 'q', '.':
+					s.send(&out)
+					s.disconnect()
+					return
+				case 'b':
+					s.send(&out)
+					if err := sendBreak(s.connection()); err != nil {
+						s.notice("Send BREAK: %v", err)
+					}
+				case 's':
+					state = stateSysRq
+				case 'l':
+					s.toggleLog()
+				case 't':
+					s.lock.Lock()
+					s.timestamps = !s.timestamps
+					s.noticeLocked("Timestamps %s", onOff(s.timestamps))
+					s.lock.Unlock()
+				case 'x':
+					s.lock.Lock()
+					s.hexView = !s.hexView
+					s.noticeLocked("Hex view %s", onOff(s.hexView))
+					s.lock.Unlock()
+				case 'u', 'd':
+					if s.readOnly {
+						s.notice("No file transfers when read-only")
+						continue
+					}
+					state, prompt, line = statePrompt, b, nil
+					if b == 'u' {
+						fmt.Print("\r\nSend (z|y|x FILE...): ")
+					} else {
+						fmt.Print("\r\nReceive (z, y or x FILE): ")
+					}
+				case '?', 'h':
+					s.notice(escapeHelp, s.escapeName, s.logName)
+				default:
+					s.notice("Unknown escape command; %s ? for help", s.escapeName)
+				}
+			}
+		}
+		s.send(&out)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// send writes the pending input to the console, and logs it if logging
+// input. Input is dropped if the console has gone away; the output side
+// notices that.
+func (s *session) send(out *[]byte) {
+	if s.readOnly {
+		*out = (*out)[:0]
+	}
+	if len(*out) > 0 {
+		s.lock.Lock()
+		conn := s.conn
+		s.logLocked(logInput, *out)
+		s.recordLocked("i", *out)
+		s.lock.Unlock()
+		conn.Write(*out)
+		*out = (*out)[:0]
+	}
+}
+
+func (s *session) connection() io.ReadWriteCloser {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.conn
+}
+
+// setConn switches the session to a new connection, reporting false, and
+// closing it, if the user ended the session meanwhile.
+func (s *session) setConn(conn io.ReadWriteCloser) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.disconnected {
+		conn.Close()
+		return false
+	}
+	s.conn = conn
+	return true
+}
+
+// mark shows a message from npterm and adds it to the log, so gaps in the
+// output are visible there too.
+func (s *session) mark(format string, args ...interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.markLocked(format, args...)
+}
+
+func (s *session) markLocked(format string, args ...interface{}) {
+	s.noticeLocked(format, args...)
+	s.recordLocked("m", []byte(fmt.Sprintf(format, args...)))
+	if s.log != nil {
+		if err := s.log.mark(fmt.Sprintf(format, args...)); err != nil {
+			s.noticeLocked("Log to %s: %v", s.logName, err)
+			s.stopLog()
+		}
+	}
+}
+
+func (s *session) toggleLog() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.log != nil {
+		s.stopLog()
+		s.noticeLocked("Stopped logging to %s", s.logName)
+		return
+	}
+	if err := s.startLog(false); err != nil {
+		s.noticeLocked("Log to %s: %v", s.logName, err)
+		return
+	}
+	s.noticeLocked("Logging to %s", s.logName)
+}
+
+// closeLog stops logging and recording.
+func (s *session) closeLog() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.log != nil {
+		s.stopLog()
+	}
+	if s.record != nil {
+		s.record.close()
+		s.record = nil
+	}
+}
+
+// disconnect closes the connection, which ends the session.
+func (s *session) disconnect() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.disconnected = true
+	s.conn.Close()
+}
+
+// closed reports whether the session was ended by the user.
+func (s *session) closed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.disconnected
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
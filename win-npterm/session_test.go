@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseEscape(t *testing.T) {
+	for _, test := range []struct {
+		s    string
+		want byte
+		ok   bool
+	}{
+		{"^]", 0x1d, true},
+		{"^a", 0x01, true},
+		{"^?", 0x7f, true},
+		{"~", '~', true},
+		{"none", 0, true},
+		{"^1", 0, false},
+		{"ab", 0, false},
+		{"", 0, false},
+	} {
+		got, err := parseEscape(test.s)
+		if got != test.want || (err == nil) != test.ok {
+			t.Errorf("parseEscape(%q) = %#x, %v", test.s, got, err)
+		}
+	}
+}
+
+// consoleRecorder is a console recording the input sent to it.
+type consoleRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *consoleRecorder) Read(p []byte) (int, error) { return 0, io.EOF }
+func (c *consoleRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+// chunkedInput is input read a chunk at a time, as keys are typed.
+type chunkedInput struct {
+	chunks []string
+}
+
+func (in *chunkedInput) Read(p []byte) (int, error) {
+	if len(in.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, in.chunks[0])
+	in.chunks = in.chunks[1:]
+	return n, nil
+}
+
+// captureStdout returns what f writes to stdout, where notices go.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	out := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		out <- b
+	}()
+	f()
+	w.Close()
+	return string(<-out)
+}
+
+func TestForwardInput(t *testing.T) {
+	for _, test := range []struct {
+		escape string
+		input  []string
+		// sent is what reaches the console, and notice part of what's
+		// shown on the screen.
+		sent       string
+		quit       bool
+		notice     string
+		timestamps bool
+		hexView    bool
+	}{
+		{"~", []string{"ls\r~."}, "ls\r", true, "", false, false},
+		{"~", []string{"~q", "ignored"}, "", true, "", false, false},
+		// A printable escape character only counts at the start of a line.
+		{"~", []string{"a~.b\r"}, "a~.b\r", false, "", false, false},
+		{"~", []string{"~~.\r"}, "~.\r", false, "", false, false},
+		{"~", []string{"\r~", "."}, "\r", true, "", false, false},
+		{"~", []string{"\n~t~x"}, "\n", false, "Hex view on", true, true},
+		{"~", []string{"~b"}, "", false, "Send BREAK", false, false},
+		// The SysRq key is dropped when the BREAK can't be sent.
+		{"~", []string{"~s", "hx"}, "x", false, "Send SysRq", false, false},
+		{"~", []string{"~z"}, "", false, "Unknown escape command; ~ ? for help", false, false},
+		{"~", []string{"~?"}, "", false, "send a BREAK", false, false},
+		// A control character escape counts anywhere.
+		{"^]", []string{"ab\x1d", "."}, "ab", true, "", false, false},
+		{"^]", []string{"a\x1d\x1db"}, "a\x1db", false, "", false, false},
+		{"none", []string{"~.\x1d."}, "~.\x1d.", false, "", false, false},
+	} {
+		escape, _ := parseEscape(test.escape)
+		conn := &consoleRecorder{}
+		s := newSession(conn, escape, test.escape, "npterm.log", logOptions{})
+		shown := captureStdout(t, func() {
+			s.forwardInput(&chunkedInput{append([]string(nil), test.input...)})
+		})
+		if conn.String() != test.sent || s.closed() != test.quit || conn.closed != test.quit {
+			t.Errorf("%s %q: sent %q, quit %v, want %q, %v", test.escape, test.input, conn.String(), s.closed(), test.sent, test.quit)
+		}
+		if !strings.Contains(shown, test.notice) {
+			t.Errorf("%s %q: showed %q, want %q", test.escape, test.input, shown, test.notice)
+		}
+		if s.timestamps != test.timestamps || s.hexView != test.hexView {
+			t.Errorf("%s %q: timestamps %v, hex view %v", test.escape, test.input, s.timestamps, s.hexView)
+		}
+	}
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rn/utils/win-npterm/console"
+)
+
+var (
+	errTimeout         = errors.New("timed out")
+	errCancelled       = errors.New("cancelled")
+	errRemoteCancelled = errors.New("cancelled by the other end")
+)
+
+// link is the connection a file transfer runs over, with the console
+// output arriving on in.
+type link struct {
+	in     <-chan []byte
+	buf    []byte
+	closed bool
+	out    io.Writer
+	// cancel is closed to cancel the transfer.
+	cancel <-chan struct{}
+}
+
+// readByte reads a byte, waiting for at most timeout.
+func (l *link) readByte(timeout time.Duration) (byte, error) {
+	if len(l.buf) == 0 {
+		if err := l.fill(timeout); err != nil {
+			return 0, err
+		}
+	}
+	c := l.buf[0]
+	l.buf = l.buf[1:]
+	return c, nil
+}
+
+// fill waits for more output, for at most timeout, not waiting at all if
+// timeout isn't positive.
+func (l *link) fill(timeout time.Duration) error {
+	if l.closed {
+		return io.EOF
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	for len(l.buf) == 0 {
+		var p []byte
+		ok := true
+		if expired == nil {
+			select {
+			case p, ok = <-l.in:
+			default:
+				return errTimeout
+			}
+		} else {
+			select {
+			case p, ok = <-l.in:
+			case <-l.cancel:
+				return errCancelled
+			case <-expired:
+				return errTimeout
+			}
+		}
+		if !ok {
+			l.closed = true
+			return io.EOF
+		}
+		l.buf = p
+	}
+	return nil
+}
+
+// read reads n bytes, waiting at most timeout for each.
+func (l *link) read(n int, timeout time.Duration) ([]byte, error) {
+	p := make([]byte, n)
+	for i := range p {
+		c, err := l.readByte(timeout)
+		if err != nil {
+			return nil, err
+		}
+		p[i] = c
+	}
+	return p, nil
+}
+
+// unread puts the byte back to be read again.
+func (l *link) unread(c byte) {
+	l.buf = append([]byte{c}, l.buf...)
+}
+
+// pending reports whether there's output waiting to be read.
+func (l *link) pending() bool {
+	return len(l.buf) > 0 || l.fill(0) == nil
+}
+
+// purge drops output until there's none for quiet.
+func (l *link) purge(quiet time.Duration) {
+	for {
+		l.buf = nil
+		if l.fill(quiet) != nil {
+			return
+		}
+	}
+}
+
+// secondCAN reports whether another CAN follows the one just read, as two
+// cancel an XMODEM or YMODEM transfer.
+func (l *link) secondCAN() bool {
+	c, err := l.readByte(time.Second)
+	if err == nil && c != can {
+		l.unread(c)
+	}
+	return err == nil && c == can
+}
+
+func (l *link) write(p []byte) error {
+	select {
+	case <-l.cancel:
+		return errCancelled
+	default:
+	}
+	_, err := l.out.Write(p)
+	return err
+}
+
+// abort cancels the transfer at the other end, with the CANs any of the
+// protocols take as that, and backspaces to clear them from a command line
+// if nothing is running there.
+func (l *link) abort() {
+	l.out.Write([]byte("\x18\x18\x18\x18\x18\x18\x18\x18\x18\x18\b\b\b\b\b\b\b\b\b\b"))
+}
+
+// transfer is a file transfer, sending or receiving files over a link.
+type transfer struct {
+	l  *link
+	in chan []byte
+	// done is closed when the transfer ends, and cancel to cancel it.
+	done       chan struct{}
+	cancel     chan struct{}
+	cancelOnce sync.Once
+	// feeding is held while output is fed, so none is lost between feed
+	// and rest.
+	feeding sync.Mutex
+	// dir is where received files go.
+	dir string
+	// progress, if not nil, is where progress is shown, on a line of its
+	// own updated in place.
+	progress io.Writer
+	start    time.Time
+
+	// The file being transferred.
+	name      string
+	size      int64
+	pos       int64
+	fileStart time.Time
+	shown     time.Time
+	shownLen  int
+
+	// verb is what the transfer does, files and bytes are the files
+	// transferred and skips those skipped, for the summary.
+	verb  string
+	files []string
+	bytes int64
+	skips []string
+}
+
+// progressEvery is how often progress is shown.
+const progressEvery = 200 * time.Millisecond
+
+// newTransfer returns a transfer writing to out, fed the console output
+// with feed.
+func newTransfer(out io.Writer, dir string, progress io.Writer) *transfer {
+	t := &transfer{
+		in:       make(chan []byte, 256),
+		done:     make(chan struct{}),
+		cancel:   make(chan struct{}),
+		dir:      dir,
+		progress: progress,
+		start:    time.Now(),
+	}
+	t.l = &link{in: t.in, out: out, cancel: t.cancel}
+	return t
+}
+
+// feed passes console output to the transfer, returning false, without
+// taking it, if the transfer has ended.
+func (t *transfer) feed(p []byte) bool {
+	t.feeding.Lock()
+	defer t.feeding.Unlock()
+	select {
+	case <-t.done:
+		return false
+	default:
+	}
+	select {
+	case t.in <- append([]byte(nil), p...):
+		return true
+	case <-t.done:
+		return false
+	}
+}
+
+// rest returns the output fed to the transfer that it didn't read, such as
+// what the other end printed once it was done, after the transfer ends.
+func (t *transfer) rest() []byte {
+	t.feeding.Lock()
+	defer t.feeding.Unlock()
+	p := t.l.buf
+	t.l.buf = nil
+	for {
+		select {
+		case q, ok := <-t.in:
+			if !ok {
+				return p
+			}
+			p = append(p, q...)
+		default:
+			return p
+		}
+	}
+}
+
+// stop cancels the transfer.
+func (t *transfer) stop() {
+	t.cancelOnce.Do(func() { close(t.cancel) })
+}
+
+// run runs the transfer, cancelling it at the other end on failure, and
+// returns a summary of it.
+func (t *transfer) run(f func() error) (string, error) {
+	err := f()
+	close(t.done)
+	switch err {
+	case nil, errRemoteCancelled, io.EOF:
+	default:
+		t.l.abort()
+	}
+
+	var s []string
+	if len(t.files) > 0 {
+		s = append(s, fmt.Sprintf("%s %s (%s) in %.1fs", t.verb, strings.Join(t.files, ", "), formatSize(t.bytes), time.Since(t.start).Seconds()))
+	}
+	if len(t.skips) > 0 {
+		s = append(s, "skipped "+strings.Join(t.skips, ", "))
+	}
+	if len(s) == 0 {
+		s = append(s, t.verb+" nothing")
+	}
+	return strings.Join(s, "; "), err
+}
+
+// begin starts transferring a file, of size bytes or -1 if not known.
+func (t *transfer) begin(name string, size int64) {
+	t.name, t.size, t.pos = name, size, 0
+	t.fileStart = time.Now()
+	t.show()
+}
+
+// advance notes n more bytes of the file transferred.
+func (t *transfer) advance(n int64) {
+	t.pos += n
+	if time.Since(t.shown) >= progressEvery {
+		t.show()
+	}
+}
+
+// seek notes that the transfer went back to pos, after an error.
+func (t *transfer) seek(pos int64) {
+	t.pos = pos
+}
+
+// end notes the file transferred.
+func (t *transfer) end() {
+	t.show()
+	if t.progress != nil {
+		io.WriteString(t.progress, "\r\n")
+		t.shownLen = 0
+	}
+	t.files = append(t.files, t.name)
+	t.bytes += t.pos
+}
+
+func (t *transfer) skipped(name, why string) {
+	t.skips = append(t.skips, fmt.Sprintf("%s (%s)", name, why))
+}
+
+// show shows the progress of the file.
+func (t *transfer) show() {
+	t.shown = time.Now()
+	if t.progress == nil {
+		return
+	}
+	s := t.name + " " + formatSize(t.pos)
+	if t.size >= 0 {
+		s += "/" + formatSize(t.size)
+		if t.size > 0 {
+			s += fmt.Sprintf(" %d%%", t.pos*100/t.size)
+		}
+	}
+	if d := time.Since(t.fileStart).Seconds(); d > 0 {
+		s += " " + formatSize(int64(float64(t.pos)/d)) + "/s"
+	}
+	pad := ""
+	if n := t.shownLen - len(s); n > 0 {
+		pad = strings.Repeat(" ", n)
+	}
+	fmt.Fprintf(t.progress, "\r%s%s", s, pad)
+	t.shownLen = len(s)
+}
+
+// protocols are the file transfer protocols, by the names and letters
+// they are given as.
+var protocols = map[string]string{
+	"x": "XMODEM", "xmodem": "XMODEM",
+	"y": "YMODEM", "ymodem": "YMODEM",
+	"z": "ZMODEM", "zmodem": "ZMODEM",
+}
+
+func parseProtocol(s string) (string, error) {
+	if p, ok := protocols[strings.ToLower(s)]; ok {
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown protocol %q, must be x, y or z", s)
+}
+
+// send sends the files with the protocol.
+func (t *transfer) send(protocol string, names []string) error {
+	t.verb = "sent"
+	switch protocol {
+	case "XMODEM":
+		if len(names) != 1 {
+			return fmt.Errorf("XMODEM sends one file at a time")
+		}
+		return t.xmodemSend(names[0])
+	case "YMODEM":
+		return t.ymodemSend(names)
+	}
+	return t.zmodemSend(names)
+}
+
+// receive receives files with the protocol, an XMODEM one as name. A
+// ZMODEM receive says it's ready at once if initiate, rather than waiting
+// for the sender to start.
+func (t *transfer) receive(protocol, name string, initiate bool) error {
+	t.verb = "received"
+	switch protocol {
+	case "XMODEM":
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(t.dir, name)
+		}
+		return t.xmodemReceive(name)
+	case "YMODEM":
+		return t.ymodemReceive()
+	}
+	return t.zmodemReceive(initiate)
+}
+
+// createReceived creates a file in dir for a received file, named as the
+// sender says but without any directory, and with .1, .2, ... added rather
+// than replacing an existing file.
+func createReceived(dir, name string) (*os.File, string, error) {
+	base := filepath.Base(filepath.FromSlash(strings.Replace(name, `\`, "/", -1)))
+	if base == "." || base == ".." || base == string(filepath.Separator) {
+		return nil, "", fmt.Errorf("bad file name %q", name)
+	}
+	path := filepath.Join(dir, base)
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) {
+			return f, path, err
+		}
+		path = fmt.Sprintf("%s.%d", filepath.Join(dir, base), i)
+	}
+}
+
+// transferMain is the send and receive subcommands, which transfer files
+// over a console.
+func transferMain(cmd string, args []string) {
+	flags := flag.NewFlagSet(cmd, flag.ExitOnError)
+	protocolPtr := flags.String("protocol", "z", "Protocol: x (XMODEM), y (YMODEM) or z (ZMODEM)")
+	startPtr := flags.String("start", "", "Command to run on the other end first, such as 'rx /tmp/file' or 'sz /var/log/messages' (ZMODEM sends run rz themselves)")
+	dirPtr := flags.String("dir", ".", "Directory to put received files in")
+	quietPtr := flags.Bool("quiet", false, "Don't show progress")
+	retryFlag := retryFlags(flags)
+	flags.Usage = func() {
+		if cmd == "send" {
+			fmt.Fprintf(os.Stderr, "Usage: npterm send [options] TARGET FILE...\n")
+		} else {
+			fmt.Fprintf(os.Stderr, "Usage: npterm receive [options] TARGET [FILE]\n")
+			fmt.Fprintf(os.Stderr, "FILE is the name to save an XMODEM file as; YMODEM and ZMODEM send names.\n")
+		}
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	protocol, err := parseProtocol(*protocolPtr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Protocol: %v\n", err)
+		os.Exit(2)
+	}
+	names := flags.Args()
+	if len(names) > 0 {
+		names = names[1:]
+	}
+	switch {
+	case flags.NArg() < 1,
+		cmd == "send" && len(names) == 0,
+		cmd == "receive" && protocol == "XMODEM" && len(names) != 1,
+		cmd == "receive" && protocol != "XMODEM" && len(names) != 0:
+		flags.Usage()
+		os.Exit(2)
+	}
+	target := flags.Arg(0)
+	scheme, addr, err := console.ParseTarget(target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Target %s: %v\n", target, err)
+		os.Exit(1)
+	}
+	status := func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+	c, err := console.Connect(scheme, addr, retryFlag(), status, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Connect to console: %v\n", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+
+	var progress io.Writer = os.Stderr
+	if *quietPtr {
+		progress = nil
+	}
+	t := newTransfer(c, *dirPtr, progress)
+	go func() {
+		defer restoreOnPanic()
+		buf := make([]byte, 4096)
+		for {
+			n, err := c.Read(buf)
+			if n > 0 {
+				t.feed(buf[:n])
+			}
+			if err != nil {
+				close(t.in)
+				return
+			}
+		}
+	}()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		defer restoreOnPanic()
+		<-sigs
+		t.stop()
+	}()
+
+	if *startPtr != "" {
+		if _, err := io.WriteString(c, *startPtr+"\r"); err != nil {
+			fmt.Fprintf(os.Stderr, "Write to console: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	summary, err := t.run(func() error {
+		if cmd == "send" {
+			return t.send(protocol, names)
+		}
+		name := ""
+		if len(names) > 0 {
+			name = names[0]
+		}
+		return t.receive(protocol, name, *startPtr == "")
+	})
+	fmt.Fprintf(os.Stderr, "%s: %s\n", protocol, summary)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", protocol, err)
+		c.Close()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// feeder feeds what's written to it to a transfer, as the console would.
+type feeder struct {
+	t *transfer
+}
+
+func (f feeder) Write(p []byte) (int, error) {
+	f.t.feed(p)
+	return len(p), nil
+}
+
+// loopback returns a sender and a receiver, putting files in dir, whose
+// links are connected to each other.
+func loopback(dir string) (*transfer, *transfer) {
+	sender := newTransfer(nil, dir, nil)
+	receiver := newTransfer(nil, dir, nil)
+	sender.l.out = feeder{receiver}
+	receiver.l.out = feeder{sender}
+	return sender, receiver
+}
+
+func TestTransferLoopback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "npterm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	if err := os.Mkdir(src, 0777); err != nil {
+		t.Fatal(err)
+	}
+	// Every byte value, so all the escapes are needed, and more than a
+	// ZMODEM window.
+	data := make([]byte, 40000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	mtime := time.Unix(1500000000, 0)
+	var names []string
+	for _, name := range []string{"one.bin", "two.bin"} {
+		path := filepath.Join(src, name)
+		if err := ioutil.WriteFile(path, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mtime, mtime)
+		names = append(names, path)
+	}
+
+	for _, test := range []struct {
+		protocol string
+		names    []string
+		// received is the files received, and mtime whether their times
+		// are kept.
+		received []string
+		mtime    bool
+	}{
+		{"XMODEM", names[:1], []string{"x.bin"}, false},
+		{"YMODEM", names, []string{"one.bin", "two.bin"}, true},
+		{"ZMODEM", names, []string{"one.bin", "two.bin"}, true},
+	} {
+		os.RemoveAll(dst)
+		if err := os.Mkdir(dst, 0777); err != nil {
+			t.Fatal(err)
+		}
+		sender, receiver := loopback(dst)
+		received := make(chan error, 1)
+		go func() {
+			_, err := receiver.run(func() error {
+				return receiver.receive(test.protocol, test.received[0], false)
+			})
+			received <- err
+		}()
+		sent := make(chan error, 1)
+		go func() {
+			_, err := sender.run(func() error { return sender.send(test.protocol, test.names) })
+			sent <- err
+		}()
+		for _, c := range []chan error{sent, received} {
+			select {
+			case err := <-c:
+				if err != nil {
+					t.Errorf("%s: %v", test.protocol, err)
+				}
+			case <-time.After(30 * time.Second):
+				t.Fatalf("%s: transfer hung", test.protocol)
+			}
+		}
+
+		for _, name := range test.received {
+			path := filepath.Join(dst, name)
+			got, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Errorf("%s: %v", test.protocol, err)
+				continue
+			}
+			if test.protocol == "XMODEM" {
+				// XMODEM pads the last block.
+				got = bytes.TrimRight(got, "\x1a")
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("%s: %s differs, %d bytes received", test.protocol, name, len(got))
+			}
+			if fi, err := os.Stat(path); err == nil && test.mtime && !fi.ModTime().Equal(mtime) {
+				t.Errorf("%s: %s modified %v, want %v", test.protocol, name, fi.ModTime(), mtime)
+			}
+		}
+		if len(sender.files) != len(test.names) || len(receiver.files) != len(test.received) {
+			t.Errorf("%s: sent %q, received %q", test.protocol, sender.files, receiver.files)
+		}
+	}
+}
+
+func TestTransferRest(t *testing.T) {
+	x := newTransfer(nil, "", nil)
+	if !x.feed([]byte("ab")) || !x.feed([]byte("cd")) {
+		t.Fatalf("output not fed to a running transfer")
+	}
+	x.run(func() error {
+		_, err := x.l.read(3, time.Second)
+		return err
+	})
+	// Output after the transfer, such as a shell prompt, isn't taken, and
+	// what it didn't read is kept.
+	if x.feed([]byte("$ ")) {
+		t.Errorf("output fed to a transfer that ended")
+	}
+	if got := string(x.rest()); got != "d" {
+		t.Errorf("rest = %q, want %q", got, "d")
+	}
+	if got := string(x.rest()); got != "" {
+		t.Errorf("rest again = %q, want nothing", got)
+	}
+}
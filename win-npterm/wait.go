@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rn/utils/win-npterm/console"
+)
+
+// The exit codes of the wait subcommand, other than 0 for success and 2 for
+// bad usage.
+const (
+	waitFailed  = 1 // a failure pattern turned up
+	waitTimeout = 3 // the success pattern didn't turn up in time
+	waitError   = 4 // connecting failed or the console closed
+)
+
+// waitResult is how waiting for the console ended.
+type waitResult struct {
+	code int
+	// kind is the JUnit failure or error type and message says why.
+	kind    string
+	message string
+}
+
+// transcript keeps the console output, without ANSI escapes, for the
+// context shown on failure and the JUnit report.
+type transcript struct {
+	strip escapeStripper
+	buf   bytes.Buffer
+}
+
+func (t *transcript) Write(p []byte) (int, error) {
+	t.buf.Write(t.strip.strip(p))
+	return len(p), nil
+}
+
+// lastLines returns the last n lines of the output, without a trailing
+// newline.
+func (t *transcript) lastLines(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	out := strings.TrimRight(t.buf.String(), "\n")
+	for i := len(out) - 1; i >= 0; i-- {
+		if out[i] == '\n' {
+			if n--; n == 0 {
+				return out[i+1:]
+			}
+		}
+	}
+	return out
+}
+
+// JUnit XML report of a wait, as read by Jenkins, GitLab and most other CI
+// systems.
+type junitSuite struct {
+	XMLName   xml.Name    `xml:"testsuite"`
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string       `xml:"name,attr"`
+	Classname string       `xml:"classname,attr"`
+	Time      string       `xml:"time,attr"`
+	Failure   *junitResult `xml:"failure,omitempty"`
+	Error     *junitResult `xml:"error,omitempty"`
+	SystemOut *junitText   `xml:"system-out,omitempty"`
+}
+
+type junitResult struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",cdata"`
+}
+
+type junitText struct {
+	Text string `xml:",cdata"`
+}
+
+// writeJUnit writes the report of the wait, with the context lines as the
+// failure's text and all the output as the test's system-out.
+func writeJUnit(name, test string, start time.Time, result waitResult, context string, t *transcript) error {
+	elapsed := fmt.Sprintf("%.3f", time.Since(start).Seconds())
+	suite := junitSuite{
+		Name:      "npterm",
+		Tests:     1,
+		Time:      elapsed,
+		Timestamp: start.Format("2006-01-02T15:04:05"),
+	}
+	tc := junitCase{Name: test, Classname: "npterm.wait", Time: elapsed}
+	if t.buf.Len() > 0 {
+		tc.SystemOut = &junitText{xmlText(t.buf.String())}
+	}
+	r := &junitResult{Message: result.message, Type: result.kind, Text: xmlText(context)}
+	switch result.code {
+	case 0:
+	case waitError:
+		suite.Errors = 1
+		tc.Error = r
+	default:
+		suite.Failures = 1
+		tc.Failure = r
+	}
+	suite.Cases = []junitCase{tc}
+
+	b, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, append([]byte(xml.Header), append(b, '\n')...), 0666)
+}
+
+// xmlText returns the text without the characters XML doesn't allow, which
+// CDATA sections don't escape, and with invalid UTF-8 replaced.
+func xmlText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' && r != '\t' && r != '\n' && r != '\r' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// waitFor reads the console until the success pattern or one of the
+// failure patterns turns up, the timeout (0 for none) runs out or the
+// console closes. After a failure it goes on reading for after, to catch
+// any backtrace following the failure.
+func waitFor(conn io.Reader, success string, fail []string, timeout, after time.Duration, echo io.Writer) waitResult {
+	e := console.NewExpecter(conn)
+	e.Echo = echo
+
+	_, i, match, err := e.ExpectAny(append([]string{success}, fail...), timeout)
+	switch {
+	case err == nil:
+	case isTimeoutError(err):
+		return waitResult{waitTimeout, "timeout", fmt.Sprintf("timed out after %v waiting for %q", timeout, success)}
+	case err == io.EOF:
+		return waitResult{waitError, "closed", fmt.Sprintf("console closed before %q turned up", success)}
+	default:
+		return waitResult{waitError, "read", fmt.Sprintf("read from console: %v", err)}
+	}
+	if i == 0 {
+		return waitResult{0, "", fmt.Sprintf("got %q", match)}
+	}
+	result := waitResult{waitFailed, "fail", fmt.Sprintf("got %q, matching failure pattern %q", match, fail[i-1])}
+	if d, ok := conn.(interface{ SetReadDeadline(time.Time) error }); ok && after > 0 {
+		d.SetReadDeadline(time.Now().Add(after))
+		io.Copy(echo, conn)
+	}
+	return result
+}
+
+func isTimeoutError(err error) bool {
+	_, ok := err.(*console.TimeoutError)
+	return ok
+}
+
+// waitMain is the wait subcommand, which waits for a pattern such as a
+// login prompt on the console, for boot tests.
+func waitMain(args []string) {
+	flags := flag.NewFlagSet("wait", flag.ExitOnError)
+	successPtr := flags.String("success", "", "Regular expression for the output meaning success, e.g. 'login: '")
+	var failPatterns listFlag
+	flags.Var(&failPatterns, "fail", "Regular expression for output meaning failure, e.g. 'Kernel panic|BUG:'; may be repeated")
+	timeoutPtr := flags.Duration("timeout", 2*time.Minute, "How long to wait for success, connecting included, 0 for ever")
+	afterPtr := flags.Duration("after", 2*time.Second, "How long to go on reading after a failure, to catch a backtrace")
+	contextPtr := flags.Int("context", 50, "How many lines of output to show on failure")
+	junitPtr := flags.String("junit", "", "Write a JUnit XML report to this file")
+	namePtr := flags.String("name", "", "Test name in the JUnit report (default the target)")
+	quietPtr := flags.Bool("quiet", false, "Don't show the console output as it comes")
+	backoff := backoffFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: npterm wait -success REGEXP [options] TARGET\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "Exits 0 on success, %d on failure, %d on timeout and %d if the console can't be read.\n", waitFailed, waitTimeout, waitError)
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || *successPtr == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	for _, pattern := range append([]string{*successPtr}, failPatterns...) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			fmt.Fprintf(os.Stderr, "Pattern %v\n", err)
+			os.Exit(2)
+		}
+	}
+	target := flags.Arg(0)
+	scheme, addr, err := console.ParseTarget(target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Target %s: %v\n", target, err)
+		os.Exit(2)
+	}
+	test := *namePtr
+	if test == "" {
+		test = target
+	}
+
+	start := time.Now()
+	out := &transcript{}
+	var echo io.Writer = out
+	if !*quietPtr {
+		echo = io.MultiWriter(os.Stdout, out)
+	}
+	status := func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+	var result waitResult
+	c, err := console.Connect(scheme, addr, backoff(*timeoutPtr), status, nil)
+	if err != nil {
+		result = waitResult{waitError, "connect", fmt.Sprintf("connect to console: %v", err)}
+	} else {
+		timeout := *timeoutPtr
+		if timeout > 0 {
+			// Whatever is left after connecting, which still gets a chance
+			// to read what's already there.
+			timeout -= time.Since(start)
+			if timeout < time.Millisecond {
+				timeout = time.Millisecond
+			}
+		}
+		result = waitFor(c, *successPtr, failPatterns, timeout, *afterPtr, echo)
+		c.Close()
+	}
+	if result.code == waitTimeout {
+		// Say how long in all, not just after connecting.
+		result.message = fmt.Sprintf("timed out after %v waiting for %q", *timeoutPtr, *successPtr)
+	}
+
+	context := ""
+	if result.code != 0 {
+		context = out.lastLines(*contextPtr)
+		if context != "" {
+			fmt.Fprintf(os.Stderr, "\n--- last %d lines ---\n%s\n--- end ---\n", *contextPtr, context)
+		}
+		fmt.Fprintf(os.Stderr, "FAIL after %.1fs: %s\n", time.Since(start).Seconds(), result.message)
+	} else {
+		fmt.Fprintf(os.Stderr, "\nOK after %.1fs: %s\n", time.Since(start).Seconds(), result.message)
+	}
+	if *junitPtr != "" {
+		if err := writeJUnit(*junitPtr, test, start, result, context, out); err != nil {
+			fmt.Fprintf(os.Stderr, "Write JUnit report: %v\n", err)
+			if result.code == 0 {
+				result.code = waitError
+			}
+		}
+	}
+	os.Exit(result.code)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// deadlinePipe is the reading end of an io.Pipe with read deadlines, which
+// waitFor needs for timeouts. A deadline passing closes the writing end with
+// a timeout error.
+type deadlinePipe struct {
+	*io.PipeReader
+	w     *io.PipeWriter
+	timer *time.Timer
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func (p *deadlinePipe) SetReadDeadline(t time.Time) error {
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	if !t.IsZero() {
+		p.timer = time.AfterFunc(time.Until(t), func() { p.w.CloseWithError(timeoutError{}) })
+	}
+	return nil
+}
+
+func TestWaitFor(t *testing.T) {
+	fail := []string{"(Kernel) panic", "BUG:"}
+	for _, test := range []struct {
+		name string
+		// output is written a piece at a time, so what follows a failure
+		// turns up after it's matched.
+		output  []string
+		close   bool
+		timeout time.Duration
+		code    int
+		kind    string
+		message string
+		// transcript is what should have been read, including what follows
+		// a failure.
+		transcript string
+	}{
+		{"success", []string{"Booting\n", "login: "}, false, time.Second, 0, "", `got "login: "`, "Booting\nlogin: "},
+		{"failure", []string{"Booting\nBUG: oops\n", "backtrace\n"}, true, time.Second, waitFailed, "fail", `got "BUG:", matching failure pattern "BUG:"`, "Booting\nBUG: oops\nbacktrace\n"},
+		{"grouped failure", []string{"Kernel panic - not syncing\n"}, true, 0, waitFailed, "fail", `got "Kernel panic", matching failure pattern "(Kernel) panic"`, "Kernel panic - not syncing\n"},
+		{"timeout", []string{"Booting\n"}, false, 50 * time.Millisecond, waitTimeout, "timeout", `timed out after 50ms waiting for "login: "`, "Booting\n"},
+		{"eof", []string{"Booting\n"}, true, 0, waitError, "closed", `console closed before "login: " turned up`, "Booting\n"},
+	} {
+		r, w := io.Pipe()
+		go func() {
+			for _, p := range test.output {
+				io.WriteString(w, p)
+				time.Sleep(10 * time.Millisecond)
+			}
+			if test.close {
+				w.Close()
+			}
+		}()
+		out := &transcript{}
+		got := waitFor(&deadlinePipe{PipeReader: r, w: w}, "login: ", fail, test.timeout, 100*time.Millisecond, out)
+		w.Close()
+		if got.code != test.code || got.kind != test.kind || got.message != test.message {
+			t.Errorf("%s: got %+v, want %d %q %q", test.name, got, test.code, test.kind, test.message)
+		}
+		if out.buf.String() != test.transcript {
+			t.Errorf("%s: read %q, want %q", test.name, out.buf.String(), test.transcript)
+		}
+	}
+}
+
+func TestLastLines(t *testing.T) {
+	out := &transcript{}
+	io.WriteString(out, "one\r\ntwo\n\x1b[1mthree\x1b[0m\nfour\n\n")
+	for n, want := range map[int]string{
+		0:  "",
+		1:  "four",
+		2:  "three\nfour",
+		4:  "one\ntwo\nthree\nfour",
+		10: "one\ntwo\nthree\nfour",
+	} {
+		if got := out.lastLines(n); got != want {
+			t.Errorf("lastLines(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "npterm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "boot.xml")
+	out := &transcript{}
+	io.WriteString(out, "Booting\x00\n\x1b[31mBUG:\x07 oops\b\n")
+	for _, result := range []waitResult{
+		{0, "", `got "login: "`},
+		{waitFailed, "fail", `got "BUG:", matching failure pattern "BUG:"`},
+		{waitError, "closed", `console closed before "login: " turned up`},
+	} {
+		if err := writeJUnit(name, "vm1", time.Now(), result, out.lastLines(1), out); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var suite junitSuite
+		if err := xml.Unmarshal(data, &suite); err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+		if len(suite.Cases) != 1 || suite.Cases[0].Name != "vm1" || suite.Tests != 1 {
+			t.Fatalf("report %+v", suite)
+		}
+		tc := suite.Cases[0]
+		if tc.SystemOut == nil || tc.SystemOut.Text != "Booting\nBUG: oops\n" {
+			t.Errorf("system-out %+v", tc.SystemOut)
+		}
+		var r *junitResult
+		switch result.code {
+		case 0:
+			if suite.Failures != 0 || suite.Errors != 0 || tc.Failure != nil || tc.Error != nil {
+				t.Errorf("success reported as %+v", suite)
+			}
+			continue
+		case waitError:
+			r = tc.Error
+			if suite.Errors != 1 || suite.Failures != 0 {
+				t.Errorf("error counted as %+v", suite)
+			}
+		default:
+			r = tc.Failure
+			if suite.Failures != 1 || suite.Errors != 0 {
+				t.Errorf("failure counted as %+v", suite)
+			}
+		}
+		if r == nil || r.Message != result.message || r.Type != result.kind || r.Text != "BUG: oops" {
+			t.Errorf("result %+v, want %+v", r, result)
+		}
+	}
+}
+
+func TestXMLText(t *testing.T) {
+	if got, want := xmlText("a\x00b\x07c\bd\te\r\nf\x1b"), "abcd\te\r\nf"; got != want {
+		t.Errorf("xmlText = %q, want %q", got, want)
+	}
+	if !strings.Contains(xmlText("ok ✓"), "✓") {
+		t.Errorf("xmlText dropped Unicode")
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XMODEM and YMODEM control characters.
+const (
+	soh = 0x01 // starts a 128 byte block
+	stx = 0x02 // starts a 1024 byte block
+	eot = 0x04
+	ack = 0x06
+	nak = 0x15
+	can = 0x18
+	// sub pads the last block of a file, being the CP/M end of file.
+	sub = 0x1a
+)
+
+// xmodemRetries is how many times a block is tried before giving up.
+const xmodemRetries = 10
+
+var errBadBlock = errors.New("bad block")
+
+// crc16 updates the CRC-16 (CCITT polynomial, as in XMODEM and ZMODEM) with
+// the data.
+func crc16(crc uint16, p []byte) uint16 {
+	for _, b := range p {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func checksum(p []byte) byte {
+	var sum byte
+	for _, b := range p {
+		sum += b
+	}
+	return sum
+}
+
+// xmodemWaitStart waits for the receiver to ask for the transfer to start,
+// with C for CRCs or NAK for checksums, and reports which.
+func (t *transfer) xmodemWaitStart() (bool, error) {
+	deadline := time.Now().Add(time.Minute)
+	for {
+		c, err := t.l.readByte(time.Until(deadline))
+		if err != nil {
+			return false, err
+		}
+		switch c {
+		case 'C', nak:
+			// Drop any more start characters sent meanwhile, which would
+			// otherwise be taken as NAKs of the first block.
+			t.l.purge(100 * time.Millisecond)
+			return c == 'C', nil
+		case can:
+			if t.l.secondCAN() {
+				return false, errRemoteCancelled
+			}
+		}
+	}
+}
+
+// xmodemSendBlock sends the block, of 128 or 1024 bytes, until the receiver
+// acknowledges it.
+func (t *transfer) xmodemSendBlock(num byte, data []byte, crc bool) error {
+	block := []byte{soh, num, ^num}
+	if len(data) == 1024 {
+		block[0] = stx
+	}
+	block = append(block, data...)
+	if crc {
+		c := crc16(0, data)
+		block = append(block, byte(c>>8), byte(c))
+	} else {
+		block = append(block, checksum(data))
+	}
+
+	for try := 0; try < xmodemRetries; try++ {
+		if err := t.l.write(block); err != nil {
+			return err
+		}
+		deadline := time.Now().Add(10 * time.Second)
+	wait:
+		for {
+			c, err := t.l.readByte(time.Until(deadline))
+			switch {
+			case err == errTimeout:
+				break wait
+			case err != nil:
+				return err
+			case c == ack:
+				return nil
+			case c == nak:
+				break wait
+			// A receiver that missed the first block asks for it again.
+			case c == 'C' && num <= 1:
+				break wait
+			case c == can:
+				if t.l.secondCAN() {
+					return errRemoteCancelled
+				}
+			}
+		}
+	}
+	return fmt.Errorf("block %d not acknowledged after %d tries", num, xmodemRetries)
+}
+
+// xmodemSendData sends the data in blocks, 1024 bytes each if using CRCs,
+// and then the end of the file.
+func (t *transfer) xmodemSendData(r io.Reader, crc bool) error {
+	size := 128
+	if crc {
+		size = 1024
+	}
+	buf := make([]byte, size)
+	for num := byte(1); ; num++ {
+		n, err := io.ReadFull(r, buf)
+		if n == 0 {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		data := buf
+		if n <= 128 {
+			data = buf[:128]
+		}
+		for i := n; i < len(data); i++ {
+			data[i] = sub
+		}
+		if err := t.xmodemSendBlock(num, data, crc); err != nil {
+			return err
+		}
+		t.advance(int64(n))
+	}
+
+	for try := 0; try < xmodemRetries; try++ {
+		if err := t.l.write([]byte{eot}); err != nil {
+			return err
+		}
+		c, err := t.l.readByte(10 * time.Second)
+		switch {
+		case err == errTimeout:
+		case err != nil:
+			return err
+		case c == ack:
+			return nil
+		}
+		// YMODEM receivers NAK the first EOT.
+	}
+	return fmt.Errorf("end of file not acknowledged")
+}
+
+// xmodemSend sends the file with XMODEM.
+func (t *transfer) xmodemSend(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	crc, err := t.xmodemWaitStart()
+	if err != nil {
+		return err
+	}
+	t.begin(filepath.Base(name), fi.Size())
+	if err := t.xmodemSendData(f, crc); err != nil {
+		return err
+	}
+	t.end()
+	return nil
+}
+
+// ymodemSend sends the files with YMODEM, each preceded by block 0 with its
+// name, size and modification time, and then an empty block 0.
+func (t *transfer) ymodemSend(names []string) error {
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		err = t.ymodemSendFile(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	crc, err := t.xmodemWaitStart()
+	if err != nil {
+		return err
+	}
+	return t.xmodemSendBlock(0, make([]byte, 128), crc)
+}
+
+func (t *transfer) ymodemSendFile(f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header := []byte(filepath.Base(f.Name()) + "\x00" + fileInfo(fi))
+	block := make([]byte, 128)
+	if len(header) > len(block) {
+		block = make([]byte, 1024)
+	}
+	copy(block, header)
+
+	crc, err := t.xmodemWaitStart()
+	if err != nil {
+		return err
+	}
+	if err := t.xmodemSendBlock(0, block, crc); err != nil {
+		return err
+	}
+	if crc, err = t.xmodemWaitStart(); err != nil {
+		return err
+	}
+	t.begin(fi.Name(), fi.Size())
+	if err := t.xmodemSendData(f, crc); err != nil {
+		return err
+	}
+	t.end()
+	return nil
+}
+
+// fileInfo returns the size, modification time and mode of the file as
+// sent by YMODEM and ZMODEM: in decimal, and seconds since 1970 and a Unix
+// mode in octal.
+func fileInfo(fi os.FileInfo) string {
+	return fmt.Sprintf("%d %o %o", fi.Size(), fi.ModTime().Unix(), 0100000|fi.Mode().Perm())
+}
+
+// parseFileInfo returns the name, size (-1 if not given) and modification
+// time (zero if not given) from a YMODEM block 0 or ZMODEM ZFILE.
+func parseFileInfo(p []byte) (string, int64, time.Time) {
+	name := p
+	var info []byte
+	if i := bytes.IndexByte(p, 0); i != -1 {
+		name, info = p[:i], p[i+1:]
+	}
+	if i := bytes.IndexByte(info, 0); i != -1 {
+		info = info[:i]
+	}
+	size, mtime := int64(-1), time.Time{}
+	fields := strings.Fields(string(info))
+	if len(fields) > 0 {
+		if n, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			size = n
+		}
+	}
+	if len(fields) > 1 {
+		if n, err := strconv.ParseInt(fields[1], 8, 64); err == nil && n > 0 {
+			mtime = time.Unix(n, 0)
+		}
+	}
+	return string(name), size, mtime
+}
+
+// xmodemReadBlock reads the next block, returning its number and data, or
+// eot at the end of the file. Anything else before a block is skipped.
+func (t *transfer) xmodemReadBlock(crc bool, timeout time.Duration) (byte, []byte, bool, error) {
+	deadline := time.Now().Add(timeout)
+	size := 0
+	for size == 0 {
+		c, err := t.l.readByte(time.Until(deadline))
+		if err != nil {
+			return 0, nil, false, err
+		}
+		switch c {
+		case soh:
+			size = 128
+		case stx:
+			size = 1024
+		case eot:
+			return 0, nil, true, nil
+		case can:
+			if t.l.secondCAN() {
+				return 0, nil, false, errRemoteCancelled
+			}
+		}
+	}
+	n := 2 + size + 1
+	if crc {
+		n++
+	}
+	block, err := t.l.read(n, time.Second)
+	if err == errTimeout {
+		return 0, nil, false, errBadBlock
+	}
+	if err != nil {
+		return 0, nil, false, err
+	}
+	num, data := block[0], block[2:2+size]
+	ok := block[1] == ^num
+	if crc {
+		ok = ok && crc16(0, data) == uint16(block[2+size])<<8|uint16(block[3+size])
+	} else {
+		ok = ok && checksum(data) == block[2+size]
+	}
+	if !ok {
+		t.l.purge(time.Second)
+		return 0, nil, false, errBadBlock
+	}
+	return num, data, false, nil
+}
+
+// xmodemReceiveData receives the blocks of a file into w, starting it by
+// sending start. Only size bytes are kept if the size is known, and
+// otherwise the padding at the end of the last block is removed.
+func (t *transfer) xmodemReceiveData(w io.Writer, start byte, size int64, ymodem bool) error {
+	crc := start == 'C'
+	reply := start
+	expect := byte(1)
+	errs := 0
+	// last is the last block, held back until the end when the size isn't
+	// known, so its padding can be removed.
+	var last []byte
+	eots := 0
+	if err := t.l.write([]byte{start}); err != nil {
+		return err
+	}
+	for {
+		num, data, isEOT, err := t.xmodemReadBlock(crc, 10*time.Second)
+		switch {
+		case err == errTimeout || err == errBadBlock:
+			if errs++; errs > xmodemRetries {
+				return fmt.Errorf("too many errors")
+			}
+			// Fall back to checksums if the sender doesn't do CRCs.
+			if expect == 1 && !ymodem && reply == 'C' && errs == 3 {
+				crc, reply = false, nak
+			}
+			if err := t.l.write([]byte{reply}); err != nil {
+				return err
+			}
+			continue
+		case err != nil:
+			return err
+		case isEOT:
+			// YMODEM receivers NAK the first EOT, in case it was noise.
+			if eots++; ymodem && eots == 1 {
+				if err := t.l.write([]byte{nak}); err != nil {
+					return err
+				}
+				continue
+			}
+			if size < 0 {
+				last = bytes.TrimRight(last, "\x1a")
+			}
+			if _, err := w.Write(last); err != nil {
+				return err
+			}
+			t.advance(int64(len(last)))
+			return t.l.write([]byte{ack})
+		}
+		eots = 0
+		if num == expect-1 {
+			// The sender missed the ACK and sent the block again.
+			if err := t.l.write([]byte{ack}); err != nil {
+				return err
+			}
+			continue
+		}
+		if num != expect {
+			return fmt.Errorf("got block %d, expected %d", num, expect)
+		}
+		errs, reply = 0, nak
+		expect++
+
+		if size >= 0 {
+			if int64(len(data)) > size {
+				data = data[:size]
+			}
+			size -= int64(len(data))
+		}
+		if _, err := w.Write(last); err != nil {
+			return err
+		}
+		t.advance(int64(len(last)))
+		last = append(last[:0], data...)
+		if err := t.l.write([]byte{ack}); err != nil {
+			return err
+		}
+	}
+}
+
+// xmodemReceive receives a file with XMODEM, saving it as name.
+func (t *transfer) xmodemReceive(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	t.begin(name, -1)
+	err = t.xmodemReceiveData(f, 'C', -1, false)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	t.end()
+	return nil
+}
+
+// ymodemReceive receives files with YMODEM into the transfer's directory,
+// until the sender sends an empty block 0.
+func (t *transfer) ymodemReceive() error {
+	for {
+		var header []byte
+		for errs := 0; header == nil; errs++ {
+			if errs > xmodemRetries {
+				return fmt.Errorf("no file header")
+			}
+			if err := t.l.write([]byte{'C'}); err != nil {
+				return err
+			}
+			num, data, isEOT, err := t.xmodemReadBlock(true, 3*time.Second)
+			switch {
+			case err == errTimeout || err == errBadBlock || isEOT:
+			case err != nil:
+				return err
+			case num != 0:
+				return fmt.Errorf("got block %d, expected a file header", num)
+			default:
+				header = data
+			}
+		}
+		if err := t.l.write([]byte{ack}); err != nil {
+			return err
+		}
+		if header[0] == 0 {
+			return nil
+		}
+
+		name, size, mtime := parseFileInfo(header)
+		f, path, err := createReceived(t.dir, name)
+		if err != nil {
+			return err
+		}
+		t.begin(path, size)
+		err = t.xmodemReceiveData(f, 'C', size, true)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		if !mtime.IsZero() {
+			os.Chtimes(path, mtime, mtime)
+		}
+		t.end()
+	}
+}
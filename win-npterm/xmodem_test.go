@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCRC16(t *testing.T) {
+	// The XMODEM CRC-16 check value.
+	if got := crc16(0, []byte("123456789")); got != 0x31c3 {
+		t.Errorf("crc16 = %#04x, want 0x31c3", got)
+	}
+	if got := crc16(crc16(0, []byte("1234")), []byte("56789")); got != 0x31c3 {
+		t.Errorf("crc16 in two parts = %#04x, want 0x31c3", got)
+	}
+}
+
+func TestParseFileInfo(t *testing.T) {
+	mtime := time.Unix(012345670120, 0)
+	for _, test := range []struct {
+		block string
+		name  string
+		size  int64
+		mtime time.Time
+	}{
+		{"boot.log\x0012345 12345670120 100644 0 1 12345\x00", "boot.log", 12345, mtime},
+		// YMODEM pads block 0 with NULs.
+		{"boot.log\x00100 12345670120\x00\x00\x00\x00", "boot.log", 100, mtime},
+		{"boot.log\x00100\x00", "boot.log", 100, time.Time{}},
+		{"boot.log\x00100 0\x00", "boot.log", 100, time.Time{}},
+		{"boot.log\x00\x00", "boot.log", -1, time.Time{}},
+		{"boot.log\x00junk\x00", "boot.log", -1, time.Time{}},
+		{"boot.log", "boot.log", -1, time.Time{}},
+		{"dir/boot.log\x0010\x00", "dir/boot.log", 10, time.Time{}},
+	} {
+		name, size, mt := parseFileInfo([]byte(test.block))
+		if name != test.name || size != test.size || !mt.Equal(test.mtime) {
+			t.Errorf("parseFileInfo(%q) = %q, %d, %v, want %q, %d, %v", test.block, name, size, mt, test.name, test.size, test.mtime)
+		}
+	}
+}
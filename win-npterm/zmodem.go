@@ -0,0 +1,825 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ZMODEM, as in Chuck Forsberg's "The ZMODEM Inter Application File
+// Transfer Protocol" and as lrzsz's sz and rz speak it.
+
+// Frame types.
+const (
+	zRQINIT = iota
+	zRINIT
+	zSINIT
+	zACK
+	zFILE
+	zSKIP
+	zNAK
+	zABORT
+	zFIN
+	zRPOS
+	zDATA
+	zEOF
+	zFERR
+	zCRC
+	zCHALLENGE
+	zCOMPL
+	zCAN
+	zFREECNT
+	zCOMMAND
+	zSTDERR
+)
+
+const (
+	zPAD   = '*'
+	zDLE   = 0x18
+	zBIN   = 'A' // binary header with a CRC-16
+	zHEX   = 'B' // hex header
+	zBIN32 = 'C' // binary header with a CRC-32
+	// The ends of data subpackets, following a ZDLE.
+	zCRCE = 'h' // the frame ends
+	zCRCG = 'i' // the frame goes on
+	zCRCQ = 'j' // the frame goes on, ZACK expected
+	zCRCW = 'k' // the frame ends, ZACK expected
+	zRUB0 = 'l' // 0x7f
+	zRUB1 = 'm' // 0xff
+)
+
+// ZRINIT capabilities, in ZF0.
+const (
+	canFDX  = 0x01
+	canOVIO = 0x02
+	canFC32 = 0x20
+	escCTL  = 0x40
+)
+
+// zmodemStart is the start of the ZRQINIT sent by a ZMODEM sender, such as
+// sz, which starts a ZMODEM receive.
+const zmodemStart = "**\x18B00"
+
+const (
+	zTimeout = 10 * time.Second
+	// zWindow is how much is sent before waiting for the receiver to
+	// acknowledge it.
+	zWindow = 16384
+	// zBlock is the size of data subpackets sent.
+	zBlock  = 1024
+	zMaxSub = 8192
+	zTries  = 10
+)
+
+var (
+	errBadCRC    = errors.New("bad CRC")
+	errBadEscape = errors.New("bad escape sequence")
+	errTooLong   = errors.New("data subpacket too long")
+)
+
+// zmodem is one end of a ZMODEM transfer.
+type zmodem struct {
+	*transfer
+	// crc32 is whether binary headers and data are sent with CRC-32s, and
+	// rxCRC32 whether the last binary header received was, as the data
+	// following it is.
+	crc32   bool
+	rxCRC32 bool
+	// escCtl escapes all control characters sent, for receivers asking for
+	// it, and lastSent is the last byte sent, as CR is escaped after @.
+	escCtl   bool
+	lastSent byte
+	// window is how much is sent before waiting for a ZACK.
+	window int
+}
+
+func posHeader(pos int64) [4]byte {
+	var h [4]byte
+	binary.LittleEndian.PutUint32(h[:], uint32(pos))
+	return h
+}
+
+func headerPos(h [4]byte) int64 {
+	return int64(binary.LittleEndian.Uint32(h[:]))
+}
+
+// sendHex sends a hex header, as used for the headers not followed by data.
+func (z *zmodem) sendHex(typ byte, h [4]byte) error {
+	b := append([]byte{typ}, h[:]...)
+	s := fmt.Sprintf("**\x18B%x%04x\r\x8a", b, crc16(0, b))
+	// Sent in case an XOFF stopped the other end, other than at the end.
+	if typ != zFIN && typ != zACK {
+		s += "\x11"
+	}
+	return z.l.write([]byte(s))
+}
+
+// escape appends the data to buf, escaping the characters which might be
+// taken for flow control, ZDLE itself and CR after @ (a Telenet escape).
+func (z *zmodem) escape(buf, p []byte) []byte {
+	for _, c := range p {
+		switch {
+		case c == zDLE, c&0x7f == 0x10, c&0x7f == 0x11, c&0x7f == 0x13,
+			c&0x7f == '\r' && z.lastSent&0x7f == '@',
+			z.escCtl && c&0x60 == 0:
+			c ^= 0x40
+			buf = append(buf, zDLE, c)
+		default:
+			buf = append(buf, c)
+		}
+		z.lastSent = c
+	}
+	return buf
+}
+
+// crc returns the CRC of the data, a CRC-32 (least significant byte
+// first) or CRC-16 (most significant first).
+func crc(long bool, p ...[]byte) []byte {
+	if long {
+		var c uint32
+		for _, b := range p {
+			c = crc32.Update(c, crc32.IEEETable, b)
+		}
+		var out [4]byte
+		binary.LittleEndian.PutUint32(out[:], c)
+		return out[:]
+	}
+	var c uint16
+	for _, b := range p {
+		c = crc16(c, b)
+	}
+	return []byte{byte(c >> 8), byte(c)}
+}
+
+// sendBin sends a binary header, as used for those followed by data.
+func (z *zmodem) sendBin(typ byte, h [4]byte) error {
+	buf := []byte{zPAD, zDLE, zBIN}
+	if z.crc32 {
+		buf[2] = zBIN32
+	}
+	b := append([]byte{typ}, h[:]...)
+	buf = z.escape(z.escape(buf, b), crc(z.crc32, b))
+	return z.l.write(buf)
+}
+
+// sendData sends a data subpacket ending with end.
+func (z *zmodem) sendData(p []byte, end byte) error {
+	buf := z.escape(make([]byte, 0, len(p)*2+16), p)
+	buf = append(buf, zDLE, end)
+	buf = z.escape(buf, crc(z.crc32, p, []byte{end}))
+	if end == zCRCW {
+		buf = append(buf, 0x11)
+	}
+	return z.l.write(buf)
+}
+
+// readRaw reads a byte, skipping flow control.
+func (z *zmodem) readRaw(timeout time.Duration) (byte, error) {
+	for {
+		c, err := z.l.readByte(timeout)
+		if err != nil || (c&0x7f != 0x11 && c&0x7f != 0x13) {
+			return c, err
+		}
+	}
+}
+
+// readEscaped reads a byte of a binary header or data subpacket, undoing
+// any escape. For the ZDLE sequence ending a data subpacket, it returns the
+// kind of end with end set.
+func (z *zmodem) readEscaped() (byte, bool, error) {
+	c, err := z.readRaw(zTimeout)
+	if err != nil || c != zDLE {
+		return c, false, err
+	}
+	// Five CANs (which ZDLE is) cancel the transfer.
+	for cans := 1; ; cans++ {
+		c, err := z.readRaw(zTimeout)
+		switch {
+		case err != nil:
+			return 0, false, err
+		case c == can:
+			if cans == 4 {
+				return 0, false, errRemoteCancelled
+			}
+			continue
+		case c == zCRCE, c == zCRCG, c == zCRCQ, c == zCRCW:
+			return c, true, nil
+		case c == zRUB0:
+			return 0x7f, false, nil
+		case c == zRUB1:
+			return 0xff, false, nil
+		case c&0x60 == 0x40:
+			return c ^ 0x40, false, nil
+		}
+		return 0, false, errBadEscape
+	}
+}
+
+// readHeader waits for a header, skipping anything else, and returns its
+// type and data.
+func (z *zmodem) readHeader(timeout time.Duration) (byte, [4]byte, error) {
+	var h [4]byte
+	deadline := time.Now().Add(timeout)
+	cans := 0
+	for {
+		c, err := z.readRaw(time.Until(deadline))
+		if err != nil {
+			return 0, h, err
+		}
+		if c == can {
+			if cans++; cans == 5 {
+				return 0, h, errRemoteCancelled
+			}
+			continue
+		}
+		cans = 0
+		if c != zPAD {
+			continue
+		}
+		for c == zPAD {
+			if c, err = z.readRaw(time.Second); err != nil {
+				return 0, h, err
+			}
+		}
+		if c != zDLE {
+			continue
+		}
+		if c, err = z.readRaw(time.Second); err != nil {
+			return 0, h, err
+		}
+		switch c {
+		case zHEX:
+			return z.readHexHeader()
+		case zBIN, zBIN32:
+			return z.readBinHeader(c == zBIN32)
+		}
+	}
+}
+
+func (z *zmodem) readHexHeader() (byte, [4]byte, error) {
+	var h [4]byte
+	var b [7]byte
+	for i := range b {
+		for j := 0; j < 2; j++ {
+			c, err := z.readRaw(time.Second)
+			if err != nil {
+				return 0, h, err
+			}
+			var v byte
+			switch c &= 0x7f; {
+			case c >= '0' && c <= '9':
+				v = c - '0'
+			case c >= 'a' && c <= 'f':
+				v = c - 'a' + 10
+			case c >= 'A' && c <= 'F':
+				v = c - 'A' + 10
+			default:
+				return 0, h, errBadCRC
+			}
+			b[i] = b[i]<<4 | v
+		}
+	}
+	if crc16(0, b[:5]) != uint16(b[5])<<8|uint16(b[6]) {
+		return 0, h, errBadCRC
+	}
+	// Skip the CR and LF after the header.
+	if c, err := z.l.readByte(100 * time.Millisecond); err == nil && c&0x7f == '\r' {
+		z.l.readByte(100 * time.Millisecond)
+	} else if err == nil {
+		z.l.unread(c)
+	}
+	copy(h[:], b[1:5])
+	return b[0], h, nil
+}
+
+func (z *zmodem) readBinHeader(long bool) (byte, [4]byte, error) {
+	var h [4]byte
+	n := 5 + 2
+	if long {
+		n = 5 + 4
+	}
+	b := make([]byte, n)
+	for i := range b {
+		c, end, err := z.readEscaped()
+		if err != nil {
+			return 0, h, err
+		}
+		if end {
+			return 0, h, errBadEscape
+		}
+		b[i] = c
+	}
+	if !bytes.Equal(b[5:], crc(long, b[:5])) {
+		return 0, h, errBadCRC
+	}
+	z.rxCRC32 = long
+	copy(h[:], b[1:5])
+	return b[0], h, nil
+}
+
+// readData reads a data subpacket, returning it and how it ended.
+func (z *zmodem) readData() ([]byte, byte, error) {
+	var p []byte
+	for {
+		c, end, err := z.readEscaped()
+		if err != nil {
+			return nil, 0, err
+		}
+		if !end {
+			if len(p) == zMaxSub {
+				return nil, 0, errTooLong
+			}
+			p = append(p, c)
+			continue
+		}
+		n := 2
+		if z.rxCRC32 {
+			n = 4
+		}
+		sum := make([]byte, n)
+		for i := range sum {
+			b, isEnd, err := z.readEscaped()
+			if err != nil {
+				return nil, 0, err
+			}
+			if isEnd {
+				return nil, 0, errBadEscape
+			}
+			sum[i] = b
+		}
+		if !bytes.Equal(sum, crc(z.rxCRC32, p, []byte{c})) {
+			return nil, 0, errBadCRC
+		}
+		return p, c, nil
+	}
+}
+
+// recoverable reports whether the error is one the protocol recovers from
+// by asking again.
+func recoverable(err error) bool {
+	return err == errTimeout || err == errBadCRC || err == errBadEscape || err == errTooLong
+}
+
+// zmodemSend sends the files with ZMODEM, starting rz on the other end as
+// sz does.
+func (t *transfer) zmodemSend(names []string) error {
+	z := &zmodem{transfer: t, window: zWindow}
+	if err := t.l.write([]byte("rz\r")); err != nil {
+		return err
+	}
+	if err := z.sendHex(zRQINIT, [4]byte{}); err != nil {
+		return err
+	}
+	if err := z.waitReceiverInit(); err != nil {
+		return err
+	}
+
+	var left int64
+	for _, name := range names {
+		if fi, err := os.Stat(name); err == nil {
+			left += fi.Size()
+		}
+	}
+	for i, name := range names {
+		size, err := z.sendFile(name, len(names)-i, left)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		left -= size
+	}
+
+	for try := 0; try < zTries; try++ {
+		if err := z.sendHex(zFIN, [4]byte{}); err != nil {
+			return err
+		}
+		typ, _, err := z.readHeader(zTimeout)
+		switch {
+		case err == nil && typ == zFIN:
+			return t.l.write([]byte("OO"))
+		case err != nil && !recoverable(err):
+			return err
+		}
+	}
+	return fmt.Errorf("no ZFIN from the receiver")
+}
+
+// waitReceiverInit waits for the receiver's ZRINIT, and takes its
+// capabilities.
+func (z *zmodem) waitReceiverInit() error {
+	for try := 0; try < zTries; try++ {
+		typ, h, err := z.readHeader(zTimeout)
+		switch {
+		case err == errTimeout:
+			if err := z.sendHex(zRQINIT, [4]byte{}); err != nil {
+				return err
+			}
+			continue
+		case recoverable(err):
+			continue
+		case err != nil:
+			return err
+		}
+		switch typ {
+		case zRINIT:
+			z.crc32 = h[3]&canFC32 != 0
+			z.escCtl = h[3]&escCTL != 0
+			if size := int(h[0]) | int(h[1])<<8; size != 0 && size < z.window {
+				z.window = size
+			}
+			return nil
+		case zCHALLENGE:
+			if err := z.sendHex(zACK, h); err != nil {
+				return err
+			}
+		}
+	}
+	return fmt.Errorf("no ZRINIT from the receiver, is rz running?")
+}
+
+// sendFile sends the file, returning its size, unless the receiver skips
+// it.
+func (z *zmodem) sendFile(name string, filesLeft int, bytesLeft int64) (int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	info := []byte(fmt.Sprintf("%s\x00%s 0 %d %d\x00", filepath.Base(name), fileInfo(fi), filesLeft, bytesLeft))
+
+	for try := 0; try < zTries; try++ {
+		// ZCBIN in ZF0: binary, no conversion.
+		if err := z.sendBin(zFILE, [4]byte{0, 0, 0, 1}); err != nil {
+			return 0, err
+		}
+		if err := z.sendData(info, zCRCW); err != nil {
+			return 0, err
+		}
+		typ, h, err := z.readHeader(zTimeout)
+		// A ZCRC asks for the CRC of the file, to compare it with one the
+		// receiver has.
+		for err == nil && typ == zCRC {
+			var sum uint32
+			if sum, err = fileCRC(f); err != nil {
+				return 0, err
+			}
+			if err := z.sendHex(zCRC, posHeader(int64(sum))); err != nil {
+				return 0, err
+			}
+			typ, h, err = z.readHeader(zTimeout)
+		}
+		switch {
+		case recoverable(err):
+			continue
+		case err != nil:
+			return 0, err
+		}
+		switch typ {
+		case zRPOS:
+			z.begin(fi.Name(), fi.Size())
+			if err := z.sendFileData(f, headerPos(h)); err != nil {
+				return 0, err
+			}
+			z.end()
+			return fi.Size(), nil
+		case zSKIP:
+			z.skipped(fi.Name(), "skipped by the receiver")
+			return fi.Size(), nil
+		case zFERR, zABORT:
+			return 0, fmt.Errorf("refused by the receiver")
+		}
+	}
+	return 0, fmt.Errorf("no response from the receiver")
+}
+
+func fileCRC(f *os.File) (uint32, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+// sendFileData sends the file from pos until the receiver has it all,
+// going back to where it asks with a ZRPOS after an error.
+func (z *zmodem) sendFileData(f *os.File, pos int64) error {
+	for try := 0; try < zTries; try++ {
+		end, err := z.streamFile(f, pos)
+		if err != nil {
+			return err
+		}
+		if pos, err = z.sendEOF(end); err != nil || pos < 0 {
+			return err
+		}
+	}
+	return fmt.Errorf("too many errors")
+}
+
+// sendEOF sends ZEOF until the receiver says it has the file, returning
+// -1, or asks for more from a position with a ZRPOS, returning that.
+func (z *zmodem) sendEOF(end int64) (int64, error) {
+	for try := 0; try < zTries; try++ {
+		if err := z.sendBin(zEOF, posHeader(end)); err != nil {
+			return 0, err
+		}
+		typ, h, err := z.readHeader(zTimeout)
+		switch {
+		case recoverable(err):
+			continue
+		case err != nil:
+			return 0, err
+		}
+		switch typ {
+		case zRINIT, zSKIP:
+			return -1, nil
+		case zRPOS:
+			return headerPos(h), nil
+		case zFERR, zABORT:
+			return 0, fmt.Errorf("aborted by the receiver")
+		}
+	}
+	return 0, fmt.Errorf("end of file not acknowledged")
+}
+
+// streamFile sends the file from pos to the end in a data frame, waiting
+// for the receiver to acknowledge each window and going back when it asks
+// with a ZRPOS, and returns the end position.
+func (z *zmodem) streamFile(f *os.File, pos int64) (int64, error) {
+	buf := make([]byte, zBlock)
+	// errs counts errors since the last ZACK, and ZRPOSes asking for the
+	// same position again, as a ZRPOS for a new one is progress.
+	errs := 0
+	lastRPOS := int64(-1)
+	for {
+		if _, err := f.Seek(pos, io.SeekStart); err != nil {
+			return 0, err
+		}
+		z.seek(pos)
+		if err := z.sendBin(zDATA, posHeader(pos)); err != nil {
+			return 0, err
+		}
+		sent := 0
+		restart := false
+		for !restart {
+			n, err := io.ReadFull(f, buf)
+			last := err == io.EOF || err == io.ErrUnexpectedEOF
+			if err != nil && !last {
+				return 0, err
+			}
+			end := byte(zCRCG)
+			switch {
+			case last:
+				end = zCRCE
+			case sent+n >= z.window:
+				end = zCRCW
+			}
+			if err := z.sendData(buf[:n], end); err != nil {
+				return 0, err
+			}
+			pos += int64(n)
+			sent += n
+			z.advance(int64(n))
+
+			if end == zCRCG && !z.l.pending() {
+				continue
+			}
+			if end == zCRCE {
+				return pos, nil
+			}
+			// Wait for the ZACK of the window, or see what the receiver
+			// sent meanwhile, such as a ZRPOS after an error.
+			for {
+				timeout := zTimeout
+				if end == zCRCG {
+					timeout = 0
+				}
+				typ, h, err := z.readHeader(timeout)
+				if err == errTimeout && end == zCRCG {
+					break
+				}
+				if recoverable(err) {
+					if errs++; errs > zTries {
+						return 0, fmt.Errorf("too many errors")
+					}
+					// The header lost was most likely a ZRPOS, or the ZACK,
+					// so start a new frame from here, and a receiver which
+					// missed data asks for it again.
+					restart = true
+					break
+				}
+				if err != nil {
+					return 0, err
+				}
+				switch typ {
+				case zRPOS:
+					pos = headerPos(h)
+					if pos != lastRPOS {
+						errs = 0
+					}
+					if errs++; errs > zTries {
+						return 0, fmt.Errorf("too many errors")
+					}
+					lastRPOS = pos
+					restart = true
+				case zACK:
+					errs = 0
+					if end == zCRCW {
+						// A new frame follows.
+						restart = true
+					}
+				case zSKIP, zRINIT:
+					return pos, nil
+				case zFERR, zABORT:
+					return 0, fmt.Errorf("aborted by the receiver")
+				default:
+					continue
+				}
+				break
+			}
+		}
+	}
+}
+
+// zmodemReceive receives files with ZMODEM into the transfer's directory
+// until the sender finishes. If initiate, it says it's ready at once,
+// rather than waiting for the sender's ZRQINIT.
+func (t *transfer) zmodemReceive(initiate bool) error {
+	z := &zmodem{transfer: t}
+	ready := func() error {
+		return z.sendHex(zRINIT, [4]byte{0, 0, 0, canFDX | canOVIO | canFC32})
+	}
+	if initiate {
+		if err := ready(); err != nil {
+			return err
+		}
+	}
+	errs := 0
+	for {
+		typ, _, err := z.readHeader(zTimeout)
+		if recoverable(err) {
+			if errs++; errs > zTries {
+				return fmt.Errorf("no sender: %v", err)
+			}
+			if err := ready(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		errs = 0
+		switch typ {
+		case zRQINIT:
+			err = ready()
+		case zSINIT:
+			// The attention string is for interrupting the sender, which
+			// never needs doing here.
+			_, _, err = z.readData()
+			switch {
+			case recoverable(err):
+				err = z.sendHex(zNAK, [4]byte{})
+			case err == nil:
+				err = z.sendHex(zACK, posHeader(1))
+			}
+		case zFILE:
+			var info []byte
+			info, _, err = z.readData()
+			switch {
+			case recoverable(err):
+				err = z.sendHex(zNAK, [4]byte{})
+			case err == nil:
+				if err = z.receiveFile(info); err == nil {
+					err = ready()
+				}
+			}
+		case zFIN:
+			if err := z.sendHex(zFIN, [4]byte{}); err != nil {
+				return err
+			}
+			// The sender's "over and out".
+			t.l.read(2, 500*time.Millisecond)
+			return nil
+		case zFREECNT:
+			err = z.sendHex(zACK, [4]byte{})
+		case zCOMMAND:
+			err = fmt.Errorf("the sender asked to run a command, which isn't supported")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// receiveFile receives the file described by the ZFILE data.
+func (z *zmodem) receiveFile(info []byte) error {
+	name, size, mtime := parseFileInfo(info)
+	f, path, err := createReceived(z.dir, name)
+	if err != nil {
+		z.skipped(name, err.Error())
+		return z.sendHex(zSKIP, [4]byte{})
+	}
+	z.begin(path, size)
+	var pos int64
+	err = z.receiveFileData(f, &pos)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if !mtime.IsZero() {
+		os.Chtimes(path, mtime, mtime)
+	}
+	z.end()
+	return nil
+}
+
+func (z *zmodem) receiveFileData(w io.Writer, pos *int64) error {
+	errs := 0
+	retry := func(err error) error {
+		if errs++; errs > zTries {
+			return fmt.Errorf("too many errors, last %v", err)
+		}
+		return z.sendHex(zRPOS, posHeader(*pos))
+	}
+	if err := z.sendHex(zRPOS, posHeader(0)); err != nil {
+		return err
+	}
+	for {
+		typ, h, err := z.readHeader(zTimeout)
+		if recoverable(err) {
+			if err := retry(err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case zDATA:
+			if headerPos(h) != *pos {
+				err = retry(fmt.Errorf("data from %d, expected %d", headerPos(h), *pos))
+				break
+			}
+			if err = z.receiveFrame(w, pos); recoverable(err) {
+				err = retry(err)
+			} else if err == nil {
+				errs = 0
+			}
+		case zEOF:
+			// A ZEOF from before the last ZRPOS is ignored.
+			if headerPos(h) == *pos {
+				return nil
+			}
+		case zFILE:
+			// The sender didn't see the ZRPOS.
+			if _, _, err = z.readData(); err == nil || recoverable(err) {
+				err = z.sendHex(zRPOS, posHeader(*pos))
+			}
+		case zFIN, zABORT, zCAN:
+			return fmt.Errorf("the sender ended the transfer")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// receiveFrame receives the data subpackets of a data frame.
+func (z *zmodem) receiveFrame(w io.Writer, pos *int64) error {
+	for {
+		p, end, err := z.readData()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+		*pos += int64(len(p))
+		z.advance(int64(len(p)))
+		switch end {
+		case zCRCW:
+			return z.sendHex(zACK, posHeader(*pos))
+		case zCRCQ:
+			if err := z.sendHex(zACK, posHeader(*pos)); err != nil {
+				return err
+			}
+		case zCRCE:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestZmodemEscape(t *testing.T) {
+	data := []byte{'a', zDLE, 0x10, 0x11, 0x13, 0x90, 0x91, 0x93, '@', '\r', 0x40 | 0x80, '\r', '\r', 0x00, 0x1b, 0x7f, 0xff, 'z'}
+	for _, escCtl := range []bool{false, true} {
+		z := &zmodem{transfer: newTransfer(nil, "", nil), escCtl: escCtl}
+		escaped := z.escape(nil, data)
+		for _, c := range escaped {
+			if c&0x7f == 0x11 || c&0x7f == 0x13 || c&0x7f == 0x10 {
+				t.Errorf("escCtl %v: %#02x sent unescaped in %q", escCtl, c, escaped)
+			}
+			if escCtl && c&0x60 == 0 && c != zDLE {
+				t.Errorf("escCtl %v: control character %#02x sent unescaped in %q", escCtl, c, escaped)
+			}
+		}
+		if !bytes.Contains(escaped, []byte{'@', zDLE, '\r' ^ 0x40}) {
+			t.Errorf("escCtl %v: CR after @ not escaped in %q", escCtl, escaped)
+		}
+
+		z.feed(append(escaped, zDLE, zCRCW))
+		var got []byte
+		for {
+			c, end, err := z.readEscaped()
+			if err != nil {
+				t.Fatalf("escCtl %v: read %q: %v", escCtl, got, err)
+			}
+			if end {
+				if c != zCRCW {
+					t.Errorf("escCtl %v: ended with %q, want %q", escCtl, c, zCRCW)
+				}
+				break
+			}
+			got = append(got, c)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("escCtl %v: read %q, want %q", escCtl, got, data)
+		}
+	}
+}
+
+func TestZmodemReadEscapedCancel(t *testing.T) {
+	z := &zmodem{transfer: newTransfer(nil, "", nil)}
+	z.feed([]byte{zDLE, can, can, can, can})
+	if _, _, err := z.readEscaped(); err != errRemoteCancelled {
+		t.Errorf("five CANs read as %v", err)
+	}
+}